@@ -0,0 +1,146 @@
+package rediswatcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var errNotSubscribed = errors.New("rediswatcher: UpdateAndWait requires a watcher created with NewWatcher")
+
+// AckError is returned by UpdateAndWait when ctx is done before enough
+// watchers acknowledged the update
+type AckError struct {
+	Acks    int
+	MinAcks int
+	Err     error
+}
+
+func (e *AckError) Error() string {
+	return fmt.Sprintf("rediswatcher: %d of %d acknowledgements: %v", e.Acks, e.MinAcks, e.Err)
+}
+
+// ackWaiter collects the peers that acknowledged an update published with
+// UpdateAndWait
+type ackWaiter struct {
+	mu     sync.Mutex
+	peers  map[string]bool
+	notify chan struct{}
+}
+
+func newAckWaiter() *ackWaiter {
+	return &ackWaiter{peers: make(map[string]bool), notify: make(chan struct{}, 1)}
+}
+
+// add records the acknowledgement of peer and wakes the waiting
+// UpdateAndWait
+func (a *ackWaiter) add(peer string) {
+	a.mu.Lock()
+	a.peers[peer] = true
+	a.mu.Unlock()
+	select {
+	case a.notify <- struct{}{}:
+	default:
+	}
+}
+
+// count returns the number of peers that acknowledged the update
+func (a *ackWaiter) count() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.peers)
+}
+
+// UpdateAndWait publishes an update and blocks until at least minAcks other
+// watchers invoked their update callback for it, for instance so that a
+// deploy script can make sure a policy change propagated. The receivers
+// acknowledge the update on the reply channel of this watcher, which it
+// subscribes to on the first call, so the watcher must be subscribed,
+// created with NewWatcher. With StreamTransport and ShardedPubSub the
+// acknowledgements are sent with the updates instead. Updates requesting
+// acknowledgements are never squashed. If ctx is done first an *AckError is
+// returned.
+func (w *Watcher) UpdateAndWait(ctx context.Context, minAcks int) error {
+	if !w.subscriber {
+		return errNotSubscribed
+	}
+	if w.replies() {
+		if err := w.addChannel(w.replyChannel(w.options.LocalID)); err != nil {
+			return err
+		}
+	}
+	version, err := w.incrVersion()
+	if err != nil {
+		return err
+	}
+	msg := &UpdateMessage{
+		Type:         UpdateMessageType,
+		LocalID:      w.options.LocalID,
+		Version:      version,
+		Payload:      w.options.LocalID,
+		AckRequested: true,
+	}
+	msg.Seq = w.nextSeq(w.messageChannel(msg))
+	// register before publishing, acks may arrive before publish returns
+	id := msg.ID()
+	acks := newAckWaiter()
+	w.acksMu.Lock()
+	w.acks[id] = acks
+	w.acksMu.Unlock()
+	defer func() {
+		w.acksMu.Lock()
+		delete(w.acks, id)
+		w.acksMu.Unlock()
+	}()
+
+	if err := w.publishMessage(ctx, msg); err != nil {
+		return err
+	}
+	for acks.count() < minAcks {
+		select {
+		case <-acks.notify:
+		case <-ctx.Done():
+			return &AckError{Acks: acks.count(), MinAcks: minAcks, Err: ctx.Err()}
+		case <-w.closed:
+			return ErrWatcherClosed
+		}
+	}
+	return nil
+}
+
+// replies reports whether acknowledgements are sent on reply channels. The
+// stream has no per-watcher channels, and sharded channels would have to
+// share a slot with the update channel.
+func (w *Watcher) replies() bool {
+	return w.options.Transport != StreamTransport && !w.options.ShardedPubSub
+}
+
+// replyChannel returns the channel the watcher with localID receives the
+// acknowledgements of its updates on
+func (w *Watcher) replyChannel(localID string) string {
+	return w.options.Channel + ":reply:" + localID
+}
+
+// ack acknowledges an update published with UpdateAndWait
+func (w *Watcher) ack(msg *UpdateMessage) {
+	err := w.publishMessage(context.Background(), &UpdateMessage{Type: AckMessageType, Target: msg.LocalID, Payload: msg.ID()})
+	if err != nil {
+		w.options.Logger.Error("Failure acknowledging update", "channel", w.options.Channel, "localID", w.options.LocalID, "id", msg.ID(), "error", err)
+		w.handleError(err)
+	}
+}
+
+// handleAck passes an acknowledgement of an update published by this watcher
+// to the waiting UpdateAndWait
+func (w *Watcher) handleAck(msg *UpdateMessage) {
+	if msg.Target != w.options.LocalID || msg.LocalID == w.options.LocalID {
+		return
+	}
+	w.acksMu.Lock()
+	acks := w.acks[msg.Payload]
+	w.acksMu.Unlock()
+	if acks != nil {
+		acks.add(msg.LocalID)
+	}
+}
@@ -0,0 +1,138 @@
+package rediswatcher
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestUpdateAndWait(t *testing.T) {
+	c := &publishConn{testConn: NewTestConn()}
+	c.Clear()
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+	if err := rw.UpdateAndWait(context.Background(), 1); err != errNotSubscribed {
+		t.Fatalf("Expected %v, got %v", errNotSubscribed, err)
+	}
+	rw.subscriber = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	result := make(chan error, 1)
+	go func() { result <- rw.UpdateAndWait(ctx, 2) }()
+
+	// wait for UpdateAndWait to register for acknowledgements
+	for registered := false; !registered; time.Sleep(time.Millisecond) {
+		rw.acksMu.Lock()
+		registered = rw.acks["node1:1"] != nil
+		rw.acksMu.Unlock()
+	}
+	ack := func(from string) {
+		rw.processMessage(&UpdateMessage{Type: AckMessageType, LocalID: from, Seq: 1, Target: "node1", Payload: "node1:1"})
+	}
+	ack("node2")
+	ack("node2")
+	ack("node1")
+	select {
+	case err := <-result:
+		t.Fatalf("UpdateAndWait should wait for two other peers, returned %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	ack("node3")
+	if err := <-result; err != nil {
+		t.Fatalf("UpdateAndWait failed: %v", err)
+	}
+	if len(c.published) != 1 {
+		t.Fatalf("Expected one published update, published %v", c.published)
+	}
+	if msg := decodeMessage("/casbin", []byte(c.published[0])); !msg.AckRequested || msg.ID() != "node1:1" {
+		t.Errorf("Published update should request acknowledgements, published %+v", msg)
+	}
+	if !rw.subscribedChannel("/casbin:reply:node1") {
+		t.Error("UpdateAndWait should subscribe to the reply channel")
+	}
+}
+
+func TestUpdateAndWaitManyAcks(t *testing.T) {
+	c := &publishConn{testConn: NewTestConn()}
+	c.Clear()
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+	rw.subscriber = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	result := make(chan error, 1)
+	go func() { result <- rw.UpdateAndWait(ctx, 20) }()
+
+	for registered := false; !registered; time.Sleep(time.Millisecond) {
+		rw.acksMu.Lock()
+		registered = rw.acks["node1:1"] != nil
+		rw.acksMu.Unlock()
+	}
+	// all peers acknowledge before UpdateAndWait gets to count them
+	for i := 0; i < 20; i++ {
+		rw.processMessage(&UpdateMessage{Type: AckMessageType, LocalID: fmt.Sprintf("node%d", i+2), Seq: 1, Target: "node1", Payload: "node1:1"})
+	}
+	if err := <-result; err != nil {
+		t.Fatalf("UpdateAndWait should count every acknowledgement: %v", err)
+	}
+}
+
+func TestUpdateAndWaitTimeout(t *testing.T) {
+	c := &publishConn{testConn: NewTestConn()}
+	c.Clear()
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+	rw.subscriber = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = rw.UpdateAndWait(ctx, 1)
+	if ackErr, ok := err.(*AckError); !ok || ackErr.Acks != 0 || ackErr.Err != context.DeadlineExceeded {
+		t.Errorf("Expected an AckError, got %v", err)
+	}
+}
+
+func TestAckUpdate(t *testing.T) {
+	c := &publishConn{testConn: NewTestConn()}
+	c.Clear()
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node2"),
+		SquashMessages(true))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	var received []string
+	w.SetUpdateCallback(func(msg string) { received = append(received, msg) })
+	update := &UpdateMessage{Type: UpdateMessageType, LocalID: "node1", Seq: 7, Payload: "node1", AckRequested: true}
+	if d := rw.Explain(*update); d != DispositionDelivered {
+		t.Errorf("Updates requesting acknowledgements should not be squashed, got %s", d)
+	}
+	rw.processMessage(update)
+	if len(received) != 1 {
+		t.Fatalf("Callback should be invoked, received %v", received)
+	}
+	if len(c.published) != 1 {
+		t.Fatalf("Update should be acknowledged, published %v", c.published)
+	}
+	if c.channels[0] != "/casbin:reply:node1" {
+		t.Errorf("Update should be acknowledged on the reply channel of the sender, published on %s", c.channels[0])
+	}
+	ack := decodeMessage("/casbin", []byte(c.published[0]))
+	if ack.Type != AckMessageType || ack.Target != "node1" || ack.Payload != "node1:7" || ack.LocalID != "node2" {
+		t.Errorf("Unexpected acknowledgement %+v", ack)
+	}
+}
@@ -0,0 +1,78 @@
+package rediswatcher
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by Update while the CircuitBreaker is open
+var ErrCircuitOpen = errors.New("rediswatcher: circuit open, not publishing")
+
+// circuit breaker states
+const (
+	circuitClosed = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// circuitBreaker fails publishes fast after consecutive failures, letting a
+// single probe through once the cooldown elapsed
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	state     int
+	openedAt  time.Time
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// allow reports whether a publish may be attempted, moving an open breaker
+// whose cooldown elapsed to half-open for a single probe
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case circuitOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = circuitHalfOpen
+		return true
+	case circuitHalfOpen:
+		// the probe is in flight
+		return false
+	}
+	return true
+}
+
+// record records the outcome of an allowed publish and reports whether it
+// opened the breaker
+func (b *circuitBreaker) record(err error) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		b.failures = 0
+		b.state = circuitClosed
+		return false
+	}
+	b.failures++
+	if b.state == circuitHalfOpen || b.failures >= b.threshold {
+		opened := b.state != circuitOpen
+		b.state = circuitOpen
+		b.openedAt = time.Now()
+		return opened
+	}
+	return false
+}
+
+// isClosed reports whether publishes go through normally
+func (b *circuitBreaker) isClosed() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state == circuitClosed
+}
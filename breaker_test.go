@@ -0,0 +1,59 @@
+package rediswatcher
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	b := newCircuitBreaker(2, 20*time.Millisecond)
+	failure := errors.New("connection refused")
+
+	if !b.allow() || b.record(failure) {
+		t.Fatal("First failure should not open the circuit")
+	}
+	if !b.allow() || !b.record(failure) {
+		t.Fatal("Second failure should open the circuit")
+	}
+	if b.allow() {
+		t.Error("Open circuit should not allow publishing")
+	}
+
+	time.Sleep(25 * time.Millisecond)
+	if !b.allow() {
+		t.Fatal("Circuit should allow a probe after the cooldown")
+	}
+	if b.allow() {
+		t.Error("Circuit should allow a single probe")
+	}
+	if !b.record(failure) {
+		t.Error("Failed probe should open the circuit again")
+	}
+
+	time.Sleep(25 * time.Millisecond)
+	b.allow()
+	b.record(nil)
+	if !b.allow() || !b.allow() {
+		t.Error("Successful probe should close the circuit")
+	}
+}
+
+func TestPublishCircuitBreaker(t *testing.T) {
+	c := &flakyConn{testConn: NewTestConn(), failures: 5}
+	c.Clear()
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		WithLogger(&testLogger{}), CircuitBreaker(2, time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+
+	w.Update()
+	w.Update()
+	if err := w.Update(); err != ErrCircuitOpen {
+		t.Errorf("Expected %v, got %v", ErrCircuitOpen, err)
+	}
+	if c.publishes != 2 {
+		t.Errorf("Open circuit should not publish, got %d attempts", c.publishes)
+	}
+}
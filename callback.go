@@ -0,0 +1,299 @@
+package rediswatcher
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+)
+
+const defaultCallbackRetryBackoff = 100 * time.Millisecond
+
+// CallbackError is reported when a callback set with
+// SetUpdateCallbackWithError still fails after CallbackRetries retries
+type CallbackError struct {
+	Data     string
+	Attempts int
+	Err      error
+}
+
+func (e *CallbackError) Error() string {
+	return fmt.Sprintf("rediswatcher: update callback failed after %d attempts: %v", e.Attempts, e.Err)
+}
+
+// CallbackPanicError reports a panic in an update callback. The watcher
+// recovers from it and keeps processing updates.
+type CallbackPanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *CallbackPanicError) Error() string {
+	return fmt.Sprintf("rediswatcher: update callback panicked: %v", e.Value)
+}
+
+// CallbackHandle identifies an update callback added with AddUpdateCallback
+type CallbackHandle uint64
+
+type updateCallback struct {
+	handle   CallbackHandle
+	callback func(context.Context, string)
+}
+
+// SetUpdateCallbackWithContext sets an update callback that receives a
+// context. With CancelSuperseded enabled the context is canceled when a newer
+// update arrives while the callback is still running, so a long reload can
+// abort early in favour of the newer one.
+func (w *Watcher) SetUpdateCallbackWithContext(callback func(context.Context, string)) error {
+	w.callback = callback
+	return nil
+}
+
+// SetUpdateCallbackWithError sets an update callback that returns an error,
+// such as the error of Enforcer.LoadPolicy. Failed invocations are retried
+// CallbackRetries times with an exponential backoff starting at
+// CallbackRetryBackoff. The final failure is passed to the
+// CallbackFailureHandler and the ErrorHandler.
+func (w *Watcher) SetUpdateCallbackWithError(callback func(string) error) error {
+	w.callback = func(ctx context.Context, data string) {
+		w.retryCallback(ctx, data, callback)
+	}
+	return nil
+}
+
+// retryCallback invokes callback until it succeeds, the retries are used up,
+// ctx is canceled or the watcher is closed
+func (w *Watcher) retryCallback(ctx context.Context, data string, callback func(string) error) {
+	backoff := w.options.CallbackRetryBackoff
+	attempts := 0
+	for {
+		startTime := time.Now()
+		err := callback(data)
+		attempts++
+		if w.options.RecordMetrics != nil {
+			w.options.RecordMetrics(w.createMetrics(CallbackMetric, startTime, err))
+		}
+		if err == nil {
+			return
+		}
+		if attempts > w.options.CallbackRetries {
+			err = &CallbackError{Data: data, Attempts: attempts, Err: err}
+			w.options.Logger.Error("Update callback failed", "channel", w.options.Channel, "localID", w.options.LocalID, "error", err)
+			w.handleError(err)
+			if w.options.CallbackFailureHandler != nil {
+				w.options.CallbackFailureHandler(data, err)
+			}
+			return
+		}
+		w.options.Logger.Warn("Update callback failed, retrying", "channel", w.options.Channel, "localID", w.options.LocalID, "attempt", attempts, "backoff", backoff, "error", err)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-w.closed:
+			timer.Stop()
+			return
+		}
+		backoff *= 2
+	}
+}
+
+// deliver invokes the update callback with data and a context derived from
+// ctx. With CancelSuperseded the callback runs on its own goroutine: a newer
+// delivery cancels the context of the invocation in flight and waits for it
+// to return before starting, so the latest update is always applied last.
+func (w *Watcher) deliver(ctx context.Context, data string) {
+	w.deliverFrom(ctx, "", data)
+}
+
+// deliverFrom is deliver for an update published by sender, see
+// OrderedDispatch
+func (w *Watcher) deliverFrom(ctx context.Context, sender string, data string) {
+	w.deliverThen(ctx, sender, data, nil)
+}
+
+// deliverThen is deliverFrom calling after, unless nil, once the callbacks
+// completed. With CancelSuperseded a superseded update hands after on to
+// the update superseding it.
+func (w *Watcher) deliverThen(ctx context.Context, sender string, data string, after func()) {
+	if !w.options.CancelSuperseded || w.options.OrderedDelivery {
+		w.dispatch(sender, func() {
+			w.invokeCallbacks(ctx, data)
+			if after != nil {
+				after()
+			}
+		})
+		return
+	}
+
+	if w.cancelInFlight != nil {
+		w.cancelInFlight()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	previous := w.inFlight
+	// done receives the pending after functions of a superseded update
+	done := make(chan []func(), 1)
+	w.cancelInFlight, w.inFlight = cancel, done
+	var pending []func()
+	if after != nil {
+		pending = append(pending, after)
+	}
+
+	w.background(func() {
+		defer cancel()
+		if previous != nil {
+			pending = append(<-previous, pending...)
+		}
+		if ctx.Err() != nil {
+			// superseded before it started
+			done <- pending
+			if w.options.RecordMetrics != nil {
+				w.options.RecordMetrics(w.createMetrics(SupersededCallbackMetric, time.Now(), ctx.Err()))
+			}
+			return
+		}
+		w.invokeCallbacks(ctx, data)
+		if ctx.Err() != nil {
+			// superseded while running
+			done <- pending
+			return
+		}
+		for _, after := range pending {
+			after()
+		}
+		done <- nil
+	})
+}
+
+// AddUpdateCallback adds a callback invoked on every update after the one set
+// with SetUpdateCallback, so that for instance an observability hook reacts
+// to updates independently of the enforcer reload. The returned handle
+// removes it with RemoveUpdateCallback.
+func (w *Watcher) AddUpdateCallback(callback func(string)) CallbackHandle {
+	handle := CallbackHandle(atomic.AddUint64(&w.lastHandle, 1))
+	w.callbacksMu.Lock()
+	defer w.callbacksMu.Unlock()
+	w.updateCallbacks = append(w.updateCallbacks, updateCallback{
+		handle: handle,
+		callback: func(_ context.Context, data string) {
+			callback(data)
+		},
+	})
+	return handle
+}
+
+// RemoveUpdateCallback removes a callback added with AddUpdateCallback, it
+// reports whether the callback was found
+func (w *Watcher) RemoveUpdateCallback(handle CallbackHandle) bool {
+	w.callbacksMu.Lock()
+	defer w.callbacksMu.Unlock()
+	for i, c := range w.updateCallbacks {
+		if c.handle == handle {
+			w.updateCallbacks = append(w.updateCallbacks[:i:i], w.updateCallbacks[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// hasCallback reports whether an update callback is set or added
+func (w *Watcher) hasCallback() bool {
+	if w.callback != nil {
+		return true
+	}
+	w.callbacksMu.Lock()
+	defer w.callbacksMu.Unlock()
+	return len(w.updateCallbacks) > 0
+}
+
+// invokeCallbacks invokes the update callback and the added callbacks
+func (w *Watcher) invokeCallbacks(ctx context.Context, data string) {
+	if w.callback != nil {
+		w.safeCall(func() { w.callback(ctx, data) })
+	}
+	w.callbacksMu.Lock()
+	callbacks := w.updateCallbacks
+	w.callbacksMu.Unlock()
+	for _, c := range callbacks {
+		callback := c.callback
+		w.safeCall(func() { callback(ctx, data) })
+	}
+}
+
+// safeCall invokes a callback, recovering and reporting a panic so that it
+// doesn't stop the message processor
+func (w *Watcher) safeCall(callback func()) {
+	w.safeHook("Update callback", callback)
+}
+
+// safeHook invokes a callback or message hook like safeCall and reports
+// whether it returned without panicking
+func (w *Watcher) safeHook(name string, hook func()) (ok bool) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		err := &CallbackPanicError{Value: r, Stack: debug.Stack()}
+		w.options.Logger.Error(name+" panicked", "channel", w.options.Channel, "localID", w.options.LocalID, "error", err, "stack", string(err.Stack))
+		w.handleError(err)
+		if w.options.RecordMetrics != nil {
+			w.options.RecordMetrics(w.createMetrics(CallbackPanicMetric, time.Now(), err))
+		}
+	}()
+	hook()
+	return true
+}
+
+// dispatch runs callback on the message processor, or hands it to one of the
+// CallbackWorkers. With OrderedDispatch the callbacks for updates from the
+// same sender always run on the same worker, in order. Panics are recovered,
+// see safeCall.
+func (w *Watcher) dispatch(sender string, callback func()) {
+	if w.work == nil {
+		w.safeCall(callback)
+		return
+	}
+	work := w.work[0]
+	if w.options.OrderedDispatch {
+		h := fnv.New32a()
+		h.Write([]byte(sender))
+		work = w.work[h.Sum32()%uint32(len(w.work))]
+	}
+	select {
+	case work <- callback:
+	case <-w.closed:
+	}
+}
+
+// startWorkers starts the CallbackWorkers, which run callbacks until the
+// watcher is closed. The workers share one queue unless OrderedDispatch
+// gives each its own.
+func (w *Watcher) startWorkers() {
+	queues := 1
+	if w.options.OrderedDispatch {
+		queues = w.options.CallbackWorkers
+	}
+	w.work = nil
+	for i := 0; i < queues; i++ {
+		w.work = append(w.work, make(chan func()))
+	}
+	for i := 0; i < w.options.CallbackWorkers; i++ {
+		work := w.work[i%queues]
+		w.background(func() {
+			for {
+				select {
+				case callback := <-work:
+					w.safeCall(callback)
+				case <-w.closed:
+					return
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package rediswatcher
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCancelSuperseded(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), CancelSuperseded(true))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	started := make(chan string, 3)
+	results := make(chan string, 3)
+	rw.SetUpdateCallbackWithContext(func(ctx context.Context, data string) {
+		started <- data
+		if data == "first" {
+			<-ctx.Done()
+			results <- "first canceled"
+			return
+		}
+		results <- data
+	})
+
+	rw.deliver(context.Background(), "first")
+	<-started
+	rw.deliver(context.Background(), "second")
+	rw.deliver(context.Background(), "third")
+
+	for _, expected := range []string{"first canceled", "third"} {
+		select {
+		case res := <-results:
+			if res != expected {
+				t.Fatalf("Expected '%s', received '%s' instead", expected, res)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for '%s'", expected)
+		}
+	}
+	select {
+	case res := <-results:
+		t.Errorf("Superseded callback should not run, received '%s'", res)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestAddUpdateCallback(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	var calls []string
+	first := rw.AddUpdateCallback(func(msg string) { calls = append(calls, "first:"+msg) })
+	rw.AddUpdateCallback(func(msg string) { calls = append(calls, "second:"+msg) })
+	rw.processMessage(decodeMessage("/casbin", []byte("node2")))
+	if len(calls) != 2 || calls[0] != "first:node2" || calls[1] != "second:node2" {
+		t.Errorf("Both added callbacks should be invoked, received %v", calls)
+	}
+
+	if !rw.RemoveUpdateCallback(first) || rw.RemoveUpdateCallback(first) {
+		t.Error("Callback should be removed exactly once")
+	}
+	w.SetUpdateCallback(func(msg string) { calls = append(calls, "reload:"+msg) })
+	rw.processMessage(decodeMessage("/casbin", []byte("node3")))
+	if len(calls) != 4 || calls[2] != "reload:node3" || calls[3] != "second:node3" {
+		t.Errorf("Update callback and remaining callback should be invoked, received %v", calls)
+	}
+}
+
+func TestCallbackPanic(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	var handled error
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), WithLogger(&testLogger{}),
+		WithErrorHandler(func(err error) { handled = err }))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	reloaded := false
+	w.SetUpdateCallback(func(string) { panic("reload failed") })
+	rw.AddUpdateCallback(func(string) { reloaded = true })
+	rw.processMessage(decodeMessage("/casbin", []byte("node2")))
+
+	if perr, ok := handled.(*CallbackPanicError); !ok || perr.Value != "reload failed" || len(perr.Stack) == 0 {
+		t.Errorf("Panic should be reported to the error handler, received %v", handled)
+	}
+	if !reloaded {
+		t.Error("A panicking callback should not prevent the other callbacks")
+	}
+}
+
+func TestCallbackRetries(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	var failed error
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), WithLogger(&testLogger{}),
+		CallbackRetries(2, time.Millisecond),
+		CallbackFailureHandler(func(data string, err error) { failed = err }))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	attempts := 0
+	rw.SetUpdateCallbackWithError(func(string) error {
+		attempts++
+		if attempts < 2 {
+			return errors.New("database unavailable")
+		}
+		return nil
+	})
+	rw.processMessage(decodeMessage("/casbin", []byte("node2")))
+	if attempts != 2 || failed != nil {
+		t.Errorf("Callback should succeed on the second attempt, received %d attempts and %v", attempts, failed)
+	}
+
+	attempts = 0
+	rw.SetUpdateCallbackWithError(func(string) error {
+		attempts++
+		return errors.New("database unavailable")
+	})
+	rw.processMessage(decodeMessage("/casbin", []byte("node2")))
+	if cerr, ok := failed.(*CallbackError); !ok || attempts != 3 || cerr.Attempts != 3 || cerr.Data != "node2" {
+		t.Errorf("Failure handler should receive the final error after 3 attempts, received %d attempts and %v", attempts, failed)
+	}
+}
+
+func TestCallbackWorkers(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	c.ReceiveWait = true
+	c.Command("SUBSCRIBE", "/casbin").Expect([]interface{}{[]byte("subscribe"), []byte("/casbin"), []byte("1")})
+	c.Command("UNSUBSCRIBE").Expect([]interface{}{[]byte("unsubscribe"), []byte("/casbin"), []byte("0")})
+
+	w, err := NewWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), CallbackWorkers(2))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	defer w.Close()
+	rw := w.(*Watcher)
+
+	release := make(chan struct{})
+	done := make(chan string, 2)
+	rw.Route("slow").SetUpdateCallback(func(string) {
+		<-release
+		done <- "slow"
+	})
+	rw.Route("fast").SetUpdateCallback(func(string) {
+		done <- "fast"
+	})
+
+	rw.processMessage(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Route: "slow"})
+	rw.processMessage(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Route: "fast"})
+	select {
+	case name := <-done:
+		if name != "fast" {
+			t.Errorf("Fast callback should complete first, received '%s'", name)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Callbacks should run concurrently on the workers")
+	}
+	close(release)
+	<-done
+}
+
+func TestOrderedDispatch(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c),
+		CallbackWorkers(4), OrderedDispatch(true))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	defer w.Close()
+	rw := w.(*Watcher)
+	rw.startWorkers()
+
+	received := make(chan string, 100)
+	rw.Route("app").SetUpdateCallback(func(msg string) {
+		time.Sleep(time.Millisecond)
+		received <- msg
+	})
+	for i := 0; i < 20; i++ {
+		rw.processMessage(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Route: "app", Payload: strconv.Itoa(i)})
+	}
+	for i := 0; i < 20; i++ {
+		select {
+		case msg := <-received:
+			if msg != strconv.Itoa(i) {
+				t.Fatalf("Updates of one sender should be applied in order, received %s at %d", msg, i)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Update was not delivered")
+		}
+	}
+}
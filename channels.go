@@ -0,0 +1,213 @@
+package rediswatcher
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+var (
+	errChannelTransport = errors.New("rediswatcher: channels can only be changed with the pub/sub transport")
+	errLastChannel      = errors.New("rediswatcher: can't remove the last channel")
+)
+
+// initChannels sets the channels the watcher subscribes to: Channels if set,
+// otherwise Channel, and the channels of the hosted tenants with
+// TenantChannels
+func (w *Watcher) initChannels() {
+	if len(w.options.Channels) == 0 {
+		w.channels = []string{w.options.Channel}
+	} else {
+		w.channels = append([]string(nil), w.options.Channels...)
+	}
+	if w.options.TenantChannels {
+		for _, tenant := range w.options.Tenants {
+			w.channels = append(w.channels, w.tenantChannel(tenant))
+		}
+	}
+	if w.options.ControlChannel != "" {
+		w.channels = append(w.channels, w.options.ControlChannel)
+	}
+}
+
+// prefixed returns the redis channel name of channel, see ChannelPrefix
+func (w *Watcher) prefixed(channel string) string {
+	return w.options.ChannelPrefix + channel
+}
+
+// subscribeAll subscribes psc to the watcher's channels, or the keyspace
+// notification channel of VersionKey with KeyspaceTransport
+func (w *Watcher) subscribeAll(psc pubSubConn) error {
+	w.channelsMu.Lock()
+	defer w.channelsMu.Unlock()
+	var channels []interface{}
+	if w.options.Transport == KeyspaceTransport {
+		channels = []interface{}{w.keyspaceChannel()}
+	} else {
+		for _, channel := range w.channels {
+			channels = append(channels, w.prefixed(channel))
+		}
+	}
+	if err := psc.Subscribe(channels...); err != nil {
+		return err
+	}
+	w.subscribing = true
+	return nil
+}
+
+// unsubscribeAll unsubscribes psc from all channels
+func (w *Watcher) unsubscribeAll(psc pubSubConn) error {
+	w.channelsMu.Lock()
+	defer w.channelsMu.Unlock()
+	w.subscribing = false
+	return psc.Unsubscribe()
+}
+
+// AddChannel subscribes the watcher to channel in addition to its current
+// channels, for instance when a tenant is added at runtime. If the watcher is
+// reconnecting the channel is subscribed to once it is connected again.
+func (w *Watcher) AddChannel(channel string) error {
+	if w.options.Transport != PubSubTransport {
+		return errChannelTransport
+	}
+	return w.addChannel(channel)
+}
+
+// addChannel subscribes the watcher to channel unless it already is
+func (w *Watcher) addChannel(channel string) error {
+	w.channelsMu.Lock()
+	defer w.channelsMu.Unlock()
+	for _, c := range w.channels {
+		if c == channel {
+			return nil
+		}
+	}
+	w.channels = append(w.channels, channel)
+	if !w.subscribing {
+		return nil
+	}
+	return w.pubSub().Subscribe(w.prefixed(channel))
+}
+
+// RemoveChannel unsubscribes the watcher from channel. Update keeps
+// publishing to Channel, and the last channel can't be removed.
+func (w *Watcher) RemoveChannel(channel string) error {
+	if w.options.Transport != PubSubTransport {
+		return errChannelTransport
+	}
+	w.channelsMu.Lock()
+	defer w.channelsMu.Unlock()
+	for i, c := range w.channels {
+		if c != channel {
+			continue
+		}
+		if len(w.channels) == 1 {
+			return errLastChannel
+		}
+		w.channels = append(w.channels[:i:i], w.channels[i+1:]...)
+		if !w.subscribing {
+			return nil
+		}
+		return w.pubSub().Unsubscribe(w.prefixed(channel))
+	}
+	return nil
+}
+
+// subscribedChannel reports whether channel is one of the watcher's channels
+func (w *Watcher) subscribedChannel(channel string) bool {
+	w.channelsMu.Lock()
+	defer w.channelsMu.Unlock()
+	for _, c := range w.channels {
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// RegisterCallback sets the update callback invoked for updates received on
+// channel in place of the one set with SetUpdateCallback, so that each
+// enforcer or tenant handler only sees its own updates. A nil callback
+// removes the registration.
+func (w *Watcher) RegisterCallback(channel string, callback func(string)) error {
+	w.callbacksMu.Lock()
+	defer w.callbacksMu.Unlock()
+	if callback == nil {
+		delete(w.channelCallbacks, channel)
+		return nil
+	}
+	if w.channelCallbacks == nil {
+		w.channelCallbacks = make(map[string]func(string))
+	}
+	w.channelCallbacks[channel] = callback
+	return nil
+}
+
+// channelCallback returns the callback registered for channel, if any
+func (w *Watcher) channelCallback(channel string) func(string) {
+	w.callbacksMu.Lock()
+	defer w.callbacksMu.Unlock()
+	return w.channelCallbacks[channel]
+}
+
+// flushSquashed invokes the callbacks of the updates held back by squashing
+// with the last update received for each
+func (w *Watcher) flushSquashed() {
+	batch := w.squash.take()
+	var deliveries []func(after func())
+	for route, data := range batch.routes {
+		if callback := w.routeCallback(route); callback != nil {
+			data := data
+			deliveries = append(deliveries, func(after func()) {
+				w.dispatch("", func() {
+					callback(data)
+					after()
+				})
+			})
+		}
+	}
+	for channel, data := range batch.channels {
+		if callback := w.channelCallback(channel); callback != nil {
+			data := data
+			deliveries = append(deliveries, func(after func()) {
+				w.dispatch("", func() {
+					callback(data)
+					after()
+				})
+			})
+		}
+	}
+	for sender, data := range batch.senders {
+		sender, data := sender, data
+		deliveries = append(deliveries, func(after func()) {
+			w.deliverThen(context.Background(), sender, data, after)
+		})
+	}
+	if batch.hasData {
+		deliveries = append(deliveries, func(after func()) {
+			w.deliverThen(context.Background(), "", batch.data, after)
+		})
+	}
+
+	// the stream entries of the batch are acknowledged once all of its
+	// callbacks completed
+	after := afterAll(len(deliveries), func() { w.ackStreams(batch.streamIDs) })
+	for _, deliver := range deliveries {
+		deliver(after)
+	}
+}
+
+// afterAll returns a function calling fn once it was itself called n times,
+// fn is called right away if n is 0
+func afterAll(n int, fn func()) func() {
+	if n == 0 {
+		fn()
+		return func() {}
+	}
+	remaining := int32(n)
+	return func() {
+		if atomic.AddInt32(&remaining, -1) == 0 {
+			fn()
+		}
+	}
+}
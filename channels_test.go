@@ -0,0 +1,157 @@
+package rediswatcher
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChannels(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	c.ReceiveWait = true
+	c.Command("SUBSCRIBE", "/casbin/app1", "/casbin/app2").
+		Expect([]interface{}{[]byte("subscribe"), []byte("/casbin/app1"), []byte("1")}).
+		Expect([]interface{}{[]byte("subscribe"), []byte("/casbin/app2"), []byte("2")})
+	c.Command("UNSUBSCRIBE").Expect([]interface{}{[]byte("unsubscribe"), []byte("/casbin/app1"), []byte("0")})
+	c.Command("PUBLISH", "/casbin/app1", "node1").Expect("1")
+	c.AddSubscriptionMessage([]interface{}{[]byte("message"), []byte("/casbin/app2"), []byte("node2")})
+
+	w, err := NewWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		Channels([]string{"/casbin/app1", "/casbin/app2"}), VerifyChannel(true))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	defer w.Close()
+
+	ch := make(chan string, 1)
+	w.SetUpdateCallback(func(msg string) { ch <- msg })
+	go func() {
+		c.ReceiveNow <- true
+		c.ReceiveNow <- true
+	}()
+
+	select {
+	case msg := <-ch:
+		if msg != "node2" {
+			t.Errorf("Callback should receive 'node2', received '%s' instead", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Message on the second channel was not delivered")
+	}
+
+	if err := w.Update(); err != nil {
+		t.Fatalf("Update should publish to the first channel: %v", err)
+	}
+}
+
+func TestRegisterCallback(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c),
+		Channels([]string{"/casbin/app1", "/casbin/app2"}))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	var app1, other []string
+	w.SetUpdateCallback(func(msg string) { other = append(other, msg) })
+	rw.RegisterCallback("/casbin/app1", func(msg string) { app1 = append(app1, msg) })
+
+	rw.processMessage(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Payload: "a", Channel: "/casbin/app1"})
+	rw.processMessage(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Payload: "b", Channel: "/casbin/app2"})
+	if len(app1) != 1 || app1[0] != "a" || len(other) != 1 || other[0] != "b" {
+		t.Errorf("Updates should be routed by channel, received %v and %v", app1, other)
+	}
+
+	rw.options.SquashMessages = true
+	rw.processMessage(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Payload: "c", Channel: "/casbin/app1"})
+	rw.processMessage(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Payload: "d", Channel: "/casbin/app1"})
+	rw.flushSquashed()
+	if len(app1) != 2 || app1[1] != "d" || len(other) != 1 {
+		t.Errorf("Squashed updates should only reach their channel's callback, received %v and %v", app1, other)
+	}
+
+	rw.RegisterCallback("/casbin/app1", nil)
+	rw.processMessage(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Payload: "e", Channel: "/casbin/app1"})
+	rw.flushSquashed()
+	if len(other) != 2 || other[1] != "e" {
+		t.Errorf("Unregistered channel should use the default callback, received %v", other)
+	}
+}
+
+func TestAddRemoveChannel(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), VerifyChannel(true))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	if err := rw.RemoveChannel("/casbin"); err != errLastChannel {
+		t.Errorf("Removing the last channel should fail, received %v", err)
+	}
+	if err := rw.AddChannel("/tenant1"); err != nil {
+		t.Fatalf("Failed to add channel: %v", err)
+	}
+	if d := rw.Explain(UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Channel: "/tenant1"}); d != DispositionDelivered {
+		t.Errorf("Message on an added channel should be delivered, received '%s' instead", d)
+	}
+
+	// a subscribed watcher adjusts the live subscription
+	rw.subscribing = true
+	subscribe := c.Command("SUBSCRIBE", "/tenant2").Expect([]interface{}{[]byte("subscribe"), []byte("/tenant2"), []byte("3")})
+	unsubscribe := c.Command("UNSUBSCRIBE", "/tenant1").Expect([]interface{}{[]byte("unsubscribe"), []byte("/tenant1"), []byte("2")})
+	if err := rw.AddChannel("/tenant2"); err != nil {
+		t.Fatalf("Failed to add channel: %v", err)
+	}
+	if err := rw.RemoveChannel("/tenant1"); err != nil {
+		t.Fatalf("Failed to remove channel: %v", err)
+	}
+	c.Receive()
+	c.Receive()
+	if c.Stats(subscribe) != 1 || c.Stats(unsubscribe) != 1 {
+		t.Error("Adding and removing channels should SUBSCRIBE and UNSUBSCRIBE")
+	}
+	if d := rw.Explain(UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Channel: "/tenant1"}); d != DispositionRejected {
+		t.Errorf("Message on a removed channel should be rejected, received '%s' instead", d)
+	}
+}
+
+func TestChannelPrefix(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	c.ReceiveWait = true
+	c.Command("SUBSCRIBE", "prod:/casbin").Expect([]interface{}{[]byte("subscribe"), []byte("prod:/casbin"), []byte("1")})
+	c.Command("UNSUBSCRIBE").Expect([]interface{}{[]byte("unsubscribe"), []byte("prod:/casbin"), []byte("0")})
+	c.Command("PUBLISH", "prod:/casbin", "node1").Expect("1")
+	c.AddSubscriptionMessage([]interface{}{[]byte("message"), []byte("prod:/casbin"), []byte("node2")})
+
+	w, err := NewWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		ChannelPrefix("prod:"), VerifyChannel(true))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	defer w.Close()
+
+	ch := make(chan string, 1)
+	w.SetUpdateCallback(func(msg string) { ch <- msg })
+	go func() {
+		c.ReceiveNow <- true
+		c.ReceiveNow <- true
+	}()
+
+	select {
+	case msg := <-ch:
+		if msg != "node2" {
+			t.Errorf("Callback should receive 'node2', received '%s' instead", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Message on the prefixed channel was not delivered")
+	}
+
+	if err := w.Update(); err != nil {
+		t.Fatalf("Update should publish to the prefixed channel: %v", err)
+	}
+}
@@ -0,0 +1,153 @@
+package rediswatcher
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// ChunkMessageType is the type of envelopes carrying a piece of a message
+// larger than MaxMessageSize, see ChunkMessages
+const ChunkMessageType = "chunk"
+
+const (
+	// partial messages are dropped when their remaining chunks don't arrive
+	// within chunkTimeout
+	chunkTimeout = time.Minute
+	// messages are split into at most maxChunks chunks, chunks claiming a
+	// larger count are dropped
+	maxChunks = 1024
+	// at most maxPartialMessages messages are reassembled at a time, the
+	// oldest is dropped to make room for another
+	maxPartialMessages = 64
+)
+
+// ErrMessageTooLarge is returned when publishing a message larger than
+// MaxMessageSize
+var ErrMessageTooLarge = errors.New("rediswatcher: message exceeds the maximum message size")
+
+// MessageChunk locates a chunk within the message it is part of
+type MessageChunk struct {
+	ID    string `json:"id"`
+	Index int    `json:"index"`
+	Count int    `json:"count"`
+}
+
+type partialMessage struct {
+	parts     []string
+	received  int
+	started   time.Time
+	streamIDs []string
+}
+
+// chunkSize returns how much of a message fits into one chunk. Chunks are
+// base64 encoded and, with encryption, encoded once more, so the size leaves
+// room for both and for the envelope.
+func (w *Watcher) chunkSize() int {
+	size := w.options.MaxMessageSize - 512
+	if w.options.KeyProvider != nil {
+		size = size * 3 / 4
+	}
+	return size * 3 / 4
+}
+
+// publishChunks publishes the encoded message data as a series of chunks
+func (w *Watcher) publishChunks(msg *UpdateMessage, data []byte) error {
+	size := w.chunkSize()
+	if size <= 0 {
+		return ErrMessageTooLarge
+	}
+	count := (len(data) + size - 1) / size
+	if count > maxChunks {
+		return ErrMessageTooLarge
+	}
+	// only updates carry a sequence number, chunks are numbered on their own
+	id := strconv.FormatUint(atomic.AddUint64(&w.chunkSeq, 1), 10)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * size
+		if end > len(data) {
+			end = len(data)
+		}
+		chunk, err := w.encode(&UpdateMessage{
+			Type:    ChunkMessageType,
+			LocalID: msg.LocalID,
+			Chunk:   &MessageChunk{ID: id, Index: i, Count: count},
+			Payload: base64.StdEncoding.EncodeToString(data[i*size : end]),
+		})
+		if err != nil {
+			return err
+		}
+		if msg.receivers, err = w.publish(w.messageChannel(msg), string(chunk), msg.ID()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addChunk buffers a received chunk and processes the message once all of
+// its chunks arrived
+func (w *Watcher) addChunk(chunk *UpdateMessage) {
+	c := chunk.Chunk
+	if c == nil || c.Count <= 0 || c.Count > maxChunks || c.Index < 0 || c.Index >= c.Count {
+		w.ackStreams(chunk.streamIDs)
+		return
+	}
+	now := time.Now()
+	if w.chunks == nil {
+		w.chunks = make(map[string]*partialMessage)
+	}
+	for key, partial := range w.chunks {
+		if now.Sub(partial.started) > chunkTimeout {
+			delete(w.chunks, key)
+		}
+	}
+
+	key := chunk.LocalID + ":" + c.ID
+	partial, ok := w.chunks[key]
+	if !ok {
+		if len(w.chunks) >= maxPartialMessages {
+			w.dropOldestPartial()
+		}
+		partial = &partialMessage{parts: make([]string, c.Count), started: now}
+		w.chunks[key] = partial
+	}
+	if c.Count != len(partial.parts) || partial.parts[c.Index] != "" {
+		w.ackStreams(chunk.streamIDs)
+		return
+	}
+	partial.parts[c.Index] = chunk.Payload
+	partial.received++
+	partial.streamIDs = append(partial.streamIDs, chunk.streamIDs...)
+	if partial.received < len(partial.parts) {
+		return
+	}
+	delete(w.chunks, key)
+
+	var data []byte
+	for _, part := range partial.parts {
+		decoded, err := base64.StdEncoding.DecodeString(part)
+		if err != nil {
+			w.handleError(err)
+			w.ackStreams(partial.streamIDs)
+			return
+		}
+		data = append(data, decoded...)
+	}
+	msg := w.decode(chunk.Channel, data)
+	msg.streamIDs = partial.streamIDs
+	w.processMessage(msg)
+}
+
+// dropOldestPartial drops the partial message that started first. Its
+// stream entries stay pending, like those of partial messages timing out.
+func (w *Watcher) dropOldestPartial() {
+	var oldest string
+	for key, partial := range w.chunks {
+		if oldest == "" || partial.started.Before(w.chunks[oldest].started) {
+			oldest = key
+		}
+	}
+	delete(w.chunks, oldest)
+}
@@ -0,0 +1,105 @@
+package rediswatcher
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// publishConn records the messages published on it
+type publishConn struct {
+	*testConn
+	channels  []string
+	published []string
+}
+
+func (c *publishConn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	if commandName == "PUBLISH" {
+		c.channels = append(c.channels, args[0].(string))
+		c.published = append(c.published, args[1].(string))
+		return int64(1), nil
+	}
+	return c.testConn.Do(commandName, args...)
+}
+
+func TestChunkMessages(t *testing.T) {
+	c := &publishConn{testConn: NewTestConn()}
+	c.Clear()
+
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		MaxMessageSize(1024), EncryptionKey([]byte("0123456789abcdef")))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	payload := strings.Repeat("p, alice, <data1>, read\n", 200)
+	if err := rw.publishMessage(context.Background(), &UpdateMessage{Type: UpdateMessageType, Payload: payload}); err != ErrMessageTooLarge {
+		t.Fatalf("Oversized message should be rejected, received %v", err)
+	}
+
+	rw.options.ChunkMessages = true
+	if err := rw.publishMessage(context.Background(), &UpdateMessage{Type: UpdateMessageType, Payload: payload}); err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+	if len(c.published) < 2 {
+		t.Fatalf("Message should be published in chunks, published %d messages", len(c.published))
+	}
+
+	receiver, _ := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node2"),
+		EncryptionKey([]byte("0123456789abcdef")))
+	rr := receiver.(*Watcher)
+	var received string
+	receiver.SetUpdateCallback(func(s string) { received = s })
+
+	// deliver the chunks in reverse order
+	for i := len(c.published) - 1; i >= 0; i-- {
+		if len(c.published[i]) > 1024 {
+			t.Fatalf("Chunk of %d bytes exceeds the maximum message size", len(c.published[i]))
+		}
+		msg, err := rr.open("/casbin", []byte(c.published[i]))
+		if err != nil {
+			t.Fatalf("Failed to decrypt chunk: %v", err)
+		}
+		rr.processMessage(msg)
+	}
+	if received != payload {
+		t.Errorf("Reassembled payload should be delivered, received %d bytes", len(received))
+	}
+}
+
+func TestChunkBounds(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	rw.addChunk(&UpdateMessage{Type: ChunkMessageType, LocalID: "node2", Chunk: &MessageChunk{ID: "1", Index: 0, Count: 1 << 30}})
+	if len(rw.chunks) != 0 {
+		t.Errorf("Chunk with an excessive count should be dropped, holding %d partial messages", len(rw.chunks))
+	}
+
+	for i := 0; i < maxPartialMessages; i++ {
+		rw.addChunk(&UpdateMessage{Type: ChunkMessageType, LocalID: "node2", Chunk: &MessageChunk{ID: strconv.Itoa(i), Index: 0, Count: 2}})
+	}
+	rw.chunks["node2:0"].started = time.Now().Add(-time.Second)
+	rw.addChunk(&UpdateMessage{Type: ChunkMessageType, LocalID: "node2", Chunk: &MessageChunk{ID: "last", Index: 0, Count: 2}})
+	if len(rw.chunks) != maxPartialMessages {
+		t.Errorf("Expected %d partial messages, holding %d", maxPartialMessages, len(rw.chunks))
+	}
+	if rw.chunks["node2:0"] != nil || rw.chunks["node2:last"] == nil {
+		t.Error("The oldest partial message should be dropped")
+	}
+
+	rw.options.MaxMessageSize = 1024
+	rw.options.ChunkMessages = true
+	payload := strings.Repeat("x", maxChunks*rw.chunkSize())
+	if err := rw.publishMessage(context.Background(), &UpdateMessage{Type: UpdateMessageType, Payload: payload}); err != ErrMessageTooLarge {
+		t.Errorf("Message needing more than %d chunks should be rejected, received %v", maxChunks, err)
+	}
+}
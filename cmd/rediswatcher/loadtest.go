@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	rediswatcher "github.com/billcobbler/casbin-redis-watcher/v2"
+	"github.com/garyburd/redigo/redis"
+)
+
+const loadTestPrefix = "loadtest:"
+
+type loadTestResult struct {
+	mu        sync.Mutex
+	latencies []time.Duration
+}
+
+func (r *loadTestResult) record(data string) {
+	if !strings.HasPrefix(data, loadTestPrefix) {
+		return
+	}
+	fields := strings.SplitN(strings.TrimPrefix(data, loadTestPrefix), ":", 2)
+	sent, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return
+	}
+	latency := time.Since(time.Unix(0, sent))
+
+	r.mu.Lock()
+	r.latencies = append(r.latencies, latency)
+	r.mu.Unlock()
+}
+
+type loadTestConfig struct {
+	addr        string
+	username    string
+	password    string
+	channel     string
+	rate        int
+	size        int
+	duration    time.Duration
+	subscribers int
+	drain       time.Duration
+	rounds      int
+	timeout     time.Duration
+}
+
+func parseLoadTestFlags(args []string) (*loadTestConfig, error) {
+	config := &loadTestConfig{}
+	fs := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	fs.StringVar(&config.addr, "addr", "127.0.0.1:6379", "redis address")
+	fs.StringVar(&config.username, "username", "", "redis username")
+	fs.StringVar(&config.password, "password", "", "redis password")
+	fs.StringVar(&config.channel, "channel", "/casbin-loadtest", "channel to publish on, use a dedicated channel")
+	fs.IntVar(&config.rate, "rate", 100, "messages published per second")
+	fs.IntVar(&config.size, "size", 64, "message size in bytes")
+	fs.DurationVar(&config.duration, "duration", 10*time.Second, "how long to publish for")
+	fs.IntVar(&config.subscribers, "subscribers", 1, "number of local watchers receiving the messages")
+	fs.DurationVar(&config.drain, "drain", 2*time.Second, "how long to wait for messages after publishing stops")
+	fs.IntVar(&config.rounds, "rounds", 10, "number of updates published with UpdateAndWait to measure propagation to all subscribers, 0 to skip")
+	fs.DurationVar(&config.timeout, "timeout", 5*time.Second, "how long to wait for the acknowledgements of an update")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// the ticker interval is time.Second/rate, it must be at least 1ns
+	if config.rate <= 0 || config.rate > int(time.Second) {
+		return nil, fmt.Errorf("rate must be between 1 and %d, got %d", int(time.Second), config.rate)
+	}
+	if config.subscribers <= 0 {
+		return nil, fmt.Errorf("subscribers must be positive, got %d", config.subscribers)
+	}
+	if config.duration <= 0 {
+		return nil, fmt.Errorf("duration must be positive, got %v", config.duration)
+	}
+	if config.size < 0 || config.rounds < 0 || config.drain < 0 {
+		return nil, errors.New("size, rounds and drain must not be negative")
+	}
+	if config.rounds > 0 && config.timeout <= 0 {
+		return nil, fmt.Errorf("timeout must be positive, got %v", config.timeout)
+	}
+	return config, nil
+}
+
+func loadTest(args []string) error {
+	config, err := parseLoadTestFlags(args)
+	if err == flag.ErrHelp {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	options := []rediswatcher.WatcherOption{
+		rediswatcher.Channel(config.channel),
+		rediswatcher.Username(config.username),
+		rediswatcher.Password(config.password),
+		rediswatcher.BlockUntilSubscribed(true),
+	}
+
+	result := &loadTestResult{}
+	for i := 0; i < config.subscribers; i++ {
+		w, err := rediswatcher.NewWatcher(config.addr, options...)
+		if err != nil {
+			return fmt.Errorf("subscriber %d: %v", i, err)
+		}
+		defer w.Close()
+		w.SetUpdateCallback(result.record)
+	}
+
+	c, err := redis.Dial("tcp", config.addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if config.password != "" {
+		user := config.username
+		if user == "" {
+			user = "default"
+		}
+		if _, err := c.Do("AUTH", user, config.password); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "publishing %d msg/s of %d bytes to %s for %v\n", config.rate, config.size, config.channel, config.duration)
+
+	ticker := time.NewTicker(time.Second / time.Duration(config.rate))
+	defer ticker.Stop()
+	deadline := time.After(config.duration)
+	start := time.Now()
+	sent, failed := 0, 0
+
+publish:
+	for {
+		select {
+		case <-deadline:
+			break publish
+		case <-ticker.C:
+			if _, err := c.Do("PUBLISH", config.channel, loadTestMessage(config.size)); err != nil {
+				failed++
+				continue
+			}
+			sent++
+		}
+	}
+	elapsed := time.Since(start)
+	time.Sleep(config.drain)
+
+	result.mu.Lock()
+	report(sent, failed, config.subscribers, elapsed, result.latencies)
+	result.mu.Unlock()
+
+	if config.rounds == 0 {
+		return nil
+	}
+	publisher, err := rediswatcher.NewWatcher(config.addr, options...)
+	if err != nil {
+		return fmt.Errorf("publisher: %v", err)
+	}
+	defer publisher.Close()
+	propagation, failed := propagate(publisher.(*rediswatcher.Watcher), config.rounds, config.subscribers, config.timeout)
+	reportPropagation(len(propagation)+failed, failed, propagation)
+	return nil
+}
+
+// propagate publishes rounds updates with UpdateAndWait and returns how long
+// each took until all subscribers invoked their callback, and the number of
+// updates that timed out
+func propagate(w *rediswatcher.Watcher, rounds, subscribers int, timeout time.Duration) ([]time.Duration, int) {
+	var latencies []time.Duration
+	failed := 0
+	for i := 0; i < rounds; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		start := time.Now()
+		err := w.UpdateAndWait(ctx, subscribers)
+		cancel()
+		if err != nil {
+			failed++
+			continue
+		}
+		latencies = append(latencies, time.Since(start))
+	}
+	return latencies, failed
+}
+
+func loadTestMessage(size int) string {
+	msg := loadTestPrefix + strconv.FormatInt(time.Now().UnixNano(), 10) + ":"
+	if pad := size - len(msg); pad > 0 {
+		msg += strings.Repeat("x", pad)
+	}
+	return msg
+}
+
+func report(sent, failed, subscribers int, elapsed time.Duration, latencies []time.Duration) {
+	expected := sent * subscribers
+	fmt.Printf("published:   %d (%d failed) in %v, %.1f msg/s\n", sent, failed, elapsed.Round(time.Millisecond), float64(sent)/elapsed.Seconds())
+	fmt.Printf("received:    %d of %d expected", len(latencies), expected)
+	if expected > 0 {
+		fmt.Printf(" (%.2f%% lost)", 100*float64(expected-len(latencies))/float64(expected))
+	}
+	fmt.Println()
+	printLatencies("latency", latencies)
+}
+
+func reportPropagation(updates, failed int, latencies []time.Duration) {
+	fmt.Printf("propagated:  %d of %d updates to all subscribers (%d timed out)\n", len(latencies), updates, failed)
+	printLatencies("propagation", latencies)
+}
+
+// printLatencies prints the percentiles of latencies, sorting them in place
+func printLatencies(name string, latencies []time.Duration) {
+	if len(latencies) == 0 {
+		return
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	percentile := func(p float64) time.Duration {
+		return latencies[int(p*float64(len(latencies)-1))]
+	}
+	fmt.Printf("%-12s %v\n", name+" p50:", percentile(0.50))
+	fmt.Printf("%-12s %v\n", name+" p90:", percentile(0.90))
+	fmt.Printf("%-12s %v\n", name+" p99:", percentile(0.99))
+	fmt.Printf("%-12s %v\n", name+" max:", latencies[len(latencies)-1])
+}
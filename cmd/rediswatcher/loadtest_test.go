@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	rediswatcher "github.com/billcobbler/casbin-redis-watcher/v2"
+	"github.com/rafaeljusto/redigomock"
+)
+
+func TestParseLoadTestFlags(t *testing.T) {
+	config, err := parseLoadTestFlags([]string{"-rate", "1000", "-subscribers", "3"})
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if config.rate != 1000 || config.subscribers != 3 || config.rounds != 10 {
+		t.Errorf("Unexpected config %+v", config)
+	}
+
+	for _, args := range [][]string{
+		{"-rate", "0"},
+		{"-rate", "-5"},
+		// time.Second/rate would be 0 and panic in time.NewTicker
+		{"-rate", "2000000000"},
+		{"-subscribers", "0"},
+		{"-duration", "0s"},
+		{"-size", "-1"},
+		{"-rounds", "-1"},
+		{"-timeout", "0s"},
+	} {
+		if _, err := parseLoadTestFlags(args); err == nil {
+			t.Errorf("Expected an error for %v", args)
+		}
+	}
+	if _, err := parseLoadTestFlags([]string{"-rounds", "0", "-timeout", "0s"}); err != nil {
+		t.Errorf("The timeout should only be required with rounds: %v", err)
+	}
+}
+
+func TestLoadTestMessage(t *testing.T) {
+	msg := loadTestMessage(100)
+	if len(msg) != 100 || !strings.HasPrefix(msg, loadTestPrefix) {
+		t.Errorf("Expected a message of 100 bytes, received '%s'", msg)
+	}
+
+	result := &loadTestResult{}
+	result.record(msg)
+	result.record("node1")
+	if len(result.latencies) != 1 {
+		t.Fatalf("Expected 1 latency, received %d", len(result.latencies))
+	}
+	if result.latencies[0] < 0 || result.latencies[0] > time.Minute {
+		t.Errorf("Unexpected latency %v", result.latencies[0])
+	}
+}
+
+func TestPropagate(t *testing.T) {
+	c := redigomock.NewConn()
+	w, err := rediswatcher.NewPublishWatcher("", rediswatcher.WithRedisPubConnection(c), rediswatcher.WithRedisSubConnection(c))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	defer w.Close()
+
+	// a publish-only watcher can't receive acknowledgements
+	latencies, failed := propagate(w.(*rediswatcher.Watcher), 3, 1, time.Second)
+	if len(latencies) != 0 || failed != 3 {
+		t.Errorf("Expected 3 failed updates, received %d latencies and %d failures", len(latencies), failed)
+	}
+}
@@ -0,0 +1,51 @@
+// Command rediswatcher is an operator tool for casbin-redis-watcher
+// deployments.
+//
+// Usage:
+//
+//	rediswatcher loadtest [flags]
+//
+// The loadtest subcommand publishes messages at a configurable rate and size
+// and measures how long they take to reach a set of local watchers, so that a
+// Redis and watcher configuration can be validated against policy-freshness
+// targets before rolling it out. It then publishes updates with
+// UpdateAndWait to measure how long they take until every watcher invoked
+// its callback.
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "loadtest":
+		err = loadTest(os.Args[2:])
+	case "help", "-h", "-help", "--help":
+		usage()
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: rediswatcher <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "commands:")
+	fmt.Fprintln(os.Stderr, "  loadtest  publish updates and measure propagation latency")
+}
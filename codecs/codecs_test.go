@@ -0,0 +1,54 @@
+package codecs
+
+import (
+	"reflect"
+	"testing"
+
+	rediswatcher "github.com/billcobbler/casbin-redis-watcher/v2"
+	"github.com/billcobbler/casbin-redis-watcher/v2/codecs/msgpack"
+	"github.com/billcobbler/casbin-redis-watcher/v2/codecs/protobuf"
+)
+
+func TestCodecs(t *testing.T) {
+	codecs := map[string]rediswatcher.Codec{
+		"json":     rediswatcher.JSONCodec,
+		"msgpack":  msgpack.Codec,
+		"protobuf": protobuf.Codec,
+	}
+	msg := &rediswatcher.UpdateMessage{
+		Schema:       rediswatcher.MessageSchemaVersion,
+		Type:         rediswatcher.UpdateMessageType,
+		LocalID:      "node1",
+		Seq:          42,
+		Tenant:       "tenant1",
+		Version:      7,
+		Payload:      "p, alice, data1, read",
+		Filter:       `{"P":["alice"]}`,
+		Domains:      []string{"domain1", "domain2"},
+		Route:        "app1",
+		AckRequested: true,
+		Command:      "reload-all",
+		Group:        "app1",
+		Chunk:        &rediswatcher.MessageChunk{ID: "42", Index: 1, Count: 3},
+		Trace:        map[string]string{"traceparent": "00-trace-span-01"},
+	}
+
+	for name, codec := range codecs {
+		data, err := codec.Marshal(msg)
+		if err != nil {
+			t.Fatalf("%s: failed to marshal: %v", name, err)
+		}
+		decoded := &rediswatcher.UpdateMessage{}
+		if err := codec.Unmarshal(data, decoded); err != nil {
+			t.Fatalf("%s: failed to unmarshal: %v", name, err)
+		}
+		if !reflect.DeepEqual(decoded, msg) {
+			t.Errorf("%s: decoded message should be %+v, received %+v", name, msg, decoded)
+		}
+
+		bare := &rediswatcher.UpdateMessage{}
+		if err := codec.Unmarshal([]byte("4e3b5f0c-6b8d-4f1e-9a3c-2d7e1b9f0a6c"), bare); err == nil && bare.Type != "" {
+			t.Errorf("%s: bare LocalID should not decode as an envelope", name)
+		}
+	}
+}
@@ -0,0 +1,3 @@
+// Package codecs holds alternative rediswatcher.Codec implementations for
+// the message envelope: msgpack and protobuf.
+package codecs
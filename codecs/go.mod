@@ -0,0 +1,21 @@
+module github.com/billcobbler/casbin-redis-watcher/v2/codecs
+
+go 1.24
+
+require (
+	github.com/billcobbler/casbin-redis-watcher/v2 v2.1.0
+	github.com/vmihailenco/msgpack/v5 v5.4.1
+	google.golang.org/protobuf v1.36.10
+)
+
+require (
+	github.com/Knetic/govaluate v3.0.1-0.20171022003610-9aa49832a739+incompatible // indirect
+	github.com/casbin/casbin/v2 v2.1.0 // indirect
+	github.com/garyburd/redigo v1.6.0 // indirect
+	github.com/google/uuid v1.1.1 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+)
+
+// builds against the watcher in this repository, dependents use the
+// required release
+replace github.com/billcobbler/casbin-redis-watcher/v2 => ../
@@ -0,0 +1,33 @@
+// Package msgpack encodes rediswatcher envelopes with MessagePack.
+package msgpack
+
+import (
+	"bytes"
+
+	rediswatcher "github.com/billcobbler/casbin-redis-watcher/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec is a rediswatcher.Codec encoding envelopes as MessagePack maps keyed
+// like the JSON envelope
+var Codec rediswatcher.Codec = codec{}
+
+type codec struct{}
+
+func (codec) Marshal(msg *rediswatcher.UpdateMessage) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.SetOmitEmpty(true)
+	enc.UseCompactInts(true)
+	if err := enc.Encode(msg); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (codec) Unmarshal(data []byte, msg *rediswatcher.UpdateMessage) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(msg)
+}
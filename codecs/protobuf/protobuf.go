@@ -0,0 +1,211 @@
+// Package protobuf encodes rediswatcher envelopes as protocol buffers
+// messages matching envelope.proto.
+package protobuf
+
+import (
+	"errors"
+
+	rediswatcher "github.com/billcobbler/casbin-redis-watcher/v2"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// field numbers of envelope.proto
+const (
+	typeField    = 1
+	localIDField = 2
+	seqField     = 3
+	targetField  = 4
+	versionField = 5
+	payloadField = 6
+	refField     = 7
+	chunkField   = 8
+	keyIDField   = 9
+	traceField   = 10
+	schemaField  = 11
+	tenantField  = 12
+	filterField  = 13
+	domainsField = 14
+	routeField   = 15
+	ackField     = 16
+	commandField = 17
+	groupField   = 18
+
+	chunkIDField    = 1
+	chunkIndexField = 2
+	chunkCountField = 3
+
+	entryKeyField   = 1
+	entryValueField = 2
+)
+
+var errInvalid = errors.New("protobuf: invalid envelope")
+
+// Codec is a rediswatcher.Codec encoding envelopes as protocol buffers
+var Codec rediswatcher.Codec = codec{}
+
+type codec struct{}
+
+func (codec) Marshal(msg *rediswatcher.UpdateMessage) ([]byte, error) {
+	var b []byte
+	b = appendString(b, typeField, msg.Type)
+	b = appendString(b, localIDField, msg.LocalID)
+	if msg.Seq != 0 {
+		b = protowire.AppendTag(b, seqField, protowire.VarintType)
+		b = protowire.AppendVarint(b, msg.Seq)
+	}
+	b = appendString(b, targetField, msg.Target)
+	if msg.Version != 0 {
+		b = protowire.AppendTag(b, versionField, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(msg.Version))
+	}
+	b = appendString(b, payloadField, msg.Payload)
+	b = appendString(b, refField, msg.Ref)
+	if msg.Chunk != nil {
+		var c []byte
+		c = appendString(c, chunkIDField, msg.Chunk.ID)
+		c = protowire.AppendTag(c, chunkIndexField, protowire.VarintType)
+		c = protowire.AppendVarint(c, uint64(msg.Chunk.Index))
+		c = protowire.AppendTag(c, chunkCountField, protowire.VarintType)
+		c = protowire.AppendVarint(c, uint64(msg.Chunk.Count))
+		b = protowire.AppendTag(b, chunkField, protowire.BytesType)
+		b = protowire.AppendBytes(b, c)
+	}
+	b = appendString(b, keyIDField, msg.KeyID)
+	for key, value := range msg.Trace {
+		var e []byte
+		e = appendString(e, entryKeyField, key)
+		e = appendString(e, entryValueField, value)
+		b = protowire.AppendTag(b, traceField, protowire.BytesType)
+		b = protowire.AppendBytes(b, e)
+	}
+	if msg.Schema != 0 {
+		b = protowire.AppendTag(b, schemaField, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(msg.Schema))
+	}
+	b = appendString(b, tenantField, msg.Tenant)
+	b = appendString(b, filterField, msg.Filter)
+	for _, domain := range msg.Domains {
+		b = protowire.AppendTag(b, domainsField, protowire.BytesType)
+		b = protowire.AppendString(b, domain)
+	}
+	b = appendString(b, routeField, msg.Route)
+	if msg.AckRequested {
+		b = protowire.AppendTag(b, ackField, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+	}
+	b = appendString(b, commandField, msg.Command)
+	b = appendString(b, groupField, msg.Group)
+	return b, nil
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func (codec) Unmarshal(data []byte, msg *rediswatcher.UpdateMessage) error {
+	return fields(data, func(num protowire.Number, typ protowire.Type, v uint64, bytes []byte) error {
+		switch num {
+		case typeField:
+			msg.Type = string(bytes)
+		case localIDField:
+			msg.LocalID = string(bytes)
+		case seqField:
+			msg.Seq = v
+		case targetField:
+			msg.Target = string(bytes)
+		case versionField:
+			msg.Version = int64(v)
+		case payloadField:
+			msg.Payload = string(bytes)
+		case refField:
+			msg.Ref = string(bytes)
+		case chunkField:
+			msg.Chunk = &rediswatcher.MessageChunk{}
+			return fields(bytes, func(num protowire.Number, _ protowire.Type, v uint64, bytes []byte) error {
+				switch num {
+				case chunkIDField:
+					msg.Chunk.ID = string(bytes)
+				case chunkIndexField:
+					msg.Chunk.Index = int(v)
+				case chunkCountField:
+					msg.Chunk.Count = int(v)
+				}
+				return nil
+			})
+		case keyIDField:
+			msg.KeyID = string(bytes)
+		case traceField:
+			var key, value string
+			err := fields(bytes, func(num protowire.Number, _ protowire.Type, _ uint64, bytes []byte) error {
+				switch num {
+				case entryKeyField:
+					key = string(bytes)
+				case entryValueField:
+					value = string(bytes)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if msg.Trace == nil {
+				msg.Trace = make(map[string]string)
+			}
+			msg.Trace[key] = value
+		case schemaField:
+			msg.Schema = int(v)
+		case tenantField:
+			msg.Tenant = string(bytes)
+		case filterField:
+			msg.Filter = string(bytes)
+		case domainsField:
+			msg.Domains = append(msg.Domains, string(bytes))
+		case routeField:
+			msg.Route = string(bytes)
+		case ackField:
+			msg.AckRequested = v != 0
+		case commandField:
+			msg.Command = string(bytes)
+		case groupField:
+			msg.Group = string(bytes)
+		}
+		return nil
+	})
+}
+
+// fields calls fn for every varint and length delimited field in data, other
+// wire types are skipped
+func fields(data []byte, fn func(num protowire.Number, typ protowire.Type, v uint64, bytes []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return errInvalid
+		}
+		data = data[n:]
+
+		var v uint64
+		var bytes []byte
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(data)
+		case protowire.BytesType:
+			bytes, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return errInvalid
+		}
+		data = data[n:]
+		if typ == protowire.VarintType || typ == protowire.BytesType {
+			if err := fn(num, typ, v, bytes); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
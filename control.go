@@ -0,0 +1,139 @@
+package rediswatcher
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+)
+
+// Commands handled by every watcher with a ControlChannel
+const (
+	// ReloadAllCommand invokes the update callback, see ForceReload
+	ReloadAllCommand = "reload-all"
+	// ReportStatusCommand logs the watcher's Stats and writes its Presence
+	ReportStatusCommand = "report-status"
+	// SetLogLevelCommand sets the minimum level of the log messages passed
+	// to the Logger to its argument: debug, info, warn or error
+	SetLogLevelCommand = "set-log-level"
+)
+
+var (
+	errNoControlChannel = errors.New("rediswatcher: no ControlChannel configured")
+	errUnknownCommand   = errors.New("rediswatcher: unknown command")
+	errUnknownLogLevel  = errors.New("rediswatcher: unknown log level")
+)
+
+// log levels of the levelLogger
+const (
+	logDebug int32 = iota
+	logInfo
+	logWarn
+	logError
+)
+
+// SendCommand publishes command with args on the ControlChannel, every
+// watcher subscribed to it, including this one, runs the handler registered
+// for command
+func (w *Watcher) SendCommand(command, args string) error {
+	if w.options.ControlChannel == "" {
+		return errNoControlChannel
+	}
+	return w.publishMessage(context.Background(), &UpdateMessage{Type: CommandMessageType, Command: command, Payload: args})
+}
+
+// RegisterCommand registers the handler run when command is received on the
+// ControlChannel, replacing the previous handler of command, including the
+// built-in ones. Errors returned by handler are passed to the ErrorHandler.
+func (w *Watcher) RegisterCommand(command string, handler func(args string) error) {
+	w.callbacksMu.Lock()
+	defer w.callbacksMu.Unlock()
+	if w.commands == nil {
+		w.commands = make(map[string]func(string) error)
+	}
+	w.commands[command] = handler
+}
+
+// initCommands registers the built-in commands and filters the log messages
+// by the level set with SetLogLevelCommand
+func (w *Watcher) initCommands() {
+	w.commands = map[string]func(string) error{
+		// commands run on the message processor, which must not hand the
+		// reload back to itself
+		ReloadAllCommand: func(string) error {
+			w.options.Logger.Info("Forcing policy reload", "channel", w.options.Channel, "localID", w.options.LocalID, "broadcast", false)
+			w.reloadNow(w.options.LocalID)
+			return nil
+		},
+		ReportStatusCommand: func(string) error {
+			stats := w.Stats()
+			w.options.Logger.Info("Watcher status", "channel", w.options.Channel, "localID", w.options.LocalID,
+				"published", stats.Published, "received", stats.Received, "squashed", stats.Squashed,
+				"reconnectAttempts", stats.ReconnectAttempts, "queueDepth", stats.QueueDepth, "queueLag", stats.QueueLag)
+			w.writePresence()
+			return nil
+		},
+		SetLogLevelCommand: w.setLogLevel,
+	}
+	w.options.Logger = &levelLogger{logger: w.options.Logger, level: &w.logLevel}
+}
+
+// handleCommand runs the handler registered for a command received on the
+// ControlChannel
+func (w *Watcher) handleCommand(msg *UpdateMessage) {
+	if w.options.ControlChannel == "" || (msg.Channel != "" && msg.Channel != w.options.ControlChannel) {
+		return
+	}
+	w.callbacksMu.Lock()
+	handler := w.commands[msg.Command]
+	w.callbacksMu.Unlock()
+
+	err := errUnknownCommand
+	if handler != nil {
+		err = nil
+		w.safeHook("Command handler", func() { err = handler(msg.Payload) })
+	}
+	if err != nil {
+		w.options.Logger.Error("Failure running command", "channel", w.options.Channel, "localID", w.options.LocalID, "command", msg.Command, "sender", msg.LocalID, "error", err)
+		w.handleError(err)
+	}
+}
+
+func (w *Watcher) setLogLevel(level string) error {
+	levels := map[string]int32{"debug": logDebug, "info": logInfo, "warn": logWarn, "error": logError}
+	l, ok := levels[strings.ToLower(strings.TrimSpace(level))]
+	if !ok {
+		return errUnknownLogLevel
+	}
+	atomic.StoreInt32(&w.logLevel, l)
+	return nil
+}
+
+// levelLogger drops the log messages below the level set with
+// SetLogLevelCommand
+type levelLogger struct {
+	logger Logger
+	level  *int32
+}
+
+func (l *levelLogger) Debug(msg string, keyvals ...interface{}) {
+	if atomic.LoadInt32(l.level) <= logDebug {
+		l.logger.Debug(msg, keyvals...)
+	}
+}
+
+func (l *levelLogger) Info(msg string, keyvals ...interface{}) {
+	if atomic.LoadInt32(l.level) <= logInfo {
+		l.logger.Info(msg, keyvals...)
+	}
+}
+
+func (l *levelLogger) Warn(msg string, keyvals ...interface{}) {
+	if atomic.LoadInt32(l.level) <= logWarn {
+		l.logger.Warn(msg, keyvals...)
+	}
+}
+
+func (l *levelLogger) Error(msg string, keyvals ...interface{}) {
+	l.logger.Error(msg, keyvals...)
+}
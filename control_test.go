@@ -0,0 +1,104 @@
+package rediswatcher
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestControlChannel(t *testing.T) {
+	c := &publishConn{testConn: NewTestConn()}
+	c.Clear()
+	var errs []error
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		ControlChannel("/casbin-control"), WithErrorHandler(func(err error) { errs = append(errs, err) }))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+	if !rw.subscribedChannel("/casbin-control") {
+		t.Error("Watcher should subscribe to the control channel")
+	}
+
+	if err := rw.SendCommand(ReloadAllCommand, ""); err != nil {
+		t.Fatalf("SendCommand failed: %v", err)
+	}
+	msg := decodeMessage("/casbin-control", []byte(c.published[0]))
+	if msg.Type != CommandMessageType || msg.Command != ReloadAllCommand {
+		t.Fatalf("Unexpected command message %+v", msg)
+	}
+	if channel := rw.messageChannel(msg); channel != "/casbin-control" {
+		t.Errorf("Commands should be published on the control channel, got %s", channel)
+	}
+
+	var received []string
+	w.SetUpdateCallback(func(msg string) { received = append(received, msg) })
+	rw.processMessage(msg)
+	if len(received) != 1 {
+		t.Errorf("reload-all should invoke the callback, received %v", received)
+	}
+
+	rw.processMessage(&UpdateMessage{Type: CommandMessageType, Channel: "/casbin-control", LocalID: "node2", Seq: 1, Command: SetLogLevelCommand, Payload: "warn"})
+	if level := atomic.LoadInt32(&rw.logLevel); level != logWarn {
+		t.Errorf("set-log-level should set the log level, got %d", level)
+	}
+
+	var args string
+	rw.RegisterCommand("drain", func(a string) error {
+		args = a
+		return nil
+	})
+	rw.processMessage(&UpdateMessage{Type: CommandMessageType, Channel: "/casbin-control", LocalID: "node2", Seq: 2, Command: "drain", Payload: "now"})
+	if args != "now" {
+		t.Errorf("Registered command should receive its arguments, received '%s'", args)
+	}
+
+	rw.processMessage(&UpdateMessage{Type: CommandMessageType, Channel: "/casbin-control", LocalID: "node2", Seq: 3, Command: "unknown"})
+	rw.processMessage(&UpdateMessage{Type: CommandMessageType, Channel: "/casbin", LocalID: "node2", Seq: 4, Command: "drain", Payload: "later"})
+	if len(errs) != 1 || errs[0] != errUnknownCommand || args != "now" {
+		t.Errorf("Unknown commands should be reported and commands on other channels ignored, got %v", errs)
+	}
+}
+
+func TestSendCommandWithoutControlChannel(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	if err := w.(*Watcher).SendCommand(ReloadAllCommand, ""); err != errNoControlChannel {
+		t.Errorf("Expected %v, got %v", errNoControlChannel, err)
+	}
+}
+
+func TestControlChannelSubscribed(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	c.Command("SUBSCRIBE", "/casbin", "/casbin-control").Expect([]interface{}{[]byte("subscribe"), []byte("/casbin"), []byte("1")})
+	w, err := NewWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		ControlChannel("/casbin-control"))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	defer w.Close()
+	rw := w.(*Watcher)
+
+	ch := make(chan string, 2)
+	w.SetUpdateCallback(func(msg string) { ch <- msg })
+	rw.RegisterCommand("panic", func(string) error { panic("boom") })
+
+	rw.messagesIn <- &UpdateMessage{Type: CommandMessageType, Channel: "/casbin-control", LocalID: "node2", Seq: 1, Command: "panic"}
+	rw.messagesIn <- &UpdateMessage{Type: CommandMessageType, Channel: "/casbin-control", LocalID: "node2", Seq: 2, Command: ReloadAllCommand}
+	rw.messagesIn <- &UpdateMessage{Type: UpdateMessageType, Channel: "/casbin", LocalID: "node2", Seq: 3}
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-ch:
+			if i == 0 && msg != "node1" {
+				t.Errorf("reload-all should invoke the callback with the local ID, received %s", msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("reload-all should not block the message processor")
+		}
+	}
+}
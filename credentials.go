@@ -0,0 +1,126 @@
+package rediswatcher
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	defaultCredentialsTimeout = 10 * time.Second
+	defaultReauthMargin       = 2 * time.Minute
+	defaultReauthMinWait      = 10 * time.Second
+)
+
+// CredentialsProvider returns the credentials a new connection authenticates
+// with. It is invoked on every connection and reconnection, so that short
+// lived credentials such as ElastiCache IAM auth tokens can rotate while the
+// process runs. An empty password skips AUTH.
+type CredentialsProvider interface {
+	Credentials(ctx context.Context) (username string, password string, err error)
+}
+
+// CredentialsProviderFunc adapts a function to a CredentialsProvider
+type CredentialsProviderFunc func(ctx context.Context) (string, string, error)
+
+// Credentials calls f(ctx)
+func (f CredentialsProviderFunc) Credentials(ctx context.Context) (string, string, error) {
+	return f(ctx)
+}
+
+// credentials returns the username and password to authenticate a new
+// connection with, from the CredentialsProvider if set
+func (w *Watcher) credentials() (string, string, error) {
+	if w.options.CredentialsProvider == nil {
+		return w.options.Username, w.options.Password, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultCredentialsTimeout)
+	defer cancel()
+	return w.options.CredentialsProvider.Credentials(ctx)
+}
+
+// ExpiringCredentialsProvider is a CredentialsProvider whose credentials
+// expire, such as access tokens. The watcher authenticates its connections
+// again with fresh credentials before they expire, since servers like Azure
+// Cache for Redis close connections whose token expired.
+type ExpiringCredentialsProvider interface {
+	CredentialsProvider
+	// ExpiresOn returns when the credentials last returned expire
+	ExpiresOn() time.Time
+}
+
+// reauthenticate authenticates the connections again shortly before the
+// credentials of an ExpiringCredentialsProvider expire, until the watcher is
+// closed
+func (w *Watcher) reauthenticate(provider ExpiringCredentialsProvider) {
+	for {
+		wait := time.Until(provider.ExpiresOn()) - defaultReauthMargin
+		if wait < defaultReauthMinWait {
+			wait = defaultReauthMinWait
+		}
+		select {
+		case <-w.closed:
+			return
+		case <-time.After(wait):
+		}
+		if err := w.reauth(); err != nil {
+			w.options.Logger.Error("Failure authenticating with fresh credentials", "channel", w.options.Channel, "localID", w.options.LocalID, "error", err)
+			w.handleError(err)
+		}
+	}
+}
+
+// reauth sends AUTH with fresh credentials on the publish connection. A
+// subscribed connection can't run AUTH, it is closed so that it resubscribes
+// right away and authenticates with the fresh credentials.
+func (w *Watcher) reauth() error {
+	username, password, err := w.credentials()
+	if err != nil {
+		return err
+	}
+	if username == "" {
+		username = "default"
+	}
+	startTime := time.Now()
+	_, err = w.pubDo("AUTH", username, password)
+	if w.options.RecordMetrics != nil {
+		w.options.RecordMetrics(w.createMetrics(RedisDoAuthMetric, startTime, err))
+	}
+	if err != nil {
+		return err
+	}
+
+	if w.subscriber && w.options.Transport != PollTransport && w.options.SubConn == nil {
+		w.options.Logger.Info("Reconnecting subscription with fresh credentials", "channel", w.options.Channel, "localID", w.options.LocalID)
+		w.pubMu.Lock()
+		subConn := w.subConn
+		w.pubMu.Unlock()
+		atomic.StoreInt32(&w.reauthing, 1)
+		subConn.Close()
+	}
+	return nil
+}
+
+// authError reports whether redis rejected the credentials of a connection
+// with err, e.g. after the password was rotated or requirepass enabled
+func authError(err error) bool {
+	e, ok := err.(redis.Error)
+	return ok && (strings.HasPrefix(string(e), "NOAUTH") || strings.HasPrefix(string(e), "WRONGPASS"))
+}
+
+// resetSub closes the subscribe connection after redis rejected its
+// credentials, so that the next connection attempt dials it again with fresh
+// credentials instead of retrying with the stale ones
+func (w *Watcher) resetSub() {
+	if w.options.SubConn != nil {
+		return
+	}
+	w.pubMu.Lock()
+	defer w.pubMu.Unlock()
+	if w.subConn != nil {
+		w.subConn.Close()
+	}
+}
@@ -0,0 +1,47 @@
+package rediswatcher
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+func TestCredentialsProvider(t *testing.T) {
+	w := &Watcher{options: defaultWatcherOptions()}
+	Username("static")(&w.options)
+	Password("secret")(&w.options)
+
+	if username, password, _ := w.credentials(); username != "static" || password != "secret" {
+		t.Errorf("Expected the static credentials, received %s/%s", username, password)
+	}
+
+	calls := 0
+	WithCredentialsProvider(CredentialsProviderFunc(func(ctx context.Context) (string, string, error) {
+		calls++
+		return "iam-user", "token" + strconv.Itoa(calls), nil
+	}))(&w.options)
+	w.credentials()
+	username, password, err := w.credentials()
+	if err != nil {
+		t.Fatalf("Failed to get credentials: %v", err)
+	}
+	if username != "iam-user" || password != "token2" {
+		t.Errorf("Provider should be invoked for every connection, received %s/%s", username, password)
+	}
+}
+
+func TestAuthError(t *testing.T) {
+	for err, expected := range map[error]bool{
+		redis.Error("NOAUTH Authentication required."):          true,
+		redis.Error("WRONGPASS invalid username-password pair"): true,
+		redis.Error("ERR unknown command"):                      false,
+		errors.New("WRONGPASS invalid username-password pair"):  false,
+	} {
+		if authError(err) != expected {
+			t.Errorf("authError(%q) should be %v", err, expected)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package rediswatcher
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+)
+
+// EncryptedMessageType is the type of envelopes carrying an encrypted
+// message, see EncryptionKey
+const EncryptedMessageType = "encrypted"
+
+var errNotEncrypted = errors.New("rediswatcher: received unencrypted message")
+
+// KeyProvider supplies the AES keys messages are encrypted with. CurrentKey
+// returns the key new messages are encrypted with along with its id, Key
+// returns the key with the given id, so that messages encrypted before a
+// rotation can still be decrypted.
+type KeyProvider interface {
+	CurrentKey() (id string, key []byte, err error)
+	Key(id string) ([]byte, error)
+}
+
+type staticKey []byte
+
+func (k staticKey) CurrentKey() (string, []byte, error) {
+	return "", k, nil
+}
+
+func (k staticKey) Key(string) ([]byte, error) {
+	return k, nil
+}
+
+// seal encrypts data into an encrypted envelope when a KeyProvider is
+// configured, otherwise it returns data as is
+func (w *Watcher) seal(data string) (string, error) {
+	if w.options.KeyProvider == nil {
+		return data, nil
+	}
+	id, key, err := w.options.KeyProvider.CurrentKey()
+	if err != nil {
+		return "", err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(data), nil)
+	envelope, err := w.encode(&UpdateMessage{
+		Type:    EncryptedMessageType,
+		KeyID:   id,
+		Payload: base64.StdEncoding.EncodeToString(sealed),
+	})
+	return string(envelope), err
+}
+
+// open decodes data, decrypting it first when a KeyProvider is configured.
+// Unencrypted messages are rejected in that case.
+func (w *Watcher) open(channel string, data []byte) (*UpdateMessage, error) {
+	msg := w.decode(channel, data)
+	if w.options.KeyProvider == nil {
+		return msg, nil
+	}
+	plain, err := w.decrypt(msg)
+	if err != nil {
+		return nil, err
+	}
+	return w.decode(channel, plain), nil
+}
+
+// decrypt returns the plaintext of an encrypted envelope
+func (w *Watcher) decrypt(msg *UpdateMessage) ([]byte, error) {
+	if msg.Type != EncryptedMessageType {
+		return nil, errNotEncrypted
+	}
+	key, err := w.options.KeyProvider.Key(msg.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(msg.Payload)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("rediswatcher: encrypted message too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
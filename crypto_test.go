@@ -0,0 +1,56 @@
+package rediswatcher
+
+import (
+	"testing"
+)
+
+type rotatingKeys map[string][]byte
+
+func (k rotatingKeys) CurrentKey() (string, []byte, error) {
+	return "v2", k["v2"], nil
+}
+
+func (k rotatingKeys) Key(id string) ([]byte, error) {
+	return k[id], nil
+}
+
+func TestEncryption(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	keys := rotatingKeys{
+		"v1": []byte("0123456789abcdef"),
+		"v2": []byte("fedcba9876543210"),
+	}
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), WithKeyProvider(keys))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	sealed, err := rw.seal("node1")
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+	msg := decodeMessage("/casbin", []byte(sealed))
+	if msg.Type != EncryptedMessageType || msg.KeyID != "v2" {
+		t.Fatalf("Message should be encrypted with key 'v2', received %+v", msg)
+	}
+
+	opened, err := rw.open("/casbin", []byte(sealed))
+	if err != nil {
+		t.Fatalf("Failed to decrypt: %v", err)
+	}
+	if opened.Payload != "node1" {
+		t.Errorf("Decrypted payload should be 'node1', received '%s' instead", opened.Payload)
+	}
+
+	if _, err := rw.open("/casbin", []byte("node1")); err != errNotEncrypted {
+		t.Errorf("Unencrypted message should be rejected, received %v", err)
+	}
+
+	other, _ := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), EncryptionKey(keys["v1"]))
+	if _, err := other.(*Watcher).open("/casbin", []byte(sealed)); err == nil {
+		t.Error("Message encrypted with another key should be rejected")
+	}
+}
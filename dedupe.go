@@ -0,0 +1,47 @@
+package rediswatcher
+
+import (
+	"crypto/sha256"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// duplicate reports whether a message identical to msg was processed within
+// the DedupeWindow
+func (w *Watcher) duplicate(msg *UpdateMessage) bool {
+	if w.options.DedupeWindow <= 0 {
+		return false
+	}
+	key := dedupeKey(msg)
+	w.dedupeMu.Lock()
+	defer w.dedupeMu.Unlock()
+	seen, ok := w.dedupeSeen[key]
+	return ok && time.Since(seen) < w.options.DedupeWindow
+}
+
+// remember records msg as processed for the DedupeWindow and forgets the
+// messages whose window expired
+func (w *Watcher) remember(msg *UpdateMessage) {
+	if w.options.DedupeWindow <= 0 {
+		return
+	}
+	now := time.Now()
+	w.dedupeMu.Lock()
+	defer w.dedupeMu.Unlock()
+	for key, seen := range w.dedupeSeen {
+		if now.Sub(seen) >= w.options.DedupeWindow {
+			delete(w.dedupeSeen, key)
+		}
+	}
+	w.dedupeSeen[dedupeKey(msg)] = now
+}
+
+// dedupeKey identifies msg by its ID, set on envelope updates, and its
+// content including the scope of the update, so that distinct updates with
+// the same payload aren't taken for duplicates
+func dedupeKey(msg *UpdateMessage) [sha256.Size]byte {
+	fields := []string{msg.ID(), msg.Type, msg.Payload, msg.Filter, strings.Join(msg.Domains, ","),
+		msg.Tenant, msg.Route, msg.Command, strconv.FormatInt(msg.Version, 10)}
+	return sha256.Sum256([]byte(strings.Join(fields, "\x00")))
+}
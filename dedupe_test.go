@@ -0,0 +1,98 @@
+package rediswatcher
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDedupeWindow(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c),
+		DedupeWindow(50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	var received []string
+	w.SetUpdateCallback(func(msg string) {
+		received = append(received, msg)
+	})
+	msg := UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Payload: "update"}
+	rw.processMessage(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Payload: "update"})
+	if d := rw.Explain(msg); d != DispositionDuplicate {
+		t.Errorf("Expected disposition %s, got %s", DispositionDuplicate, d)
+	}
+	rw.processMessage(&UpdateMessage{Type: UpdateMessageType, LocalID: "node3", Payload: "update"})
+	rw.processMessage(&UpdateMessage{Type: UpdateMessageType, LocalID: "node3", Payload: "other"})
+	if len(received) != 2 {
+		t.Errorf("Duplicate should be dropped, received %v", received)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	rw.processMessage(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Payload: "update"})
+	if len(received) != 3 {
+		t.Errorf("Message should be delivered once the window expired, received %v", received)
+	}
+}
+
+func TestDedupeDistinctUpdates(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c),
+		DedupeWindow(time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	var received []string
+	w.SetUpdateCallback(func(msg string) {
+		received = append(received, msg)
+	})
+	rw.processMessage(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Seq: 1, Payload: "node2", Domains: []string{"a"}})
+	rw.processMessage(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Seq: 2, Payload: "node2", Domains: []string{"b"}})
+	rw.processMessage(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Seq: 3, Payload: "node2"})
+	rw.processMessage(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Seq: 4, Payload: "node2"})
+	if len(received) != 4 {
+		t.Errorf("Distinct updates should all be delivered, received %v", received)
+	}
+
+	rw.processMessage(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Seq: 4, Payload: "node2"})
+	if len(received) != 4 {
+		t.Errorf("Message delivered twice should be dropped, received %v", received)
+	}
+}
+
+func TestDedupeEnvelopeUpdates(t *testing.T) {
+	c := &publishConn{testConn: NewTestConn()}
+	c.Clear()
+	sender, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node2"),
+		EnvelopeMessages(true))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		DedupeWindow(time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	var received []string
+	w.SetUpdateCallback(func(msg string) {
+		received = append(received, msg)
+	})
+	// updates published twice are told apart by their sequence number, only
+	// a message delivered twice is a duplicate
+	sender.Update()
+	sender.Update()
+	for _, data := range c.published {
+		rw.processMessage(decodeMessage("/casbin", []byte(data)))
+	}
+	rw.processMessage(decodeMessage("/casbin", []byte(c.published[1])))
+	if len(received) != 2 {
+		t.Errorf("Both updates should be delivered and the redelivery dropped, received %v", received)
+	}
+}
@@ -0,0 +1,35 @@
+package rediswatcher
+
+import (
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// defaultTCPKeepAlive is the keep-alive period redigo uses by default
+const defaultTCPKeepAlive = 5 * time.Minute
+
+// dialOptions returns the options new connections are dialed with
+func (w *Watcher) dialOptions() []redis.DialOption {
+	options := []redis.DialOption{redis.DialKeepAlive(w.tcpKeepAlive())}
+	if w.options.Dialer != nil {
+		options = append(options, redis.DialNetDial(w.options.Dialer))
+	}
+	if w.options.ConnectTimeout > 0 {
+		options = append(options, redis.DialConnectTimeout(w.options.ConnectTimeout))
+	}
+	if w.options.WriteTimeout > 0 {
+		options = append(options, redis.DialWriteTimeout(w.options.WriteTimeout))
+	}
+	return options
+}
+
+// tcpKeepAlive returns the keep-alive period passed to net.Dialer, which
+// takes 0 for its default period and a negative period to disable
+// keep-alives
+func (w *Watcher) tcpKeepAlive() time.Duration {
+	if w.options.TCPKeepAlive == 0 {
+		return -1
+	}
+	return w.options.TCPKeepAlive
+}
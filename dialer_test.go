@@ -0,0 +1,46 @@
+package rediswatcher
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestWithDialer(t *testing.T) {
+	unreachable := errors.New("proxy unreachable")
+	var dialed string
+	w := &Watcher{options: defaultWatcherOptions()}
+	WithDialer(func(network, addr string) (net.Conn, error) {
+		dialed = network + "://" + addr
+		return nil, unreachable
+	})(&w.options)
+
+	if _, err := w.dial("redis:6379"); err != unreachable {
+		t.Errorf("Expected the dialer's error, received %v", err)
+	}
+	if dialed != "tcp://redis:6379" {
+		t.Errorf("Connection should be established with the dialer, dialed '%s'", dialed)
+	}
+}
+
+func TestDialOptions(t *testing.T) {
+	w := &Watcher{options: defaultWatcherOptions()}
+	if n := len(w.dialOptions()); n != 1 {
+		t.Errorf("Only the keep-alive period should be set by default, received %d options", n)
+	}
+
+	ConnectTimeout(time.Second)(&w.options)
+	WriteTimeout(time.Second)(&w.options)
+	if n := len(w.dialOptions()); n != 3 {
+		t.Errorf("Expected the keep-alive period and both timeouts, received %d options", n)
+	}
+
+	if period := w.tcpKeepAlive(); period != defaultTCPKeepAlive {
+		t.Errorf("Expected the default keep-alive period, received %v", period)
+	}
+	TCPKeepAlive(0)(&w.options)
+	if period := w.tcpKeepAlive(); period >= 0 {
+		t.Errorf("Disabling keep-alives should pass a negative period to net.Dialer, received %v", period)
+	}
+}
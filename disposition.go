@@ -0,0 +1,119 @@
+package rediswatcher
+
+// Disposition describes what the watcher does with a received message
+type Disposition string
+
+const (
+	// DispositionDelivered messages invoke the update callback immediately
+	DispositionDelivered Disposition = "delivered"
+	// DispositionIgnoredSelf messages were published by this watcher and
+	// IgnoreSelf is enabled
+	DispositionIgnoredSelf Disposition = "ignored-self"
+	// DispositionIgnoredGroup messages were published by another watcher of
+	// the same GroupID and IgnoreGroup is enabled
+	DispositionIgnoredGroup Disposition = "ignored-group"
+	// DispositionSquashed messages are coalesced and invoke the update
+	// callback once the squash timeout elapses
+	DispositionSquashed Disposition = "squashed"
+	// DispositionControl messages are handled by the watcher itself, such as
+	// snapshot requests and acknowledgements, and never reach the update
+	// callback
+	DispositionControl Disposition = "control"
+	// DispositionRejected messages arrived on a channel the watcher is not
+	// subscribed to and VerifyChannel is enabled
+	DispositionRejected Disposition = "rejected"
+	// DispositionDeniedSender messages were published by a watcher that is
+	// not in AllowSenders or is in DenySenders
+	DispositionDeniedSender Disposition = "denied-sender"
+	// DispositionUnsupported messages are of a type this watcher doesn't
+	// know, such as a message type introduced by a newer version
+	DispositionUnsupported Disposition = "unsupported"
+	// DispositionOtherTenant messages are updates for a tenant this watcher
+	// doesn't host, see Tenants
+	DispositionOtherTenant Disposition = "other-tenant"
+	// DispositionFiltered messages were dropped by the WithMessageFilter
+	// predicate
+	DispositionFiltered Disposition = "filtered"
+	// DispositionDuplicate messages are identical to a message processed
+	// within the DedupeWindow: the same envelope message, by sender and
+	// sequence number, or a bare message with the same payload and scope
+	DispositionDuplicate Disposition = "duplicate"
+	// DispositionPaused messages are updates received while the watcher is
+	// paused, see Pause
+	DispositionPaused Disposition = "paused"
+)
+
+// Explain reports what the watcher would do with msg given its current
+// options, without invoking the update callback or changing the state of the
+// watcher. It is meant for debugging IgnoreSelf and squashing
+// configurations. Like received updates, msg is passed to the
+// WithMessageTransform and WithMessageFilter hooks, which do run.
+func (w *Watcher) Explain(msg UpdateMessage) Disposition {
+	if !w.transform(&msg) {
+		return DispositionFiltered
+	}
+	return w.disposition(&msg)
+}
+
+// transform passes an update to the WithMessageTransform hook and reports
+// whether it returned, an update is dropped if the hook panics
+func (w *Watcher) transform(msg *UpdateMessage) bool {
+	if w.options.MessageTransform == nil || msg.Type != UpdateMessageType {
+		return true
+	}
+	return w.safeHook("Message transform", func() { w.options.MessageTransform(msg) })
+}
+
+// filter reports whether the WithMessageFilter hook keeps an update, it is
+// dropped if the hook panics
+func (w *Watcher) filter(msg *UpdateMessage) bool {
+	keep := false
+	w.safeHook("Message filter", func() { keep = w.options.MessageFilter(*msg) })
+	return keep
+}
+
+// disposition runs msg through the filtering pipeline shared by Explain and
+// the message processor
+func (w *Watcher) disposition(msg *UpdateMessage) Disposition {
+	if w.options.VerifyChannel && msg.Channel != "" && !w.subscribed(msg.Channel) {
+		return DispositionRejected
+	}
+	if !w.senderAllowed(msg.LocalID) {
+		return DispositionDeniedSender
+	}
+	switch msg.Type {
+	case SnapshotRequestMessageType, SnapshotMessageType, ChunkMessageType, AckMessageType, CommandMessageType,
+		VersionMessageType:
+		return DispositionControl
+	}
+	if !knownMessageType(msg.Type) {
+		return DispositionUnsupported
+	}
+	if !w.hostsTenant(msg.Tenant) {
+		return DispositionOtherTenant
+	}
+	if w.options.MessageFilter != nil && !w.filter(msg) {
+		return DispositionFiltered
+	}
+	if w.options.IgnoreSelf && msg.LocalID == w.options.LocalID {
+		return DispositionIgnoredSelf
+	}
+	if w.options.IgnoreGroup && w.options.GroupID != "" && msg.Group == w.options.GroupID {
+		return DispositionIgnoredGroup
+	}
+	if w.isPaused() {
+		return DispositionPaused
+	}
+	if w.duplicate(msg) {
+		return DispositionDuplicate
+	}
+	if w.options.SquashMessages && !msg.AckRequested {
+		return DispositionSquashed
+	}
+	return DispositionDelivered
+}
+
+// subscribed reports whether the watcher subscribes to channel
+func (w *Watcher) subscribed(channel string) bool {
+	return w.subscribedChannel(channel)
+}
@@ -0,0 +1,282 @@
+package rediswatcher
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExplain(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	self := UpdateMessage{Type: UpdateMessageType, LocalID: "node1"}
+	other := UpdateMessage{Type: UpdateMessageType, LocalID: "node2"}
+
+	if d := rw.Explain(self); d != DispositionDelivered {
+		t.Errorf("Message should be delivered, received '%s' instead", d)
+	}
+
+	rw.options.IgnoreSelf = true
+	rw.options.SquashMessages = true
+	if d := rw.Explain(self); d != DispositionIgnoredSelf {
+		t.Errorf("Own message should be ignored, received '%s' instead", d)
+	}
+	if d := rw.Explain(other); d != DispositionSquashed {
+		t.Errorf("Message should be squashed, received '%s' instead", d)
+	}
+	if d := rw.Explain(UpdateMessage{Type: SnapshotRequestMessageType, LocalID: "node2"}); d != DispositionControl {
+		t.Errorf("Snapshot request should be handled by the watcher, received '%s' instead", d)
+	}
+	rw.options.VerifyChannel = true
+	if d := rw.Explain(UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Channel: "/other"}); d != DispositionRejected {
+		t.Errorf("Message on an unexpected channel should be rejected, received '%s' instead", d)
+	}
+	if d := rw.Explain(UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Channel: "/casbin"}); d != DispositionSquashed {
+		t.Errorf("Message on the subscribed channel should be squashed, received '%s' instead", d)
+	}
+	if IsCallbackPending(rw, false) {
+		t.Error("Explain should not change the squash state")
+	}
+}
+
+func TestSquashMaxCount(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c),
+		SquashMessages(true), SquashMaxCount(3))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	var calls []string
+	w.SetUpdateCallback(func(msg string) { calls = append(calls, msg) })
+	for _, sender := range []string{"node2", "node3", "node4", "node5"} {
+		rw.processMessage(decodeMessage("/casbin", []byte(sender)))
+	}
+	if len(calls) != 1 || calls[0] != "node4" {
+		t.Errorf("Callback should be invoked after 3 squashed messages, received %v", calls)
+	}
+	if !IsCallbackPending(rw, false) {
+		t.Error("The fourth message should start a new squash window")
+	}
+}
+
+func TestSquashMaxDelay(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	c.ReceiveWait = true
+	c.Command("SUBSCRIBE", "/casbin").Expect([]interface{}{[]byte("subscribe"), []byte("/casbin"), []byte("1")})
+	c.Command("UNSUBSCRIBE").Expect([]interface{}{[]byte("unsubscribe"), []byte("/casbin"), []byte("0")})
+
+	w, err := NewWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c),
+		SquashMessages(true), SquashTimeoutShort(50*time.Millisecond), SquashMaxDelay(100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	defer w.Close()
+	rw := w.(*Watcher)
+
+	called := make(chan time.Time, 1)
+	w.SetUpdateCallback(func(string) {
+		select {
+		case called <- time.Now():
+		default:
+		}
+	})
+
+	// a message every 20ms keeps resetting the short timeout
+	start := time.Now()
+	stop := time.After(300 * time.Millisecond)
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			select {
+			case rw.messagesIn <- decodeMessage("/casbin", []byte("node2")):
+			default:
+			}
+			continue
+		case at := <-called:
+			if at.Sub(start) > 200*time.Millisecond {
+				t.Errorf("Callback should be invoked within the max delay, took %v", at.Sub(start))
+			}
+		case <-stop:
+			t.Error("Callback was deferred past the max delay")
+		}
+		break
+	}
+}
+
+func TestSquashPerSender(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c),
+		SquashMessages(true), SquashPerSender(true), EnvelopeMessages(true))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	calls := make(map[string]int)
+	var payloads []string
+	w.SetUpdateCallback(func(msg string) {
+		calls[msg[:5]]++
+		payloads = append(payloads, msg)
+	})
+	for i := 0; i < 10; i++ {
+		rw.processMessage(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Payload: "node2:" + string(rune('0'+i))})
+	}
+	rw.processMessage(&UpdateMessage{Type: UpdateMessageType, LocalID: "node3", Payload: "node3:0"})
+	rw.flushSquashed()
+
+	if calls["node2"] != 1 || calls["node3"] != 1 {
+		t.Errorf("Callback should be invoked once per sender, received %v", payloads)
+	}
+}
+
+func TestIgnoreGroup(t *testing.T) {
+	c := &publishConn{testConn: NewTestConn()}
+	c.Clear()
+
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		GroupID("app1"), IgnoreGroup(true))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	if d := rw.Explain(UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Group: "app1"}); d != DispositionIgnoredGroup {
+		t.Errorf("Message of the same group should be ignored, received '%s' instead", d)
+	}
+	if d := rw.Explain(UpdateMessage{Type: UpdateMessageType, LocalID: "node3", Group: "app2"}); d != DispositionDelivered {
+		t.Errorf("Message of another group should be delivered, received '%s' instead", d)
+	}
+
+	if err := rw.UpdateWithPayload("reason"); err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+	if msg := decodeMessage("/casbin", []byte(c.published[0])); msg.Group != "app1" {
+		t.Errorf("Published message should carry the group, received '%s'", msg.Group)
+	}
+}
+
+func TestSenderLists(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		AllowSenders("node2", "node3"), DenySenders("node3"))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	for sender, expected := range map[string]Disposition{
+		"node1": DispositionDelivered,
+		"node2": DispositionDelivered,
+		"node3": DispositionDeniedSender,
+		"node4": DispositionDeniedSender,
+	} {
+		if d := rw.Explain(UpdateMessage{Type: UpdateMessageType, LocalID: sender}); d != expected {
+			t.Errorf("Message from %s should be %s, received '%s' instead", sender, expected, d)
+		}
+	}
+}
+
+func TestMessageFilter(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		WithMessageFilter(func(msg UpdateMessage) bool { return msg.Tenant != "tenant2" }))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	var received []string
+	w.SetUpdateCallback(func(msg string) { received = append(received, msg) })
+
+	if d := rw.Explain(UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Tenant: "tenant2"}); d != DispositionFiltered {
+		t.Errorf("Expected disposition %s, got %s", DispositionFiltered, d)
+	}
+	rw.processMessage(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Tenant: "tenant2", Payload: "dropped"})
+	rw.processMessage(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Tenant: "tenant1", Payload: "kept"})
+	if len(received) != 1 || received[0] != "kept" {
+		t.Errorf("Only the update passing the filter should be delivered, received %v", received)
+	}
+}
+
+func TestMessageTransform(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		WithMessageTransform(func(msg *UpdateMessage) {
+			// legacy senders published "tenant/payload"
+			if i := strings.IndexByte(msg.Payload, '/'); i > 0 && msg.Tenant == "" {
+				msg.Tenant, msg.Payload = msg.Payload[:i], msg.Payload[i+1:]
+			}
+		}),
+		WithMessageFilter(func(msg UpdateMessage) bool { return msg.Tenant != "tenant2" }))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	var received []string
+	w.SetUpdateCallback(func(msg string) { received = append(received, msg) })
+
+	if d := rw.Explain(*decodeMessage("/casbin", []byte("tenant2/node2"))); d != DispositionFiltered {
+		t.Errorf("Transformed message should be filtered, got %s", d)
+	}
+	rw.processMessage(decodeMessage("/casbin", []byte("tenant2/node2")))
+	rw.processMessage(decodeMessage("/casbin", []byte("tenant1/node2")))
+	if len(received) != 1 || received[0] != "node2" {
+		t.Errorf("Callback should receive the transformed payload, received %v", received)
+	}
+}
+
+func TestMessageHookPanic(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		WithMessageTransform(func(msg *UpdateMessage) {
+			if msg.Payload == "transform" {
+				panic("transform")
+			}
+		}),
+		WithMessageFilter(func(msg UpdateMessage) bool {
+			if msg.Payload == "filter" {
+				panic("filter")
+			}
+			return true
+		}))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	var received []string
+	w.SetUpdateCallback(func(msg string) { received = append(received, msg) })
+
+	if d := rw.Explain(UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Payload: "filter"}); d != DispositionFiltered {
+		t.Errorf("Update should be filtered when the filter panics, got %s", d)
+	}
+	rw.processMessage(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Payload: "transform"})
+	rw.processMessage(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Payload: "filter"})
+	rw.processMessage(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Payload: "kept"})
+	if len(received) != 1 || received[0] != "kept" {
+		t.Errorf("Updates whose hooks panicked should be dropped, received %v", received)
+	}
+}
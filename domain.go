@@ -0,0 +1,31 @@
+package rediswatcher
+
+import "context"
+
+// UpdateForDomains publishes an update affecting only the policies of the
+// given RBAC domains. Watchers with a callback set by SetDomainUpdateCallback
+// receive the domains and can reload just their policies, keeping reloads
+// cheap as the number of domains grows; everywhere else, and when updates are
+// squashed, it is handled like Update.
+func (w *Watcher) UpdateForDomains(domains ...string) error {
+	if len(domains) == 0 {
+		return w.Update()
+	}
+	version, err := w.incrVersion()
+	if err != nil {
+		return err
+	}
+	return w.publishMessage(context.Background(), &UpdateMessage{
+		Type:    UpdateMessageType,
+		Version: version,
+		Payload: w.options.LocalID,
+		Domains: domains,
+	})
+}
+
+// SetDomainUpdateCallback sets the callback invoked with the domains of
+// updates published with UpdateForDomains
+func (w *Watcher) SetDomainUpdateCallback(callback func(domains []string)) error {
+	w.domainCallback = callback
+	return nil
+}
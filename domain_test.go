@@ -0,0 +1,36 @@
+package rediswatcher
+
+import "testing"
+
+func TestUpdateForDomains(t *testing.T) {
+	c := &publishConn{testConn: NewTestConn()}
+	c.Clear()
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	if err := rw.UpdateForDomains("domain1", "domain2"); err != nil {
+		t.Fatalf("Failed to publish domain update: %v", err)
+	}
+	if len(c.published) != 1 {
+		t.Fatalf("Expected 1 published message, received %d", len(c.published))
+	}
+
+	var domains []string
+	updated := false
+	w.SetUpdateCallback(func(string) { updated = true })
+	rw.SetDomainUpdateCallback(func(d []string) { domains = d })
+	rw.processMessage(decodeMessage("/casbin", []byte(c.published[0])))
+	if len(domains) != 2 || domains[0] != "domain1" || domains[1] != "domain2" || updated {
+		t.Errorf("Domain callback should receive the domains, received %v", domains)
+	}
+
+	rw.options.SquashMessages = true
+	rw.processMessage(decodeMessage("/casbin", []byte(c.published[0])))
+	rw.flushSquashed()
+	if !updated {
+		t.Error("Squashed domain updates should invoke the update callback")
+	}
+}
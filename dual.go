@@ -0,0 +1,69 @@
+package rediswatcher
+
+import (
+	"time"
+)
+
+// streamed reports whether updates are appended to the update stream
+// as well as published, by StreamTransport and DualTransport
+func (w *Watcher) streamed() bool {
+	return w.options.Transport == StreamTransport || w.options.Transport == DualTransport
+}
+
+// receivedLive records the sequence number of a message received through
+// pub/sub with DualTransport, so that catchUpStream skips its stream entry
+func (w *Watcher) receivedLive(msg *UpdateMessage) {
+	if msg.Seq == 0 {
+		return
+	}
+	if w.liveSeq == nil {
+		w.liveSeq = make(map[string]uint64)
+	}
+	key := w.sequenceKey(msg)
+	if msg.Seq > w.liveSeq[key] || msg.Seq == 1 {
+		// the first sequence number is a restarted sender
+		w.liveSeq[key] = msg.Seq
+	}
+}
+
+// seenLive reports whether the message of a stream entry was already
+// received through pub/sub
+func (w *Watcher) seenLive(msg *UpdateMessage) bool {
+	return msg.Seq != 0 && msg.Seq <= w.liveSeq[w.sequenceKey(msg)]
+}
+
+// catchUpStream reads the stream entries added after the stream position
+// with DualTransport once the subscription is confirmed, so that the updates
+// published while the watcher was disconnected are delivered. Entries whose
+// message was already received through pub/sub are skipped.
+func (w *Watcher) catchUpStream() {
+	if w.streamID == "" {
+		return
+	}
+	for {
+		startTime := time.Now()
+		reply, err := w.pubDo("XREAD", "COUNT", 100, "STREAMS", w.streamKey(), w.streamID)
+		var entries []streamEntry
+		if err == nil && reply != nil {
+			entries, err = streamEntries(reply)
+		}
+		if err != nil {
+			if w.options.RecordMetrics != nil {
+				w.options.RecordMetrics(w.createMetrics(StreamReadMetric, startTime, err))
+			}
+			w.options.Logger.Error("Failure reading missed updates from the stream", "channel", w.options.Channel, "localID", w.options.LocalID, "error", err)
+			w.handleError(err)
+			return
+		}
+		if len(entries) == 0 {
+			break
+		}
+		for _, entry := range entries {
+			w.streamID = entry.id
+			if entry.data != nil {
+				w.receiveEntry(entry, startTime)
+			}
+		}
+	}
+	w.liveSeq = nil
+}
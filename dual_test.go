@@ -0,0 +1,72 @@
+package rediswatcher
+
+import (
+	"testing"
+
+	"github.com/rafaeljusto/redigomock"
+)
+
+func TestDualPublish(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c),
+		WithTransport(DualTransport))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+
+	xadd := c.Command("XADD", "/casbin", "*", streamDataField, redigomock.NewAnyData()).Expect("1-0")
+	publish := c.Command("PUBLISH", "/casbin", redigomock.NewAnyData()).Expect(int64(2))
+	receivers, err := w.(*Watcher).UpdateWithResult()
+	if err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+	if c.Stats(xadd) != 1 || c.Stats(publish) != 1 {
+		t.Error("Update should be appended to the stream and published")
+	}
+	if receivers != 2 {
+		t.Errorf("Expected 2 receivers, got %d", receivers)
+	}
+}
+
+func TestDualCatchUp(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c),
+		WithTransport(DualTransport))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+	rw.messagesIn = make(chan *UpdateMessage, 10)
+	rw.streamID = "1-0"
+
+	entry := func(seq uint64) string {
+		data, err := rw.encode(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Seq: seq, Payload: "node2"})
+		if err != nil {
+			t.Fatalf("Failed to encode message: %v", err)
+		}
+		return string(data)
+	}
+	rw.receivedLive(&UpdateMessage{LocalID: "node2", Seq: 2})
+
+	c.Command("XREAD", "COUNT", 100, "STREAMS", "/casbin", "1-0").
+		Expect(streamReply("/casbin", streamEntryReply("2-0", entry(2)), streamEntryReply("3-0", entry(3))))
+	c.Command("XREAD", "COUNT", 100, "STREAMS", "/casbin", "3-0").Expect(nil)
+
+	rw.catchUpStream()
+	if len(rw.messagesIn) != 1 {
+		t.Fatalf("Expected 1 missed message, received %d", len(rw.messagesIn))
+	}
+	if msg := <-rw.messagesIn; msg.Seq != 3 {
+		t.Errorf("Message received through pub/sub should be skipped, received seq %d", msg.Seq)
+	}
+	if rw.streamID != "3-0" {
+		t.Errorf("Stream position should be '3-0', received '%s' instead", rw.streamID)
+	}
+	if rw.liveSeq != nil {
+		t.Error("Received sequence numbers should be reset once caught up")
+	}
+}
@@ -0,0 +1,105 @@
+package rediswatcher
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// WatcherDump is a diagnostic snapshot of the watcher returned by Dump, for
+// support bundles and debug endpoints
+type WatcherDump struct {
+	LocalID    string
+	Transport  string
+	Channels   []string
+	RemoteAddr string
+
+	// Subscriber is set for watchers created with NewWatcher, Connected
+	// when the subscription is established and the publish connection
+	// healthy
+	Subscriber bool
+	Connected  bool
+	Paused     bool
+	Leader     bool
+
+	// ReconnectAttempts is the number of failed attempts since the
+	// connection was lost, 0 while connected
+	ReconnectAttempts int
+	// CircuitOpen is set while the CircuitBreaker fails publishes, Outbox
+	// is the number of messages waiting to be published
+	CircuitOpen bool
+	Outbox      int
+
+	// PolicyVersion counts the updates processed
+	PolicyVersion uint64
+
+	Stats WatcherStats
+}
+
+// Dump returns a diagnostic snapshot of the watcher's state, it is safe to
+// call concurrently with the watcher's operation
+func (w *Watcher) Dump() WatcherDump {
+	remoteAddr, _ := w.remoteAddr.Load().(string)
+	w.channelsMu.Lock()
+	channels := append([]string(nil), w.channels...)
+	w.channelsMu.Unlock()
+	w.outboxMu.Lock()
+	outbox := len(w.outbox)
+	w.outboxMu.Unlock()
+
+	return WatcherDump{
+		LocalID:           w.options.LocalID,
+		Transport:         w.options.Transport,
+		Channels:          channels,
+		RemoteAddr:        remoteAddr,
+		Subscriber:        w.subscriber,
+		Connected:         w.IsConnected(),
+		Paused:            w.isPaused(),
+		Leader:            w.IsLeader(),
+		ReconnectAttempts: int(atomic.LoadInt32(&w.reconnectAttempts)),
+		CircuitOpen:       w.breaker != nil && !w.breaker.isClosed(),
+		Outbox:            outbox,
+		PolicyVersion:     atomic.LoadUint64(&w.policyVersion),
+		Stats:             w.Stats(),
+	}
+}
+
+// String returns the Dump of the watcher as "key: value" lines
+func (w *Watcher) String() string {
+	d := w.Dump()
+	var b strings.Builder
+	line := func(key string, value interface{}) {
+		fmt.Fprintf(&b, "%s: %v\n", key, value)
+	}
+	line("localID", d.LocalID)
+	line("transport", d.Transport)
+	line("channels", strings.Join(d.Channels, ", "))
+	line("remoteAddr", d.RemoteAddr)
+	line("subscriber", d.Subscriber)
+	line("connected", d.Connected)
+	line("paused", d.Paused)
+	line("leader", d.Leader)
+	line("reconnectAttempts", d.ReconnectAttempts)
+	line("circuitOpen", d.CircuitOpen)
+	line("outbox", d.Outbox)
+	line("policyVersion", d.PolicyVersion)
+	line("published", d.Stats.Published)
+	line("received", d.Stats.Received)
+	line("squashed", d.Stats.Squashed)
+	line("ignoredSelf", d.Stats.IgnoredSelf)
+	line("reconnects", d.Stats.ReconnectAttempts)
+	line("queueDepth", d.Stats.QueueDepth)
+	line("queueLag", d.Stats.QueueLag)
+	line("lastMessage", formatTime(d.Stats.LastMessage))
+	line("lastError", formatTime(d.Stats.LastError))
+	return b.String()
+}
+
+// formatTime formats t for String, "never" if zero
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
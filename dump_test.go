@@ -0,0 +1,60 @@
+package rediswatcher
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	c := &publishConn{testConn: NewTestConn()}
+	c.Clear()
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		Channels([]string{"/casbin", "/tenant1"}))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+
+	d := rw.Dump()
+	if d.LocalID != "node1" || len(d.Channels) != 2 || d.Subscriber {
+		t.Errorf("Unexpected dump %+v", d)
+	}
+	if d.Stats.Published != 1 {
+		t.Errorf("Dump should include the stats, published %d", d.Stats.Published)
+	}
+
+	s := rw.String()
+	for _, line := range []string{"localID: node1\n", "channels: /casbin, /tenant1\n", "published: 1\n", "lastMessage: never\n"} {
+		if !strings.Contains(s, line) {
+			t.Errorf("String should contain %q, received:\n%s", line, s)
+		}
+	}
+}
+
+func TestGetWatcherOptions(t *testing.T) {
+	c := &publishConn{testConn: NewTestConn()}
+	c.Clear()
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		Password("secret"), Channels([]string{"/casbin", "/tenant1"}))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	o := rw.GetWatcherOptions()
+	if o.LocalID != "node1" || !o.Authenticated || !o.InjectedConnections {
+		t.Errorf("Unexpected options %+v", o)
+	}
+	if strings.Contains(fmt.Sprintf("%+v", o), "secret") {
+		t.Errorf("Options should not contain the password: %+v", o)
+	}
+
+	o.Channels[0] = "/changed"
+	if rw.options.Channels[0] != "/casbin" {
+		t.Errorf("Options should be a copy, channels %v", rw.options.Channels)
+	}
+}
@@ -0,0 +1,196 @@
+package rediswatcher
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultLatencyProbeInterval = 30 * time.Second
+	defaultLatencyHysteresis    = 0.2
+)
+
+// endpointSelector picks the endpoint used for new connections when several
+// redis addresses are configured. It prefers the fastest healthy endpoint but
+// only switches when another endpoint is faster by the hysteresis fraction,
+// so that similar latencies don't make the watcher flap between endpoints.
+// With failover set it keeps the current endpoint until a connection to it
+// fails and then moves on to the next one in order.
+type endpointSelector struct {
+	mu         sync.Mutex
+	addrs      []string
+	latency    map[string]time.Duration
+	healthy    map[string]bool
+	preferred  string
+	hysteresis float64
+	failover   bool
+}
+
+func newEndpointSelector(addrs []string, hysteresis float64) *endpointSelector {
+	s := &endpointSelector{
+		addrs:      addrs,
+		latency:    make(map[string]time.Duration),
+		healthy:    make(map[string]bool),
+		hysteresis: hysteresis,
+	}
+	for _, addr := range addrs {
+		s.healthy[addr] = true
+	}
+	if len(addrs) > 0 {
+		s.preferred = addrs[0]
+	}
+	return s
+}
+
+// current returns the preferred endpoint
+func (s *endpointSelector) current() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.preferred
+}
+
+// record stores the outcome of probing addr and re-evaluates the preferred
+// endpoint
+func (s *endpointSelector) record(addr string, latency time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.healthy[addr] = err == nil
+	if err == nil {
+		s.latency[addr] = latency
+	}
+	s.reselect()
+}
+
+// failed moves a failover selector on to the endpoint after addr if addr is
+// the current one, it returns the new endpoint or "" if it didn't change
+func (s *endpointSelector) failed(addr string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.failover || addr != s.preferred || len(s.addrs) < 2 {
+		return ""
+	}
+	for i, a := range s.addrs {
+		if a == addr {
+			s.preferred = s.addrs[(i+1)%len(s.addrs)]
+			break
+		}
+	}
+	return s.preferred
+}
+
+func (s *endpointSelector) reselect() {
+	best := ""
+	for _, addr := range s.addrs {
+		if _, measured := s.latency[addr]; !measured || !s.healthy[addr] {
+			continue
+		}
+		if best == "" || s.latency[addr] < s.latency[best] {
+			best = addr
+		}
+	}
+	if best == "" || best == s.preferred {
+		return
+	}
+	if s.healthy[s.preferred] {
+		threshold := time.Duration(float64(s.latency[s.preferred]) * (1 - s.hysteresis))
+		if s.latency[best] >= threshold {
+			return
+		}
+	}
+	s.preferred = best
+}
+
+// initEndpoints sets up endpoint selection when more than one address is
+// configured through addr and the Addresses option
+func (w *Watcher) initEndpoints(addr string) {
+	var addrs []string
+	if addr != "" {
+		addrs = append(addrs, addr)
+	}
+	addrs = append(addrs, w.options.Addresses...)
+	if len(w.options.Addresses) > 0 {
+		w.endpoints = newEndpointSelector(addrs, w.options.LatencyHysteresis)
+		w.endpoints.failover = w.options.Failover
+	}
+}
+
+// endpointFailed reports a failed connection to addr, with FailoverAddresses
+// the following connections use the next address
+func (w *Watcher) endpointFailed(addr string, err error) {
+	if w.options.SRVName != "" {
+		// the next lookup returns the following target
+		atomic.AddUint32(&w.srvFailures, 1)
+		w.srvTarget.Store("")
+	}
+	if w.endpoints == nil {
+		return
+	}
+	if next := w.endpoints.failed(addr); next != "" {
+		w.options.Logger.Warn("Failing over to the next redis address", "channel", w.options.Channel, "localID", w.options.LocalID, "addr", addr, "next", next, "error", err)
+	}
+}
+
+// connectAttempts returns how many times connect tries to establish the
+// connections, once per address with FailoverAddresses
+func (w *Watcher) connectAttempts() int {
+	if w.endpoints == nil || !w.endpoints.failover {
+		return 1
+	}
+	return len(w.endpoints.addrs)
+}
+
+// probeEndpoints periodically measures the PING latency of every configured
+// endpoint until the watcher is closed
+func (w *Watcher) probeEndpoints() {
+	ticker := time.NewTicker(w.options.LatencyProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		for _, addr := range w.endpoints.addrs {
+			latency, err := w.probe(addr)
+			w.endpoints.record(addr, latency, err)
+		}
+
+		select {
+		case <-w.closed:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *Watcher) probe(addr string) (time.Duration, error) {
+	c, err := w.dial(addr)
+	if err != nil {
+		return 0, err
+	}
+	defer (*c).Close()
+
+	startTime := time.Now()
+	_, err = (*c).Do("PING")
+	latency := time.Since(startTime)
+	if w.options.RecordMetrics != nil {
+		w.options.RecordMetrics(w.createMetrics(RedisPingMetric, startTime, err))
+	}
+	return latency, err
+}
+
+// endpoint returns the address new connections should be dialed to, looked
+// up from SRVName if set
+func (w *Watcher) endpoint(addr string) string {
+	if w.options.SRVName != "" {
+		target, err := w.lookupSRV()
+		if err == nil {
+			return target
+		}
+		w.options.Logger.Error("Failure looking up SRV record", "channel", w.options.Channel, "localID", w.options.LocalID, "name", w.options.SRVName, "error", err)
+		w.handleError(err)
+	}
+	if w.endpoints == nil {
+		return addr
+	}
+	return w.endpoints.current()
+}
@@ -0,0 +1,61 @@
+package rediswatcher
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEndpointSelector(t *testing.T) {
+	s := newEndpointSelector([]string{"a:6379", "b:6379"}, 0.2)
+
+	if s.current() != "a:6379" {
+		t.Fatalf("First endpoint should be preferred initially, received '%s' instead", s.current())
+	}
+
+	s.record("a:6379", 10*time.Millisecond, nil)
+	s.record("b:6379", 9*time.Millisecond, nil)
+	if s.current() != "a:6379" {
+		t.Errorf("Slightly faster endpoint should not be preferred, received '%s' instead", s.current())
+	}
+
+	s.record("b:6379", 5*time.Millisecond, nil)
+	if s.current() != "b:6379" {
+		t.Errorf("Much faster endpoint should be preferred, received '%s' instead", s.current())
+	}
+
+	s.record("b:6379", 0, errors.New("connection refused"))
+	if s.current() != "a:6379" {
+		t.Errorf("Unhealthy endpoint should not be preferred, received '%s' instead", s.current())
+	}
+}
+
+func TestEndpointFailover(t *testing.T) {
+	s := newEndpointSelector([]string{"a:6379", "b:6379", "c:6379"}, 0.2)
+	s.failover = true
+
+	if next := s.failed("b:6379"); next != "" {
+		t.Errorf("Failure of another endpoint should not fail over, received '%s'", next)
+	}
+	if next := s.failed("a:6379"); next != "b:6379" {
+		t.Errorf("Expected failover to 'b:6379', received '%s' instead", next)
+	}
+	s.failed("b:6379")
+	if next := s.failed("c:6379"); next != "a:6379" {
+		t.Errorf("Failover should wrap around to 'a:6379', received '%s' instead", next)
+	}
+}
+
+func TestFailoverAddresses(t *testing.T) {
+	w := &Watcher{options: defaultWatcherOptions()}
+	w.options.Logger = &testLogger{}
+	FailoverAddresses("127.0.0.1:1", "127.0.0.1:2")(&w.options)
+	w.initEndpoints("")
+
+	if err := w.connect(""); err == nil {
+		t.Fatal("Connecting should fail when no address is reachable")
+	}
+	if w.endpoints.current() != "127.0.0.1:1" {
+		t.Errorf("Every address should have been tried once, current is '%s'", w.endpoints.current())
+	}
+}
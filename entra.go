@@ -0,0 +1,58 @@
+package rediswatcher
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// EntraScope is the scope of the Microsoft Entra ID access tokens accepted by
+// Azure Cache for Redis
+const EntraScope = "https://redis.azure.com/.default"
+
+// entraRefreshMargin is how long before it expires an Entra token is replaced
+const entraRefreshMargin = 5 * time.Minute
+
+// EntraTokenFunc returns a Microsoft Entra ID access token for EntraScope and
+// when it expires, for instance from an azidentity credential's GetToken
+type EntraTokenFunc func(ctx context.Context) (token string, expiresOn time.Time, err error)
+
+// EntraCredentials authenticates with Azure Cache for Redis using Microsoft
+// Entra ID, with the object ID of the identity as username and an access
+// token as password. The token is cached until shortly before it expires.
+type EntraCredentials struct {
+	objectID string
+	token    EntraTokenFunc
+
+	mu        sync.Mutex
+	cached    string
+	expiresOn time.Time
+}
+
+// NewEntraCredentials returns the credentials of the identity objectID,
+// whose access tokens are returned by token
+func NewEntraCredentials(objectID string, token EntraTokenFunc) *EntraCredentials {
+	return &EntraCredentials{objectID: objectID, token: token}
+}
+
+// Credentials returns the object ID and a token valid for at least a few
+// more minutes, requesting a new one if needed
+func (c *EntraCredentials) Credentials(ctx context.Context) (string, string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cached == "" || time.Until(c.expiresOn) < entraRefreshMargin {
+		token, expiresOn, err := c.token(ctx)
+		if err != nil {
+			return "", "", err
+		}
+		c.cached, c.expiresOn = token, expiresOn
+	}
+	return c.objectID, c.cached, nil
+}
+
+// ExpiresOn returns when the current token expires
+func (c *EntraCredentials) ExpiresOn() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.expiresOn
+}
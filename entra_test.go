@@ -0,0 +1,86 @@
+package rediswatcher
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEntraCredentials(t *testing.T) {
+	calls := 0
+	expiresOn := time.Now().Add(time.Hour)
+	c := NewEntraCredentials("object-id", func(ctx context.Context) (string, time.Time, error) {
+		calls++
+		return "token" + strconv.Itoa(calls), expiresOn, nil
+	})
+
+	c.Credentials(context.Background())
+	username, password, err := c.Credentials(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get credentials: %v", err)
+	}
+	if username != "object-id" || password != "token1" {
+		t.Errorf("Expected the cached token, received %s/%s", username, password)
+	}
+
+	expiresOn = time.Now().Add(time.Minute)
+	c.expiresOn = expiresOn
+	if _, password, _ := c.Credentials(context.Background()); password != "token2" {
+		t.Errorf("Token about to expire should be replaced, received %s", password)
+	}
+	if !c.ExpiresOn().Equal(expiresOn) {
+		t.Errorf("Expected expiry %v, received %v", expiresOn, c.ExpiresOn())
+	}
+}
+
+func TestEntraReauth(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c),
+		EntraAuth("object-id", func(ctx context.Context) (string, time.Time, error) {
+			return "token", time.Now().Add(time.Hour), nil
+		}))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	defer w.Close()
+
+	auth := c.Command("AUTH", "object-id", "token").Expect("OK")
+	if err := w.(*Watcher).reauth(); err != nil {
+		t.Fatalf("Failed to authenticate: %v", err)
+	}
+	if c.Stats(auth) != 1 {
+		t.Error("Publish connection should be authenticated with the fresh token")
+	}
+}
+
+func TestReauthResubscribe(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	errs := make(chan error, 2)
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c),
+		MaxReconnectAttempts(1), ReconnectFailureCallback(func(err error) { errs <- err }))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	defer w.Close()
+	rw := w.(*Watcher)
+	refused := errors.New("connection refused")
+	c.Command("SUBSCRIBE", "/casbin").ExpectError(errors.New("use of closed network connection"))
+	c.Command("UNSUBSCRIBE").ExpectError(refused)
+
+	// the first failure is the connection closed by reauth
+	atomic.StoreInt32(&rw.reauthing, 1)
+	go rw.subscribeLoop("", false)
+	select {
+	case err := <-errs:
+		if re, ok := err.(*ReconnectError); !ok || re.Attempts != 1 || re.Err != refused {
+			t.Errorf("Closing the connection for reauth should not count as a failure, received '%v'", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Watcher should resubscribe right away after reauth")
+	}
+}
@@ -0,0 +1,110 @@
+package rediswatcher
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// FanoutError reports a failure publishing an update to one of the
+// FanoutAddresses. The update was published on the main connection.
+type FanoutError struct {
+	Addr string
+	Err  error
+}
+
+func (e *FanoutError) Error() string {
+	return fmt.Sprintf("rediswatcher: publishing to %s: %v", e.Addr, e.Err)
+}
+
+// fanoutTarget is an additional redis the updates are published to, its
+// connection is dialed on first use and again after a failure
+type fanoutTarget struct {
+	addr string
+	mu   sync.Mutex
+	conn redis.Conn
+}
+
+// initFanout sets up the FanoutAddresses
+func (w *Watcher) initFanout() {
+	w.fanout = make([]*fanoutTarget, 0, len(w.options.FanoutAddresses))
+	for _, addr := range w.options.FanoutAddresses {
+		w.fanout = append(w.fanout, &fanoutTarget{addr: addr})
+	}
+}
+
+// publishFanout publishes sealed data to channel on every fanout redis and
+// returns the number of subscribers that received it. Failures are reported
+// per address as a FanoutError and don't fail the update.
+func (w *Watcher) publishFanout(channel string, data string, id string) int64 {
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		receivers int64
+	)
+	for _, target := range w.fanout {
+		target := target
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n, err := w.publishTo(target, channel, data, id)
+			if err != nil {
+				err = &FanoutError{Addr: target.addr, Err: err}
+				w.options.Logger.Error("Failure publishing to fanout address", "channel", w.options.Channel, "localID", w.options.LocalID, "addr", target.addr, "error", err)
+				w.handleError(err)
+				return
+			}
+			mu.Lock()
+			receivers += n
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return receivers
+}
+
+// publishTo publishes sealed data to channel on a fanout redis
+func (w *Watcher) publishTo(target *fanoutTarget, channel string, data string, id string) (int64, error) {
+	target.mu.Lock()
+	defer target.mu.Unlock()
+
+	startTime := time.Now()
+	var reply interface{}
+	var err error
+	if target.conn == nil {
+		var c *redis.Conn
+		if c, err = w.dial(target.addr); err == nil {
+			target.conn = *c
+		}
+	}
+	if err == nil {
+		reply, err = target.conn.Do("PUBLISH", w.prefixed(channel), data)
+		if err != nil {
+			target.conn.Close()
+			target.conn = nil
+		}
+	}
+	receivers, _ := reply.(int64)
+	if w.options.RecordMetrics != nil {
+		m := w.createMetrics(FanoutPublishMetric, startTime, err)
+		m.MessageID = id
+		m.RemoteAddr = target.addr
+		m.Receivers = receivers
+		w.options.RecordMetrics(m)
+	}
+	return receivers, err
+}
+
+// closeFanout closes the connections to the fanout redis
+func (w *Watcher) closeFanout() {
+	for _, target := range w.fanout {
+		target.mu.Lock()
+		if target.conn != nil {
+			target.conn.Close()
+			target.conn = nil
+		}
+		target.mu.Unlock()
+	}
+}
@@ -0,0 +1,50 @@
+package rediswatcher
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestFanoutPublish(t *testing.T) {
+	c := &publishConn{testConn: NewTestConn()}
+	c.Clear()
+	var (
+		mu   sync.Mutex
+		errs []error
+	)
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		WithLogger(&testLogger{}), FanoutAddresses("eu:6379", "us:6379"),
+		WithErrorHandler(func(err error) {
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+		}))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+	eu := &publishConn{testConn: NewTestConn()}
+	us := &flakyConn{testConn: NewTestConn(), failures: 1}
+	rw.fanout[0].conn = eu
+	rw.fanout[1].conn = us
+
+	receivers, err := rw.UpdateWithResult()
+	if err != nil {
+		t.Fatalf("Update should succeed when a fanout address fails: %v", err)
+	}
+	if receivers != 2 {
+		t.Errorf("Expected 2 receivers, got %d", receivers)
+	}
+	if len(c.published) != 1 || len(eu.published) != 1 {
+		t.Errorf("Update should be published on the main connection and to eu:6379")
+	}
+	if len(errs) != 1 {
+		t.Fatalf("Expected 1 error, got %v", errs)
+	}
+	if fe, ok := errs[0].(*FanoutError); !ok || fe.Addr != "us:6379" {
+		t.Errorf("Expected a FanoutError for us:6379, got %v", errs[0])
+	}
+	if rw.fanout[1].conn != nil {
+		t.Error("Failed fanout connection should be dialed again")
+	}
+}
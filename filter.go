@@ -0,0 +1,44 @@
+package rediswatcher
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// UpdateForFilter publishes an update affecting only the policies matched by
+// filter, the filter passed to LoadFilteredPolicy of the enforcer's adapter.
+// The filter is JSON encoded, so it must be a type that round trips through
+// encoding/json such as fileadapter.Filter. Watchers with a callback set by
+// SetFilteredUpdateCallback receive the encoded filter and reload only the
+// matching policies; everywhere else, and when updates are squashed, it is
+// handled like Update.
+func (w *Watcher) UpdateForFilter(filter interface{}) error {
+	data, err := json.Marshal(filter)
+	if err != nil {
+		return err
+	}
+	version, err := w.incrVersion()
+	if err != nil {
+		return err
+	}
+	return w.publishMessage(context.Background(), &UpdateMessage{
+		Type:    UpdateMessageType,
+		Version: version,
+		Payload: w.options.LocalID,
+		Filter:  string(data),
+	})
+}
+
+// SetFilteredUpdateCallback sets the callback invoked with the JSON encoded
+// filter of updates published with UpdateForFilter, e.g.
+//
+//	w.SetFilteredUpdateCallback(func(data []byte) {
+//		var filter fileadapter.Filter
+//		if err := json.Unmarshal(data, &filter); err == nil {
+//			e.LoadFilteredPolicy(&filter)
+//		}
+//	})
+func (w *Watcher) SetFilteredUpdateCallback(callback func(filter []byte)) error {
+	w.filteredCallback = callback
+	return nil
+}
@@ -0,0 +1,37 @@
+package rediswatcher
+
+import "testing"
+
+func TestUpdateForFilter(t *testing.T) {
+	c := &publishConn{testConn: NewTestConn()}
+	c.Clear()
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	filter := struct{ P, G []string }{P: []string{"", "domain1"}}
+	if err := rw.UpdateForFilter(filter); err != nil {
+		t.Fatalf("Failed to publish filtered update: %v", err)
+	}
+	if len(c.published) != 1 {
+		t.Fatalf("Expected 1 published message, received %d", len(c.published))
+	}
+	msg := decodeMessage("/casbin", []byte(c.published[0]))
+
+	var updated string
+	var filtered []byte
+	w.SetUpdateCallback(func(msg string) { updated = msg })
+	rw.processMessage(msg)
+	if updated != "node1" {
+		t.Errorf("Without a filtered callback the update callback should be invoked, received '%s'", updated)
+	}
+
+	rw.SetFilteredUpdateCallback(func(filter []byte) { filtered = filter })
+	updated = ""
+	rw.processMessage(msg)
+	if string(filtered) != `{"P":["","domain1"],"G":null}` || updated != "" {
+		t.Errorf("Filtered callback should receive the encoded filter, received '%s'", filtered)
+	}
+}
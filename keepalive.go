@@ -0,0 +1,59 @@
+package rediswatcher
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	// missedPongs is how many KeepAlive intervals may pass without a reply
+	// before the subscribed connection is considered dead
+	missedPongs   = 2
+	keepAlivePing = "keepalive"
+)
+
+// keepAliveInterval returns how often the subscribed connection is PINGed,
+// KeepAliveInterval or, with only a ReceiveTimeout, half of it so that an
+// idle but healthy connection doesn't time out
+func (w *Watcher) keepAliveInterval() time.Duration {
+	if w.options.KeepAliveInterval <= 0 && w.options.ReceiveTimeout > 0 {
+		return w.options.ReceiveTimeout / 2
+	}
+	return w.options.KeepAliveInterval
+}
+
+// keepAlive PINGs the subscribed connection conn every keepAliveInterval
+// until stop is closed. When nothing was received for missedPongs intervals
+// the connection is closed, so that receive fails and the watcher reconnects
+// instead of waiting on a half-open connection.
+func (w *Watcher) keepAlive(psc pubSubConn, conn redis.Conn, stop <-chan struct{}) {
+	interval := w.keepAliveInterval()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		silent := time.Since(time.Unix(0, atomic.LoadInt64(&w.lastReceived)))
+		if silent > missedPongs*interval {
+			w.options.Logger.Warn("No reply to keepalive PING, reconnecting", "channel", w.options.Channel, "localID", w.options.LocalID, "silent", silent)
+			if w.options.RecordMetrics != nil {
+				w.options.RecordMetrics(w.createMetrics(KeepAliveTimeoutMetric, time.Now(), nil))
+			}
+			conn.Close()
+			return
+		}
+		w.channelsMu.Lock()
+		err := psc.Ping(keepAlivePing)
+		w.channelsMu.Unlock()
+		if err != nil {
+			// receive fails with the same error
+			return
+		}
+	}
+}
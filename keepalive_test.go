@@ -0,0 +1,50 @@
+package rediswatcher
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// pingConn counts the keepalive PINGs sent on a subscribed connection
+type pingConn struct {
+	pubSubConn
+	pings int32
+}
+
+func (c *pingConn) Ping(data string) error {
+	atomic.AddInt32(&c.pings, 1)
+	return nil
+}
+
+func TestKeepAlive(t *testing.T) {
+	c := NewTestConn()
+	var closed int32
+	c.CloseMock = func() error {
+		atomic.StoreInt32(&closed, 1)
+		return nil
+	}
+	w := &Watcher{options: defaultWatcherOptions()}
+	w.options.Logger = &testLogger{}
+	KeepAlive(10 * time.Millisecond)(&w.options)
+
+	psc := &pingConn{}
+	atomic.StoreInt64(&w.lastReceived, time.Now().UnixNano())
+	done := make(chan struct{})
+	go func() {
+		w.keepAlive(psc, c, make(chan struct{}))
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Connection without replies should be considered dead")
+	}
+	if atomic.LoadInt32(&psc.pings) == 0 {
+		t.Error("Connection should be PINGed")
+	}
+	if atomic.LoadInt32(&closed) != 1 {
+		t.Error("Dead connection should be closed")
+	}
+}
@@ -0,0 +1,33 @@
+package rediswatcher
+
+import (
+	"errors"
+	"strconv"
+)
+
+var (
+	errNoVersionKey     = errors.New("rediswatcher: KeyspaceTransport and PollTransport require a VersionKey")
+	errVersionTransport = errors.New("rediswatcher: KeyspaceTransport and PollTransport can't publish messages")
+)
+
+// versionTransport reports whether updates are only signalled through
+// VersionKey, by KeyspaceTransport and PollTransport
+func (w *Watcher) versionTransport() bool {
+	return w.options.Transport == KeyspaceTransport || w.options.Transport == PollTransport
+}
+
+// keyspaceChannel returns the keyspace notification channel of VersionKey
+func (w *Watcher) keyspaceChannel() string {
+	return "__keyspace@" + strconv.Itoa(w.options.KeyspaceDB) + "__:" + w.options.VersionKey
+}
+
+// keyspaceMessage turns a keyspace notification, whose data is the name of
+// the command that modified the version key, into an update
+func (w *Watcher) keyspaceMessage(event []byte) *UpdateMessage {
+	return &UpdateMessage{
+		Type:    UpdateMessageType,
+		LocalID: string(event),
+		Payload: string(event),
+		Channel: w.options.Channel,
+	}
+}
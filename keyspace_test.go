@@ -0,0 +1,48 @@
+package rediswatcher
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeyspaceTransport(t *testing.T) {
+	if _, err := NewWatcher("", WithTransport(KeyspaceTransport)); err != errNoVersionKey {
+		t.Errorf("KeyspaceTransport without a VersionKey should fail, received %v", err)
+	}
+
+	c := NewTestConn()
+	c.Clear()
+	c.ReceiveWait = true
+	c.Command("SUBSCRIBE", "__keyspace@2__:casbin:version").Expect([]interface{}{[]byte("subscribe"), []byte("__keyspace@2__:casbin:version"), []byte("1")})
+	c.Command("GET", "casbin:version").Expect(nil)
+	c.Command("UNSUBSCRIBE").Expect([]interface{}{[]byte("unsubscribe"), []byte("__keyspace@2__:casbin:version"), []byte("0")})
+	c.Command("INCR", "casbin:version").Expect(int64(1))
+	c.AddSubscriptionMessage([]interface{}{[]byte("message"), []byte("__keyspace@2__:casbin:version"), []byte("incr")})
+
+	w, err := NewWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c),
+		WithTransport(KeyspaceTransport), VersionKey("casbin:version"), KeyspaceDB(2), VerifyChannel(true))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	defer w.Close()
+
+	ch := make(chan string, 1)
+	w.SetUpdateCallback(func(msg string) { ch <- msg })
+	go func() {
+		c.ReceiveNow <- true
+		c.ReceiveNow <- true
+	}()
+
+	select {
+	case msg := <-ch:
+		if msg != "incr" {
+			t.Errorf("Callback should receive the event 'incr', received '%s' instead", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Keyspace notification was not delivered")
+	}
+
+	if err := w.Update(); err != nil {
+		t.Fatalf("Update should only bump the version key: %v", err)
+	}
+}
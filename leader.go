@@ -0,0 +1,136 @@
+package rediswatcher
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// VersionMessageType messages carry the policy version periodically
+// broadcast with VersionBroadcast
+const VersionMessageType = "version"
+
+const defaultLeaderTTL = 15 * time.Second
+
+// IsLeader reports whether the watcher currently holds the LeaderElection
+// lock. Watchers without LeaderElection always report false.
+func (w *Watcher) IsLeader() bool {
+	return atomic.LoadInt32(&w.leader) == 1
+}
+
+// elect tries to acquire or renew the leader lock every third of the
+// LeaderTTL until the watcher is closed
+func (w *Watcher) elect() {
+	w.campaign()
+	ticker := time.NewTicker(w.options.LeaderTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.closed:
+			return
+		case <-ticker.C:
+			w.campaign()
+		}
+	}
+}
+
+// campaign renews the leader lock if the watcher holds it, or acquires it
+// if no watcher does
+func (w *Watcher) campaign() {
+	var ok bool
+	var err error
+	if w.IsLeader() {
+		ok, err = w.storage.CompareAndExpire(w.options.LeaderKey, []byte(w.options.LocalID), w.options.LeaderTTL)
+	} else {
+		ok, err = w.storage.SetNX(w.options.LeaderKey, []byte(w.options.LocalID), w.options.LeaderTTL)
+	}
+	if err != nil {
+		w.options.Logger.Error("Failure campaigning for leadership", "channel", w.options.Channel, "localID", w.options.LocalID, "key", w.options.LeaderKey, "error", err)
+		w.handleError(err)
+		// keep the leadership until the lock may have expired, which is
+		// before the next campaign once two thirds of the LeaderTTL passed
+		// since the last renewal
+		renewed := time.Unix(0, atomic.LoadInt64(&w.leaderRenewed))
+		if w.IsLeader() && time.Since(renewed) >= w.options.LeaderTTL-w.options.LeaderTTL/3 {
+			w.setLeader(false)
+		}
+		return
+	}
+	if ok {
+		atomic.StoreInt64(&w.leaderRenewed, time.Now().UnixNano())
+	}
+	w.setLeader(ok)
+}
+
+// resign releases the leader lock when the watcher is closed
+func (w *Watcher) resign() {
+	if _, err := w.storage.CompareAndDelete(w.options.LeaderKey, []byte(w.options.LocalID)); err != nil {
+		w.options.Logger.Error("Failure releasing leadership", "channel", w.options.Channel, "localID", w.options.LocalID, "key", w.options.LeaderKey, "error", err)
+		w.handleError(err)
+	}
+	w.setLeader(false)
+}
+
+func (w *Watcher) setLeader(leader bool) {
+	var value int32
+	if leader {
+		value = 1
+	}
+	if atomic.SwapInt32(&w.leader, value) != value {
+		w.options.Logger.Info("Leadership changed", "channel", w.options.Channel, "localID", w.options.LocalID, "leader", leader)
+		if w.options.OnLeaderChange != nil {
+			w.options.OnLeaderChange(leader)
+		}
+	}
+}
+
+// broadcastVersions publishes the policy version every VersionBroadcast
+// interval until the watcher is closed, only while leading with
+// LeaderElection
+func (w *Watcher) broadcastVersions() {
+	ticker := time.NewTicker(w.options.VersionBroadcastInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.closed:
+			return
+		case <-ticker.C:
+			if w.options.LeaderKey != "" && !w.IsLeader() {
+				continue
+			}
+			if err := w.broadcastVersion(); err != nil {
+				w.options.Logger.Error("Failure broadcasting policy version", "channel", w.options.Channel, "localID", w.options.LocalID, "key", w.options.VersionKey, "error", err)
+				w.handleError(err)
+			}
+		}
+	}
+}
+
+// broadcastVersion publishes the current value of VersionKey
+func (w *Watcher) broadcastVersion() error {
+	data, err := w.storage.Get(w.options.VersionKey)
+	if err != nil || data == nil {
+		return err
+	}
+	version, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return err
+	}
+	return w.publishMessage(context.Background(), &UpdateMessage{Type: VersionMessageType, Version: version})
+}
+
+// handleVersion reloads the policy when a broadcast version is ahead of the
+// last version seen, meaning updates were missed
+func (w *Watcher) handleVersion(msg *UpdateMessage) {
+	if msg.LocalID == w.options.LocalID || !w.syncVersion(msg.Version) {
+		return
+	}
+	w.options.Logger.Info("Broadcast policy version ahead, reloading", "channel", w.options.Channel, "localID", w.options.LocalID, "version", msg.Version)
+	if w.options.RecordMetrics != nil {
+		w.options.RecordMetrics(w.createMetrics(MissedPushMetric, time.Now(), nil))
+	}
+	if w.hasCallback() {
+		w.deliver(context.Background(), w.options.LocalID)
+	}
+}
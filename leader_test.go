@@ -0,0 +1,139 @@
+package rediswatcher
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLeaderElection(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	storage := newMemoryStorage()
+	var changes int32
+	w1, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		WithStorage(storage), LeaderElection("leader", time.Minute),
+		OnLeaderChange(func(bool) { atomic.AddInt32(&changes, 1) }))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	w2, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node2"),
+		WithStorage(storage), LeaderElection("leader", time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	defer w2.Close()
+	rw1, rw2 := w1.(*Watcher), w2.(*Watcher)
+
+	// the election goroutines race for the lock, campaign deterministically
+	rw1.campaign()
+	rw2.campaign()
+	if rw1.IsLeader() == rw2.IsLeader() {
+		t.Fatalf("Exactly one watcher should lead, node1 %v, node2 %v", rw1.IsLeader(), rw2.IsLeader())
+	}
+	leader, follower := rw1, rw2
+	if rw2.IsLeader() {
+		leader, follower = rw2, rw1
+	}
+	leader.campaign()
+	if !leader.IsLeader() {
+		t.Error("Leader should renew its leadership")
+	}
+
+	leader.Close()
+	if leader.IsLeader() {
+		t.Error("Closed watcher should resign")
+	}
+	follower.campaign()
+	if !follower.IsLeader() {
+		t.Error("Follower should take over once the leader resigned")
+	}
+	if atomic.LoadInt32(&changes) == 0 {
+		t.Error("OnLeaderChange should be called")
+	}
+}
+
+// failingStorage fails the leader lock calls once fail is set
+type failingStorage struct {
+	*memoryStorage
+	fail int32
+}
+
+func (s *failingStorage) SetNX(key string, value []byte, ttl time.Duration) (bool, error) {
+	if atomic.LoadInt32(&s.fail) == 1 {
+		return false, errors.New("storage down")
+	}
+	return s.memoryStorage.SetNX(key, value, ttl)
+}
+
+func (s *failingStorage) CompareAndExpire(key string, value []byte, ttl time.Duration) (bool, error) {
+	if atomic.LoadInt32(&s.fail) == 1 {
+		return false, errors.New("storage down")
+	}
+	return s.memoryStorage.CompareAndExpire(key, value, ttl)
+}
+
+func TestLeaderStepsDown(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	storage := &failingStorage{memoryStorage: newMemoryStorage()}
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		WithStorage(storage), LeaderElection("leader", 30*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	defer w.Close()
+	rw := w.(*Watcher)
+
+	rw.campaign()
+	if !rw.IsLeader() {
+		t.Fatal("Watcher should lead")
+	}
+	atomic.StoreInt32(&storage.fail, 1)
+	rw.campaign()
+	if !rw.IsLeader() {
+		t.Error("Leader should keep the leadership while its lock is valid")
+	}
+	time.Sleep(30 * time.Millisecond)
+	rw.campaign()
+	if rw.IsLeader() {
+		t.Error("Leader should step down once its lock may have expired")
+	}
+}
+
+func TestVersionBroadcast(t *testing.T) {
+	c := &publishConn{testConn: NewTestConn()}
+	c.Clear()
+	storage := newMemoryStorage()
+	storage.Set("version", []byte("5"), 0)
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		WithStorage(storage), VersionKey("version"))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	if err := rw.broadcastVersion(); err != nil {
+		t.Fatalf("Failed to broadcast version: %v", err)
+	}
+	msg := decodeMessage("/casbin", []byte(c.published[0]))
+	if msg.Type != VersionMessageType || msg.Version != 5 {
+		t.Fatalf("Unexpected version broadcast %+v", msg)
+	}
+
+	var received []string
+	w.SetUpdateCallback(func(msg string) { received = append(received, msg) })
+	broadcast := func(version int64) {
+		rw.processMessage(&UpdateMessage{Type: VersionMessageType, LocalID: "node2", Seq: uint64(version), Version: version})
+	}
+	broadcast(5)
+	broadcast(5)
+	if len(received) != 0 {
+		t.Errorf("Current version should not reload, received %v", received)
+	}
+	broadcast(6)
+	if len(received) != 1 {
+		t.Errorf("Version ahead should reload, received %v", received)
+	}
+}
@@ -0,0 +1,55 @@
+package rediswatcher
+
+import "errors"
+
+var errWatcherRunning = errors.New("rediswatcher: watcher already running")
+
+// Start connects a stopped watcher to redis again and restarts its
+// subscription, as set up by the constructor that created it. Watchers are
+// started by their constructor, Start is meant to be called after Stop or
+// Close. The options stay those passed to the constructor, credentials that
+// rotate are picked up from a CredentialsProvider, which is invoked on every
+// connection. Connections passed with WithRedisConnection are reused, so
+// they must still be usable. Start must not be called concurrently with the
+// watcher's other methods.
+func (w *Watcher) Start() error {
+	w.lifecycleMu.Lock()
+	defer w.lifecycleMu.Unlock()
+
+	select {
+	case <-w.closed:
+	default:
+		return errWatcherRunning
+	}
+	w.routines.Wait()
+	return w.start()
+}
+
+// Stop closes the watcher like Close and waits for its goroutines to exit,
+// so that it can be started again with Start. It returns the first error
+// closing the redis connections.
+func (w *Watcher) Stop() error {
+	w.lifecycleMu.Lock()
+	defer w.lifecycleMu.Unlock()
+
+	err := w.close()
+	w.routines.Wait()
+	return err
+}
+
+// Restart stops the watcher and starts it again
+func (w *Watcher) Restart() error {
+	if err := w.Stop(); err != nil {
+		w.options.Logger.Warn("Failure closing Redis connections", "channel", w.options.Channel, "localID", w.options.LocalID, "error", err)
+	}
+	return w.Start()
+}
+
+// background runs fn in a goroutine that Stop waits for
+func (w *Watcher) background(fn func()) {
+	w.routines.Add(1)
+	go func() {
+		defer w.routines.Done()
+		fn()
+	}()
+}
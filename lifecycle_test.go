@@ -0,0 +1,111 @@
+package rediswatcher
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStopStart(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	c.Command("SUBSCRIBE", "/casbin").Expect([]interface{}{[]byte("subscribe"), []byte("/casbin"), []byte("1")})
+
+	w, err := NewWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+	if err := rw.Start(); err != errWatcherRunning {
+		t.Errorf("Starting a running watcher should fail with %v, got %v", errWatcherRunning, err)
+	}
+
+	if err := rw.Stop(); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+	select {
+	case <-rw.closed:
+	default:
+		t.Fatal("Stop should close the watcher")
+	}
+
+	c.Command("SUBSCRIBE", "/casbin").Expect([]interface{}{[]byte("subscribe"), []byte("/casbin"), []byte("1")})
+	if err := rw.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	select {
+	case <-rw.Ready():
+	case <-time.After(time.Second):
+		t.Fatal("Restarted watcher should subscribe again")
+	}
+
+	if err := rw.Restart(); err != nil {
+		t.Fatalf("Restart failed: %v", err)
+	}
+	select {
+	case <-rw.closed:
+		t.Error("Restarted watcher should be running")
+	default:
+	}
+	rw.Stop()
+}
+
+func TestStopWithQueuedMessage(t *testing.T) {
+	w := &Watcher{
+		closed:     make(chan struct{}),
+		messagesIn: make(chan *UpdateMessage),
+		stats:      &watcherStats{},
+	}
+	// nothing reads the queue, as after the processor exited
+	w.background(func() { w.enqueue(&UpdateMessage{Type: UpdateMessageType}) })
+
+	close(w.closed)
+	done := make(chan struct{})
+	go func() {
+		w.routines.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Stop should not wait for a message blocked on the queue")
+	}
+}
+
+func TestStopWaitsForSupersedableCallback(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		CancelSuperseded(true))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	w.SetUpdateCallback(func(string) {
+		close(started)
+		<-release
+	})
+	rw.deliver(context.Background(), "node2")
+	<-started
+
+	stopped := make(chan struct{})
+	go func() {
+		rw.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("Stop should wait for the running callback")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Stop should return once the callback completed")
+	}
+}
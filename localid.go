@@ -0,0 +1,34 @@
+package rediswatcher
+
+import (
+	"os"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// HostLocalID returns a LocalID made of the host name, which is the pod name
+// on Kubernetes, the process ID and a random suffix, such as
+// "api-7d9f8-x2kqp:1:3f2a9c1e", so that the publisher of a message can be
+// told from its LocalID alone. Pass it to LocalIDGenerator.
+func HostLocalID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return hostname + ":" + strconv.Itoa(os.Getpid()) + ":" + uuid.New().String()[:8]
+}
+
+// initLocalID generates the LocalID with the LocalIDGenerator unless it was
+// set
+func (w *Watcher) initLocalID() {
+	if w.options.LocalID != "" {
+		return
+	}
+	if w.options.LocalIDGenerator != nil {
+		w.options.LocalID = w.options.LocalIDGenerator()
+	}
+	if w.options.LocalID == "" {
+		w.options.LocalID = uuid.New().String()
+	}
+}
@@ -0,0 +1,30 @@
+package rediswatcher
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLocalIDGenerator(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalIDGenerator(HostLocalID))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	hostname, _ := os.Hostname()
+	if id := w.(*Watcher).options.LocalID; !strings.HasPrefix(id, hostname+":") {
+		t.Errorf("LocalID should start with the host name, received '%s'", id)
+	}
+
+	w, err = NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c),
+		LocalIDGenerator(HostLocalID), LocalID("node1"))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	if id := w.(*Watcher).options.LocalID; id != "node1" {
+		t.Errorf("LocalID set after the generator should be kept, received '%s'", id)
+	}
+}
@@ -0,0 +1,86 @@
+package rediswatcher
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultPolicyLockTTL   = 30 * time.Second
+	policyLockRetryBackoff = 50 * time.Millisecond
+)
+
+// errPolicyLockLost is returned by the unlock function of LockPolicy when
+// the lock expired, and possibly another watcher acquired it, before it was
+// released
+var errPolicyLockLost = errors.New("rediswatcher: policy lock expired before it was released")
+
+// PolicySaver saves the whole policy, it is implemented by casbin.Enforcer
+type PolicySaver interface {
+	SavePolicy() error
+}
+
+// policyLockKey returns the key of the policy lock, the prefixed channel with
+// a ":lock" suffix by default
+func (w *Watcher) policyLockKey() string {
+	if w.options.PolicyLockKey != "" {
+		return w.options.PolicyLockKey
+	}
+	return w.prefixed(w.options.Channel) + ":lock"
+}
+
+// LockPolicy blocks until it acquires the distributed policy lock shared by
+// the watchers of the channel, or ctx is done. It returns a fencing token,
+// greater than the tokens of all previous holders, which a store can use to
+// reject writes of a holder whose lock expired, and the function releasing
+// the lock. The lock expires after PolicyLockTTL.
+func (w *Watcher) LockPolicy(ctx context.Context) (int64, func() error, error) {
+	key := w.policyLockKey()
+	// the token is taken together with the lock, so that tokens increase in
+	// the order the lock is acquired
+	value := []byte(uuid.New().String())
+	var token int64
+	for {
+		var err error
+		token, err = w.storage.SetNXIncr(key, value, w.options.PolicyLockTTL, key+":fence")
+		if err != nil {
+			return 0, nil, err
+		}
+		if token > 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return 0, nil, ctx.Err()
+		case <-w.closed:
+			return 0, nil, ErrWatcherClosed
+		case <-time.After(policyLockRetryBackoff):
+		}
+	}
+	unlock := func() error {
+		ok, err := w.storage.CompareAndDelete(key, value)
+		if err == nil && !ok {
+			err = errPolicyLockLost
+		}
+		return err
+	}
+	return token, unlock, nil
+}
+
+// SavePolicy saves the policy of e while holding the policy lock, so that
+// full policy saves of different watchers don't interleave. The enforcer
+// publishes the update itself when the watcher is set with SetWatcher.
+func (w *Watcher) SavePolicy(ctx context.Context, e PolicySaver) error {
+	_, unlock, err := w.LockPolicy(ctx)
+	if err != nil {
+		return err
+	}
+	err = e.SavePolicy()
+	if unlockErr := unlock(); err == nil {
+		err = unlockErr
+	}
+	return err
+}
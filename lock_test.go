@@ -0,0 +1,123 @@
+package rediswatcher
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type policySaver func() error
+
+func (f policySaver) SavePolicy() error {
+	return f()
+}
+
+func TestLockPolicy(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	storage := newMemoryStorage()
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), WithStorage(storage))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	token, unlock, err := rw.LockPolicy(context.Background())
+	if err != nil {
+		t.Fatalf("LockPolicy failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, _, err := rw.LockPolicy(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Held lock should not be acquired, got %v", err)
+	}
+	if err := unlock(); err != nil {
+		t.Fatalf("Unlock failed: %v", err)
+	}
+
+	next, unlock, err := rw.LockPolicy(context.Background())
+	if err != nil {
+		t.Fatalf("LockPolicy failed: %v", err)
+	}
+	if next <= token {
+		t.Errorf("Fencing token should increase, got %d after %d", next, token)
+	}
+	// the lock expires and another watcher acquires it
+	storage.Set("/casbin:lock", []byte("node2"), 0)
+	if err := unlock(); err != errPolicyLockLost {
+		t.Errorf("Expected %v, got %v", errPolicyLockLost, err)
+	}
+}
+
+func TestLockPolicyContention(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	storage := newMemoryStorage()
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), WithStorage(storage))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	_, unlock, err := rw.LockPolicy(context.Background())
+	if err != nil {
+		t.Fatalf("LockPolicy failed: %v", err)
+	}
+
+	// two waiters, the tokens must increase in the order they acquire the
+	// lock whichever started waiting first
+	var mu sync.Mutex
+	var tokens []int64
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, unlock, err := rw.LockPolicy(context.Background())
+			if err != nil {
+				t.Errorf("LockPolicy failed: %v", err)
+				return
+			}
+			mu.Lock()
+			tokens = append(tokens, token)
+			mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			unlock()
+		}()
+		time.Sleep(20 * time.Millisecond)
+	}
+	unlock()
+	wg.Wait()
+
+	if len(tokens) != 2 || tokens[0] >= tokens[1] {
+		t.Errorf("Fencing tokens should increase in acquisition order, got %v", tokens)
+	}
+}
+
+func TestSavePolicy(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	storage := newMemoryStorage()
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), WithStorage(storage))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	saveErr := errors.New("adapter failure")
+	err = rw.SavePolicy(context.Background(), policySaver(func() error {
+		if v, _ := storage.Get("/casbin:lock"); v == nil {
+			t.Error("Policy should be saved while holding the lock")
+		}
+		return saveErr
+	}))
+	if err != saveErr {
+		t.Errorf("Expected %v, got %v", saveErr, err)
+	}
+	if v, _ := storage.Get("/casbin:lock"); v != nil {
+		t.Error("Lock should be released after saving")
+	}
+}
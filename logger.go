@@ -0,0 +1,43 @@
+package rediswatcher
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Logger receives the watcher's internal log messages. keyvals are
+// alternating keys and values such as "channel", "/casbin", "error", err.
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+// defaultLogger prints warnings and errors to stdout
+type defaultLogger struct{}
+
+func (defaultLogger) Debug(msg string, keyvals ...interface{}) {}
+
+func (defaultLogger) Info(msg string, keyvals ...interface{}) {}
+
+func (defaultLogger) Warn(msg string, keyvals ...interface{}) {
+	fmt.Println(formatLog(msg, keyvals))
+}
+
+func (defaultLogger) Error(msg string, keyvals ...interface{}) {
+	fmt.Println(formatLog(msg, keyvals))
+}
+
+func formatLog(msg string, keyvals []interface{}) string {
+	var buf bytes.Buffer
+	buf.WriteString(msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		var value interface{}
+		if i+1 < len(keyvals) {
+			value = keyvals[i+1]
+		}
+		fmt.Fprintf(&buf, " %v=%v", keyvals[i], value)
+	}
+	return buf.String()
+}
@@ -0,0 +1,188 @@
+package rediswatcher
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Message types carried in an UpdateMessage envelope
+const (
+	UpdateMessageType          = "update"
+	SnapshotRequestMessageType = "snapshot-request"
+	SnapshotMessageType        = "snapshot"
+	AckMessageType             = "ack"
+	CommandMessageType         = "command"
+)
+
+// MessageSchemaVersion is the envelope schema version written by this
+// watcher. Envelopes of older schema versions are upgraded when decoded;
+// message types this watcher doesn't know, for instance from newer watchers
+// during a rolling upgrade, are ignored.
+const MessageSchemaVersion = 1
+
+// UpdateMessage is the envelope published by the watcher for structured
+// messages. Plain Update() calls publish the bare LocalID unless
+// EnvelopeMessages is enabled; messages that are not envelopes are decoded
+// as an update whose LocalID and Payload are the raw message data.
+type UpdateMessage struct {
+	Schema  int    `json:"schema,omitempty"`
+	Type    string `json:"type"`
+	LocalID string `json:"localID"`
+	Seq     uint64 `json:"seq,omitempty"`
+	Target  string `json:"target,omitempty"`
+	Tenant  string `json:"tenant,omitempty"`
+	Version int64  `json:"version,omitempty"`
+	Payload string `json:"payload,omitempty"`
+
+	// Ref is the key holding the payload of a message published by
+	// reference, see ReferenceThreshold
+	Ref string `json:"ref,omitempty"`
+
+	// Chunk is set on the chunks of a message split up to stay within
+	// MaxMessageSize
+	Chunk *MessageChunk `json:"chunk,omitempty"`
+
+	// Filter is the JSON encoded policy filter of an update published with
+	// UpdateForFilter
+	Filter string `json:"filter,omitempty"`
+
+	// Domains are the RBAC domains affected by an update published with
+	// UpdateForDomains
+	Domains []string `json:"domains,omitempty"`
+
+	// Route identifies the enforcer an update published through a watcher
+	// returned by Route is meant for
+	Route string `json:"route,omitempty"`
+
+	// AckRequested is set on updates published with UpdateAndWait, the
+	// receivers acknowledge them once their callback returned
+	AckRequested bool `json:"ackRequested,omitempty"`
+
+	// Command is the name of the command sent with SendCommand, Payload
+	// holds its arguments
+	Command string `json:"command,omitempty"`
+
+	// Group is the GroupID of the sender
+	Group string `json:"group,omitempty"`
+
+	// KeyID identifies the key an encrypted message was encrypted with
+	KeyID string `json:"keyID,omitempty"`
+
+	// Trace carries the publisher's trace context, such as the W3C
+	// traceparent and tracestate, when a Tracer is configured
+	Trace map[string]string `json:"trace,omitempty"`
+
+	// Channel is the channel the message was received on, it is not
+	// part of the published envelope
+	Channel string `json:"-"`
+
+	// streamIDs are the ids of the stream entries to acknowledge once the
+	// message is handled: its own, those of the chunks it was reassembled
+	// from and those of the messages dropped in its favour
+	streamIDs []string
+
+	// receivedAt is when the message was queued for the message processor
+	receivedAt time.Time
+
+	// receivers is the number of subscribers PUBLISH delivered the message
+	// to, -1 if unknown
+	receivers int64
+
+	// logSeq is the update log sequence number of a replayed message
+	logSeq int64
+}
+
+// ID identifies an envelope message across watchers as "localID:seq", it is
+// empty for messages without a sequence number
+func (msg *UpdateMessage) ID() string {
+	if msg.Seq == 0 {
+		return ""
+	}
+	return msg.LocalID + ":" + strconv.FormatUint(msg.Seq, 10)
+}
+
+// LogSeq is the sequence number of a message returned by ReplaySince in the
+// update log, it is 0 for received messages
+func (msg *UpdateMessage) LogSeq() int64 {
+	return msg.logSeq
+}
+
+// Codec encodes and decodes the message envelope, see WithCodec. Unmarshal
+// returns an error for data that isn't an envelope, such as the bare LocalID
+// published by Update, which is then treated as a plain update.
+type Codec interface {
+	Marshal(msg *UpdateMessage) ([]byte, error)
+	Unmarshal(data []byte, msg *UpdateMessage) error
+}
+
+// JSONCodec encodes envelopes as JSON, it is the default Codec
+var JSONCodec Codec = jsonCodec{}
+
+var errNotEnvelope = errors.New("rediswatcher: not an envelope")
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(msg *UpdateMessage) ([]byte, error) {
+	return json.Marshal(msg)
+}
+
+func (jsonCodec) Unmarshal(data []byte, msg *UpdateMessage) error {
+	if len(data) == 0 || data[0] != '{' {
+		return errNotEnvelope
+	}
+	return json.Unmarshal(data, msg)
+}
+
+func encodeMessage(msg *UpdateMessage) ([]byte, error) {
+	return JSONCodec.Marshal(msg)
+}
+
+func decodeMessage(channel string, data []byte) *UpdateMessage {
+	return decodeWith(JSONCodec, channel, data)
+}
+
+// upgradeMessage brings an envelope of an older schema version up to date
+func upgradeMessage(msg *UpdateMessage) {
+	if msg.Schema == 0 {
+		// envelopes written before schema versions were introduced are
+		// identical to version 1
+		msg.Schema = 1
+	}
+}
+
+// knownMessageType reports whether this watcher handles messages of type t
+func knownMessageType(t string) bool {
+	switch t {
+	case UpdateMessageType, SnapshotRequestMessageType, SnapshotMessageType, ChunkMessageType, AckMessageType, CommandMessageType, VersionMessageType:
+		return true
+	}
+	return false
+}
+
+// encode encodes msg with the configured Codec
+func (w *Watcher) encode(msg *UpdateMessage) ([]byte, error) {
+	return w.options.Codec.Marshal(msg)
+}
+
+// decode decodes data with the configured Codec
+func (w *Watcher) decode(channel string, data []byte) *UpdateMessage {
+	return decodeWith(w.options.Codec, channel, data)
+}
+
+func decodeWith(codec Codec, channel string, data []byte) *UpdateMessage {
+	msg := &UpdateMessage{}
+	if err := codec.Unmarshal(data, msg); err == nil && msg.Type != "" {
+		msg.Channel = channel
+		upgradeMessage(msg)
+		return msg
+	}
+
+	return &UpdateMessage{
+		Type:    UpdateMessageType,
+		LocalID: string(data),
+		Payload: string(data),
+		Channel: channel,
+	}
+}
@@ -0,0 +1,49 @@
+package rediswatcher
+
+import "testing"
+
+func TestDecodeMessage(t *testing.T) {
+	msg := decodeMessage("/casbin", []byte("casbin rules updated"))
+	if msg.Type != UpdateMessageType || msg.LocalID != "casbin rules updated" || msg.Payload != "casbin rules updated" {
+		t.Errorf("Plain message should decode as an update, received %+v instead", msg)
+	}
+
+	data, err := encodeMessage(&UpdateMessage{Type: SnapshotRequestMessageType, LocalID: "node1", Seq: 3})
+	if err != nil {
+		t.Fatalf("Failed to encode message: %v", err)
+	}
+	msg = decodeMessage("/casbin", data)
+	if msg.Type != SnapshotRequestMessageType || msg.LocalID != "node1" || msg.Seq != 3 || msg.Channel != "/casbin" {
+		t.Errorf("Envelope did not round trip, received %+v instead", msg)
+	}
+
+	msg = decodeMessage("/casbin", []byte(`{"policy": "p"}`))
+	if msg.Type != UpdateMessageType || msg.Payload != `{"policy": "p"}` {
+		t.Errorf("JSON without a type should decode as a plain update, received %+v instead", msg)
+	}
+}
+
+func TestMessageSchema(t *testing.T) {
+	legacy := decodeMessage("/casbin", []byte(`{"type":"update","localID":"node2","payload":"node2"}`))
+	if legacy.Schema != 1 {
+		t.Errorf("Envelope without schema should be upgraded to version 1, received %d", legacy.Schema)
+	}
+
+	c := NewTestConn()
+	c.Clear()
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	called := false
+	w.SetUpdateCallback(func(string) { called = true })
+
+	newer := decodeMessage("/casbin", []byte(`{"schema":2,"type":"presence","localID":"node2","extra":true}`))
+	if d := w.(*Watcher).Explain(*newer); d != DispositionUnsupported {
+		t.Errorf("Unknown message type should be unsupported, received '%s' instead", d)
+	}
+	w.(*Watcher).processMessage(newer)
+	if called {
+		t.Error("Unknown message type should not invoke the update callback")
+	}
+}
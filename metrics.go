@@ -0,0 +1,165 @@
+package rediswatcher
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// emitRuntimeMetrics periodically records a RuntimeStatsMetric describing the
+// watcher's goroutines and internal queue until the watcher is closed
+func (w *Watcher) emitRuntimeMetrics() {
+	ticker := time.NewTicker(w.options.RuntimeMetricsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.closed:
+			return
+		case <-ticker.C:
+			w.options.RecordMetrics(w.runtimeMetrics())
+		}
+	}
+}
+
+func (w *Watcher) runtimeMetrics() *WatcherMetrics {
+	m := w.createMetrics(RuntimeStatsMetric, time.Now(), nil)
+	m.Goroutines = runtime.NumGoroutine()
+	m.QueueDepth = len(w.messagesIn)
+	m.QueueCapacity = cap(w.messagesIn)
+	if m.QueueCapacity > 0 {
+		m.QueueUtilization = float64(m.QueueDepth) / float64(m.QueueCapacity)
+	}
+	return m
+}
+
+const (
+	defaultMetricsBatchSize     = 100
+	defaultMetricsFlushInterval = time.Second
+)
+
+// MetricsSink receives the watcher's metrics in batches, as an alternative to
+// a RecordMetrics callback per event. Record is called with up to
+// MetricsBatchSize metrics at a time and must not keep the slice or the
+// metrics once it returns, both are reused. Flush is called every
+// MetricsFlushInterval and when the watcher is closed, so that sinks
+// buffering across batches can send what they hold.
+type MetricsSink interface {
+	Record(metrics []*WatcherMetrics)
+	Flush()
+}
+
+// metricsPool holds the metrics handed back by the MetricsSink
+var metricsPool = sync.Pool{New: func() interface{} { return new(WatcherMetrics) }}
+
+// metricsBatch buffers metrics for a MetricsSink until the batch is full or
+// the flush interval elapses. pooled is set when the metrics go to the sink
+// only, they are then returned to metricsPool once recorded.
+type metricsBatch struct {
+	mu     sync.Mutex
+	sink   MetricsSink
+	size   int
+	batch  []*WatcherMetrics
+	pooled bool
+}
+
+func newMetricsBatch(sink MetricsSink, size int, pooled bool) *metricsBatch {
+	if size <= 0 {
+		size = defaultMetricsBatchSize
+	}
+	return &metricsBatch{sink: sink, size: size, batch: make([]*WatcherMetrics, 0, size), pooled: pooled}
+}
+
+func (b *metricsBatch) record(m *WatcherMetrics) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.batch = append(b.batch, m)
+	if len(b.batch) >= b.size {
+		b.recordLocked()
+	}
+}
+
+// flush hands the partial batch to the sink and flushes it
+func (b *metricsBatch) flush() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.recordLocked()
+	b.sink.Flush()
+}
+
+func (b *metricsBatch) recordLocked() {
+	if len(b.batch) == 0 {
+		return
+	}
+	b.sink.Record(b.batch)
+	for i, m := range b.batch {
+		if b.pooled {
+			metricsPool.Put(m)
+		}
+		b.batch[i] = nil
+	}
+	b.batch = b.batch[:0]
+}
+
+// newMetrics returns an empty WatcherMetrics, taken from metricsPool when
+// the metrics only go to the MetricsSink
+func (w *Watcher) newMetrics() *WatcherMetrics {
+	if w.metrics == nil || !w.metrics.pooled {
+		return new(WatcherMetrics)
+	}
+	m := metricsPool.Get().(*WatcherMetrics)
+	*m = WatcherMetrics{}
+	return m
+}
+
+// initMetricsSink routes RecordMetrics through a batch for the MetricsSink,
+// a RecordMetrics callback set as well keeps receiving every event
+func (w *Watcher) initMetricsSink() {
+	record := w.options.RecordMetrics
+	w.metrics = newMetricsBatch(w.options.MetricsSink, w.options.MetricsBatchSize, record == nil)
+	w.options.RecordMetrics = func(m *WatcherMetrics) {
+		if record != nil {
+			record(m)
+		}
+		w.metrics.record(m)
+	}
+}
+
+// flushMetrics periodically hands partial batches to the MetricsSink until
+// the watcher is closed
+func (w *Watcher) flushMetrics() {
+	ticker := time.NewTicker(w.options.MetricsFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.closed:
+			return
+		case <-ticker.C:
+			w.metrics.flush()
+		}
+	}
+}
+
+// initMetricsFilter drops metrics rejected by MetricsFilter and records only
+// every Nth metric of the names in MetricsSampling
+func (w *Watcher) initMetricsFilter() {
+	filter := w.options.MetricsFilter
+	counters := make(map[string]*uint64, len(w.options.MetricsSampling))
+	for name := range w.options.MetricsSampling {
+		counters[name] = new(uint64)
+	}
+	record := w.options.RecordMetrics
+	w.options.RecordMetrics = func(m *WatcherMetrics) {
+		if filter != nil && !filter(m.Name) {
+			return
+		}
+		if every := uint64(w.options.MetricsSampling[m.Name]); every > 1 {
+			if (atomic.AddUint64(counters[m.Name], 1)-1)%every != 0 {
+				return
+			}
+		}
+		record(m)
+	}
+}
@@ -0,0 +1,214 @@
+package rediswatcher
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rafaeljusto/redigomock"
+)
+
+func TestRuntimeMetrics(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	metrics := make(chan *WatcherMetrics, 10)
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c),
+		RuntimeMetricsInterval(time.Millisecond),
+		RecordMetrics(func(m *WatcherMetrics) {
+			select {
+			case metrics <- m:
+			default:
+			}
+		}))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	defer w.Close()
+
+	select {
+	case m := <-metrics:
+		if m.Name != RuntimeStatsMetric {
+			t.Errorf("Metric should be '%s', received '%s' instead", RuntimeStatsMetric, m.Name)
+		}
+		if m.Goroutines == 0 {
+			t.Error("Goroutine count should be recorded")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Runtime metrics were not recorded")
+	}
+}
+
+func TestDispositionMetrics(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	var names []string
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		IgnoreSelf(true), SquashMessages(true),
+		RecordMetrics(func(m *WatcherMetrics) {
+			names = append(names, m.Name)
+		}))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+	w.SetUpdateCallback(func(string) {})
+
+	rw.processMessage(decodeMessage("/casbin", []byte("node1")))
+	rw.processMessage(decodeMessage("/casbin", []byte("node2")))
+
+	if len(names) != 2 || names[0] != IgnoredSelfMetric || names[1] != SquashedMessageMetric {
+		t.Errorf("Expected %s and %s metrics, received %v", IgnoredSelfMetric, SquashedMessageMetric, names)
+	}
+}
+
+type testSink struct {
+	batches [][]WatcherMetrics
+	flushes int
+}
+
+func (s *testSink) Record(metrics []*WatcherMetrics) {
+	batch := make([]WatcherMetrics, 0, len(metrics))
+	for _, m := range metrics {
+		batch = append(batch, *m)
+	}
+	s.batches = append(s.batches, batch)
+}
+
+func (s *testSink) Flush() {
+	s.flushes++
+}
+
+func TestMetricsSink(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	sink := &testSink{}
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c),
+		WithMetricsSink(sink), MetricsBatchSize(2), MetricsFlushInterval(0))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	for i := 0; i < 3; i++ {
+		rw.options.RecordMetrics(rw.createMetrics(PubSubReceiveMetric, time.Now(), nil))
+	}
+	if len(sink.batches) != 1 || len(sink.batches[0]) != 2 || sink.flushes != 0 {
+		t.Fatalf("Expected 1 batch of 2 metrics and no flush, received %d batches and %d flushes", len(sink.batches), sink.flushes)
+	}
+
+	// closing records two RedisClose metrics and flushes the partial batch
+	w.Close()
+	if len(sink.batches) != 3 || len(sink.batches[2]) != 1 || sink.flushes != 1 {
+		t.Errorf("Close should flush the partial batch, received %d batches and %d flushes", len(sink.batches), sink.flushes)
+	}
+	if sink.batches[2][0].Name != RedisCloseMetric {
+		t.Errorf("Expected a %s metric, received %s", RedisCloseMetric, sink.batches[2][0].Name)
+	}
+}
+
+func TestMetricsSinkAllocations(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c),
+		WithMetricsSink(&discardSink{}), MetricsBatchSize(10), MetricsFlushInterval(0))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	startTime := time.Now()
+	allocs := testing.AllocsPerRun(100, func() {
+		rw.options.RecordMetrics(rw.createMetrics(PubSubReceiveMetric, startTime, nil))
+	})
+	if allocs >= 1 {
+		t.Errorf("Recording a metric should not allocate, %v allocations per event", allocs)
+	}
+}
+
+type discardSink struct{}
+
+func (discardSink) Record([]*WatcherMetrics) {}
+
+func (discardSink) Flush() {}
+
+func TestMetricsSampling(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	counts := make(map[string]int)
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c),
+		SampleMetrics(PubSubReceiveMetric, 3),
+		FilterMetrics(func(name string) bool { return name != RedisPingMetric }),
+		RecordMetrics(func(m *WatcherMetrics) {
+			counts[m.Name]++
+		}))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	for i := 0; i < 7; i++ {
+		rw.options.RecordMetrics(rw.createMetrics(PubSubReceiveMetric, time.Now(), nil))
+		rw.options.RecordMetrics(rw.createMetrics(PubSubPublishMetric, time.Now(), nil))
+		rw.options.RecordMetrics(rw.createMetrics(RedisPingMetric, time.Now(), nil))
+	}
+	if counts[PubSubReceiveMetric] != 3 || counts[PubSubPublishMetric] != 7 || counts[RedisPingMetric] != 0 {
+		t.Errorf("Unexpected metric counts %v", counts)
+	}
+}
+
+func TestMetricsContext(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	var published *WatcherMetrics
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		EnvelopeMessages(true),
+		RecordMetrics(func(m *WatcherMetrics) {
+			if m.Name == PubSubPublishMetric {
+				published = m
+			}
+		}))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+	rw.reconnectFailed(errors.New("connection refused"))
+
+	c.Command("PUBLISH", "/casbin", redigomock.NewAnyData()).Expect("1")
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+	if published == nil {
+		t.Fatal("Publish metric was not recorded")
+	}
+	if published.MessageID != "node1:1" {
+		t.Errorf("Message ID should be 'node1:1', received '%s' instead", published.MessageID)
+	}
+	if published.ReconnectAttempt != 1 || published.Timestamp.IsZero() {
+		t.Errorf("Metric should carry the reconnect attempt and timestamp, received %+v", published)
+	}
+}
+
+func TestQueueSize(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	c.ReceiveWait = true
+	c.Command("SUBSCRIBE", "/casbin").Expect([]interface{}{[]byte("subscribe"), []byte("/casbin"), []byte("1")})
+	c.Command("UNSUBSCRIBE").Expect([]interface{}{[]byte("unsubscribe"), []byte("/casbin"), []byte("0")})
+
+	w, err := NewWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), QueueSize(64))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	defer w.Close()
+
+	m := w.(*Watcher).runtimeMetrics()
+	if m.QueueCapacity != 64 {
+		t.Errorf("Queue capacity should be 64, received %d", m.QueueCapacity)
+	}
+}
@@ -1,9 +1,12 @@
 package rediswatcher
 
 import (
+	"fmt"
+	"net"
 	"time"
 
 	"github.com/garyburd/redigo/redis"
+	"github.com/google/uuid"
 )
 
 type WatcherOptions struct {
@@ -14,22 +17,231 @@ type WatcherOptions struct {
 	Password           string
 	Protocol           string
 	IgnoreSelf         bool
+	LocalIDGenerator   func() string
+	GroupID            string
+	IgnoreGroup        bool
+	AllowedSenders     []string
+	DeniedSenders      []string
+	MessageFilter      func(msg UpdateMessage) bool
+	MessageTransform   func(msg *UpdateMessage)
 	LocalID            string
 	RecordMetrics      func(*WatcherMetrics)
 	SquashMessages     bool
 	SquashTimeoutShort time.Duration
 	SquashTimeoutLong  time.Duration
-	callbackPending    bool
+
+	EnvelopeMessages     bool
+	SnapshotProvider     func() ([]byte, error)
+	SnapshotLoader       func([]byte) error
+	SnapshotGapThreshold uint64
+	SnapshotKeyPrefix    string
+	SnapshotTTL          time.Duration
+	SnapshotTimeout      time.Duration
+
+	ReconnectFailureCallback func(error)
+	MaxReconnectAttempts     int
+	ReconnectThreshold       time.Duration
+	ReloadOnRecovery         bool
+	OnConnected              func(addr string)
+	OnDisconnected           func(err error)
+
+	Addresses            []string
+	LatencyProbeInterval time.Duration
+	LatencyHysteresis    float64
+	Failover             bool
+
+	RuntimeMetricsInterval time.Duration
+
+	OrderedDelivery bool
+	ReorderTimeout  time.Duration
+
+	Storage Storage
+
+	BlockUntilSubscribed bool
+	SubscribeTimeout     time.Duration
+
+	VerifyChannel bool
+
+	Logger Logger
+
+	CancelSuperseded bool
+
+	ErrorHandler func(err error)
+
+	Tracer Tracer
+
+	ExpvarName string
+
+	MetricsSink          MetricsSink
+	MetricsBatchSize     int
+	MetricsFlushInterval time.Duration
+
+	MetricsFilter   func(name string) bool
+	MetricsSampling map[string]int
+
+	Transport      string
+	StreamKey      string
+	StreamGroup    string
+	StreamConsumer string
+
+	StreamMaxLen    int64
+	StreamMaxAge    time.Duration
+	StreamTrimExact bool
+
+	StreamClaimInterval time.Duration
+	StreamClaimIdle     time.Duration
+
+	VersionKey string
+
+	UpdateLogKey    string
+	UpdateLogMaxLen int64
+
+	ReferenceThreshold int
+	ReferenceKeyPrefix string
+	ReferenceTTL       time.Duration
+
+	KeyProvider KeyProvider
+
+	MaxMessageSize int
+	ChunkMessages  bool
+
+	Codec Codec
+
+	ShardedPubSub bool
+	RESP3         bool
+
+	KeyspaceDB int
+
+	PollInterval        time.Duration
+	VersionPollInterval time.Duration
+
+	Channels      []string
+	ChannelPrefix string
+
+	Tenants        []string
+	TenantChannels bool
+
+	CallbackRetries        int
+	CallbackRetryBackoff   time.Duration
+	CallbackFailureHandler func(data string, err error)
+
+	CallbackWorkers int
+	OrderedDispatch bool
+
+	QueueSize        int
+	QueueOverflow    string
+	OnDroppedMessage func(msg *UpdateMessage)
+
+	SquashMaxDelay time.Duration
+	SquashMaxCount int
+
+	SquashPerSender bool
+
+	DedupeWindow time.Duration
+
+	MinSubscribers int
+
+	HeartbeatInterval time.Duration
+	PresenceKey       string
+
+	ControlChannel string
+
+	LeaderKey                string
+	LeaderTTL                time.Duration
+	OnLeaderChange           func(leader bool)
+	VersionBroadcastInterval time.Duration
+
+	PolicyLockKey string
+	PolicyLockTTL time.Duration
+
+	PublishRetries      int
+	PublishRetryBackoff time.Duration
+
+	CircuitThreshold int
+	CircuitCooldown  time.Duration
+
+	OutboxSize int
+
+	FanoutAddresses []string
+
+	ResolveDNS bool
+	SRVName    string
+
+	CredentialsProvider CredentialsProvider
+
+	Dialer func(network, addr string) (net.Conn, error)
+
+	KeepAliveInterval time.Duration
+	ReceiveTimeout    time.Duration
+
+	TCPKeepAlive   time.Duration
+	ConnectTimeout time.Duration
+	WriteTimeout   time.Duration
 }
 
 type WatcherOption func(*WatcherOptions)
 
+func defaultWatcherOptions() WatcherOptions {
+	return WatcherOptions{
+		Channel:              "/casbin",
+		Protocol:             "tcp",
+		LocalID:              uuid.New().String(),
+		SquashTimeoutShort:   defaultShortMessageInTimeout,
+		SquashTimeoutLong:    defaultLongMessageInTimeout,
+		SnapshotKeyPrefix:    defaultSnapshotKeyPrefix,
+		SnapshotTTL:          defaultSnapshotTTL,
+		SnapshotTimeout:      defaultSnapshotTimeout,
+		LatencyProbeInterval: defaultLatencyProbeInterval,
+		LatencyHysteresis:    defaultLatencyHysteresis,
+		ReorderTimeout:       defaultReorderTimeout,
+		SubscribeTimeout:     defaultSubscribeTimeout,
+		Logger:               defaultLogger{},
+		Transport:            PubSubTransport,
+		QueueOverflow:        OverflowBlock,
+		PollInterval:         defaultPollInterval,
+		CallbackRetryBackoff: defaultCallbackRetryBackoff,
+		Codec:                JSONCodec,
+		StreamClaimIdle:      defaultStreamClaimIdle,
+		UpdateLogMaxLen:      defaultUpdateLogMaxLen,
+		ReferenceKeyPrefix:   defaultReferenceKeyPrefix,
+		ReferenceTTL:         defaultReferenceTTL,
+		MetricsBatchSize:     defaultMetricsBatchSize,
+		MetricsFlushInterval: defaultMetricsFlushInterval,
+		PolicyLockTTL:        defaultPolicyLockTTL,
+		PublishRetryBackoff:  defaultPublishRetryBackoff,
+		TCPKeepAlive:         defaultTCPKeepAlive,
+	}
+}
+
 func Channel(subject string) WatcherOption {
 	return func(options *WatcherOptions) {
 		options.Channel = subject
 	}
 }
 
+// Channels subscribes the watcher to several channels over a single
+// connection, for instance one per policy domain or application. Update
+// publishes to the first channel, which replaces Channel. Channels are only
+// supported by the pub/sub transport.
+func Channels(channels []string) WatcherOption {
+	return func(options *WatcherOptions) {
+		if len(channels) > 0 {
+			options.Channel = channels[0]
+		}
+		options.Channels = channels
+	}
+}
+
+// ChannelPrefix namespaces the channels published and subscribed to, and the
+// default stream key, with prefix, such as "prod:" or "staging:", so that
+// environments sharing a redis instance can't trigger each other's reloads.
+// Channel names elsewhere, for instance in RegisterCallback, are unprefixed.
+func ChannelPrefix(prefix string) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.ChannelPrefix = prefix
+	}
+}
+
 func Username(username string) WatcherOption {
 	return func(options *WatcherOptions) {
 		options.Username = username
@@ -66,12 +278,81 @@ func LocalID(id string) WatcherOption {
 	}
 }
 
+// LocalIDGenerator generates the LocalID of the watcher with generate
+// instead of a random UUID, for instance HostLocalID. A LocalID set after
+// this option takes precedence.
+func LocalIDGenerator(generate func() string) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.LocalIDGenerator = generate
+		options.LocalID = ""
+	}
+}
+
 func IgnoreSelf(ignore bool) WatcherOption {
 	return func(options *WatcherOptions) {
 		options.IgnoreSelf = ignore
 	}
 }
 
+// GroupID sets the group of the watcher, shared by all instances of the same
+// logical application. Envelope messages carry the group of their sender,
+// see UpdateMessage.Group; bare LocalID messages published by Update without
+// EnvelopeMessages don't.
+func GroupID(id string) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.GroupID = id
+	}
+}
+
+// IgnoreGroup ignores the updates published by any watcher of the same
+// GroupID, like IgnoreSelf does for this watcher's own updates
+func IgnoreGroup(ignore bool) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.IgnoreGroup = ignore
+	}
+}
+
+// AllowSenders only processes the messages published by the watchers with
+// the given LocalIDs, for instance while unrelated systems share a channel
+// during a migration. The watcher's own messages are always processed.
+func AllowSenders(ids ...string) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.AllowedSenders = ids
+	}
+}
+
+// DenySenders ignores the messages published by the watchers with the given
+// LocalIDs
+func DenySenders(ids ...string) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.DeniedSenders = ids
+	}
+}
+
+// WithMessageFilter drops the updates for which filter returns false before
+// they are squashed or reach the update callback, so that irrelevant updates,
+// such as those of another tenant or policy section, don't trigger a reload.
+// filter should be cheap and free of side effects, it is also evaluated by
+// Explain. Updates are dropped if it panics. Messages handled by the watcher
+// itself aren't filtered.
+func WithMessageFilter(filter func(msg UpdateMessage) bool) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.MessageFilter = filter
+	}
+}
+
+// WithMessageTransform passes every received update to transform before it
+// is filtered, squashed or delivered, so that it can rewrite or annotate the
+// message, for instance translating the payload of a legacy wire format
+// during a migration or adding local metadata. transform should be free of
+// side effects, Explain invokes it too. Updates are dropped if it panics.
+// Messages handled by the watcher itself aren't transformed.
+func WithMessageTransform(transform func(msg *UpdateMessage)) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.MessageTransform = transform
+	}
+}
+
 func SquashMessages(squash bool) WatcherOption {
 	return func(options *WatcherOptions) {
 		options.SquashMessages = squash
@@ -84,6 +365,14 @@ func RecordMetrics(callback func(*WatcherMetrics)) WatcherOption {
 	}
 }
 
+// RuntimeMetricsInterval records a RuntimeStatsMetric with the goroutine
+// count and internal queue depth every d, 0 disables it
+func RuntimeMetricsInterval(d time.Duration) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.RuntimeMetricsInterval = d
+	}
+}
+
 func SquashTimeoutShort(d time.Duration) WatcherOption {
 	return func(options *WatcherOptions) {
 		options.SquashTimeoutShort = d
@@ -96,11 +385,1001 @@ func SquashTimeoutLong(d time.Duration) WatcherOption {
 	}
 }
 
+// EnvelopeMessages makes Update publish an UpdateMessage envelope carrying a
+// sequence number instead of the bare LocalID. Updates are numbered per
+// channel, other envelopes such as acknowledgements and commands carry none.
+func EnvelopeMessages(enabled bool) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.EnvelopeMessages = enabled
+	}
+}
+
+// SnapshotProvider designates the watcher as a snapshot provider. The
+// provider function returns the serialized policy, which is compressed and
+// written to redis whenever another watcher calls RequestSnapshot.
+func SnapshotProvider(provider func() ([]byte, error)) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.SnapshotProvider = provider
+	}
+}
+
+// SnapshotLoader sets the function that receives a requested policy snapshot
+func SnapshotLoader(loader func([]byte) error) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.SnapshotLoader = loader
+	}
+}
+
+// SnapshotGapThreshold requests a snapshot automatically when at least n
+// messages from a single sender were missed. Requires the senders to use
+// EnvelopeMessages.
+func SnapshotGapThreshold(n uint64) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.SnapshotGapThreshold = n
+	}
+}
+
+func SnapshotKeyPrefix(prefix string) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.SnapshotKeyPrefix = prefix
+	}
+}
+
+func SnapshotTTL(d time.Duration) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.SnapshotTTL = d
+	}
+}
+
+// SnapshotTimeout sets how long a requested snapshot is waited for before
+// the update callback is invoked to reload the whole policy instead. It
+// defaults to 30 seconds, zero waits forever.
+func SnapshotTimeout(d time.Duration) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.SnapshotTimeout = d
+	}
+}
+
+// ReconnectFailureCallback is invoked with the error of every failed attempt
+// to (re)establish the subscription. Once MaxReconnectAttempts is exceeded it
+// is invoked a final time with a *ReconnectError.
+func ReconnectFailureCallback(callback func(error)) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.ReconnectFailureCallback = callback
+	}
+}
+
+// MaxReconnectAttempts stops the watcher from reconnecting after n
+// consecutive failures, 0 retries forever
+func MaxReconnectAttempts(n int) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.MaxReconnectAttempts = n
+	}
+}
+
+// ReconnectThreshold escalates an outage that lasts longer than d: a
+// ReconnectThresholdMetric is recorded and the ReconnectFailureCallback is
+// invoked with a *ReconnectThresholdError
+func ReconnectThreshold(d time.Duration) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.ReconnectThreshold = d
+	}
+}
+
+// ReloadOnRecovery invokes the update callback once the subscription is
+// re-established after an outage that exceeded the ReconnectThreshold
+func ReloadOnRecovery(reload bool) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.ReloadOnRecovery = reload
+	}
+}
+
+// OnConnected is invoked with the redis address whenever the watcher's
+// subscription is established or re-established. Watchers created with
+// NewPublishWatcher invoke it once the publish connection is up.
+func OnConnected(callback func(addr string)) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.OnConnected = callback
+	}
+}
+
+// OnDisconnected is invoked with the cause when the watcher loses an
+// established subscription
+func OnDisconnected(callback func(err error)) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.OnDisconnected = callback
+	}
+}
+
+// Addresses configures additional redis endpoints, for example replicas in
+// other regions. The fastest healthy endpoint is used for new connections.
+func Addresses(addrs ...string) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.Addresses = addrs
+	}
+}
+
+// FailoverAddresses configures standby redis endpoints for simple
+// active/passive setups without Sentinel. The watcher connects to the first
+// address and moves on to the next one, in order and wrapping around, when a
+// connection to the current one fails. Endpoints aren't probed for latency.
+func FailoverAddresses(addrs ...string) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.Addresses = addrs
+		options.Failover = true
+	}
+}
+
+// LatencyProbeInterval sets how often each endpoint is PINGed to measure its
+// latency, 0 disables probing
+func LatencyProbeInterval(d time.Duration) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.LatencyProbeInterval = d
+	}
+}
+
+// LatencyHysteresis sets the fraction by which another endpoint has to be
+// faster than the current one before the watcher switches to it
+func LatencyHysteresis(fraction float64) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.LatencyHysteresis = fraction
+	}
+}
+
+// OrderedDelivery guarantees that callbacks run strictly in publish order per
+// sender and channel. Envelope updates that arrive out of order are held back
+// for up to ReorderTimeout waiting for the missing sequence numbers, and
+// duplicates are dropped. Senders must use EnvelopeMessages. It can't be
+// combined with more than one of the CallbackWorkers.
+func OrderedDelivery(ordered bool) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.OrderedDelivery = ordered
+	}
+}
+
+// ReorderTimeout sets how long OrderedDelivery waits for a missing message
+// before skipping it
+func ReorderTimeout(d time.Duration) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.ReorderTimeout = d
+	}
+}
+
+// BlockUntilSubscribed makes NewWatcher wait until the initial SUBSCRIBE is
+// confirmed. NewWatcher fails if the first attempt fails or SubscribeTimeout
+// elapses.
+func BlockUntilSubscribed(block bool) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.BlockUntilSubscribed = block
+	}
+}
+
+// SubscribeTimeout bounds how long BlockUntilSubscribed waits, 0 waits
+// indefinitely
+func SubscribeTimeout(d time.Duration) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.SubscribeTimeout = d
+	}
+}
+
+// VerifyChannel rejects messages that arrive on a channel the watcher is not
+// subscribed to, recording an UnexpectedChannelMetric for each of them
+func VerifyChannel(verify bool) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.VerifyChannel = verify
+	}
+}
+
+// WithLogger routes the watcher's internal log messages to logger. By default
+// warnings and errors are printed to stdout.
+func WithLogger(logger Logger) WatcherOption {
+	return func(options *WatcherOptions) {
+		if logger != nil {
+			options.Logger = logger
+		}
+	}
+}
+
+// CancelSuperseded runs update callbacks asynchronously and cancels the
+// context of a callback still in flight when a newer update arrives, see
+// SetUpdateCallbackWithContext. It has no effect with OrderedDelivery. Stop
+// waits for the callback in flight to return.
+func CancelSuperseded(cancel bool) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.CancelSuperseded = cancel
+	}
+}
+
+// WithErrorHandler sets a function called with every failure the watcher
+// runs into in the background, such as subscribe, receive and publish errors.
+// Failures are still logged as well.
+func WithErrorHandler(handler func(err error)) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.ErrorHandler = handler
+	}
+}
+
+// WithTracer instruments publishing and receiving updates with tracer
+func WithTracer(tracer Tracer) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.Tracer = tracer
+	}
+}
+
+// ExpvarName publishes the watcher's counters through expvar under name, so
+// they appear on /debug/vars
+func ExpvarName(name string) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.ExpvarName = name
+	}
+}
+
+// WithMetricsSink sends the watcher's metrics to sink in batches, see
+// MetricsSink. Unless a RecordMetrics callback is set as well, the metrics
+// are reused once recorded, so that none are allocated per event.
+func WithMetricsSink(sink MetricsSink) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.MetricsSink = sink
+	}
+}
+
+// MetricsBatchSize sets the number of metrics handed to the MetricsSink at
+// once, 100 by default
+func MetricsBatchSize(n int) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.MetricsBatchSize = n
+	}
+}
+
+// MetricsFlushInterval sets how often a partial batch is handed to the
+// MetricsSink, every second by default
+func MetricsFlushInterval(d time.Duration) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.MetricsFlushInterval = d
+	}
+}
+
+// FilterMetrics records only the metrics whose name filter returns true for
+func FilterMetrics(filter func(name string) bool) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.MetricsFilter = filter
+	}
+}
+
+// SampleMetrics records only every nth metric called name, such as
+// PubSubReceiveMetric on busy channels. It can be given once per name.
+func SampleMetrics(name string, n int) WatcherOption {
+	return func(options *WatcherOptions) {
+		if options.MetricsSampling == nil {
+			options.MetricsSampling = make(map[string]int)
+		}
+		options.MetricsSampling[name] = n
+	}
+}
+
+// WithTransport selects how updates are distributed: PubSubTransport, the
+// default, StreamTransport, which appends updates to a redis stream so that
+// a watcher reads the updates published while it was disconnected once it
+// reconnects, KeyspaceTransport or PollTransport.
+//
+// KeyspaceTransport watches VersionKey through keyspace notifications instead
+// of a channel, so any client that modifies the key, not only Update, makes
+// the watchers invoke their update callback with the name of the modifying
+// command. The server must have keyspace notifications enabled for the key,
+// e.g. notify-keyspace-events "K$g". Envelope messages, snapshots and the
+// other features relying on published messages aren't available.
+//
+// PollTransport is a fallback for environments where pub/sub is unavailable,
+// such as behind restrictive proxies. The watcher reads VersionKey every
+// PollInterval and invokes the update callback with the new value when it
+// changed, so updates arrive with a delay instead of not at all. Like
+// KeyspaceTransport it is driven by VersionKey alone.
+//
+// DualTransport publishes updates like PubSubTransport and also appends them
+// to the update stream. Watchers receive updates through pub/sub and, once
+// resubscribed after a disconnection, read the updates they missed from the
+// stream. Envelope updates already received through pub/sub are skipped;
+// bare LocalID messages and other envelopes have no sequence number and are
+// delivered again.
+// StreamMaxLen or StreamMaxAge keep the stream bounded.
+func WithTransport(transport string) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.Transport = transport
+	}
+}
+
+// StreamKey sets the key of the update stream used by StreamTransport, the
+// channel name by default
+func StreamKey(key string) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.StreamKey = key
+	}
+}
+
+// StreamGroup reads the update stream through a consumer group, entries are
+// acknowledged once processed and, with a stable StreamConsumer, entries left
+// unacknowledged are read again after a restart. Entries are delivered to one
+// consumer per group, so every watcher needs a group of its own that stays the
+// same across restarts, such as the host name.
+func StreamGroup(group string) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.StreamGroup = group
+	}
+}
+
+// StreamConsumer sets the consumer name used within the StreamGroup, the
+// LocalID by default
+func StreamConsumer(consumer string) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.StreamConsumer = consumer
+	}
+}
+
+// StreamMaxLen trims the update stream to about n entries on every update
+func StreamMaxLen(n int64) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.StreamMaxLen = n
+	}
+}
+
+// StreamMaxAge trims entries older than d from the update stream on every
+// update
+func StreamMaxAge(d time.Duration) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.StreamMaxAge = d
+	}
+}
+
+// StreamTrimExact trims the update stream exactly to StreamMaxLen or
+// StreamMaxAge. By default trimming is approximate, which is much cheaper
+// for redis.
+func StreamTrimExact(exact bool) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.StreamTrimExact = exact
+	}
+}
+
+// StreamClaimInterval sets how often a watcher using a StreamGroup takes
+// over entries other consumers of its group left unacknowledged, such as
+// those of a previous instance that crashed. It is disabled by default.
+func StreamClaimInterval(d time.Duration) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.StreamClaimInterval = d
+	}
+}
+
+// StreamClaimIdle sets how long an entry must have been pending before it is
+// claimed, one minute by default
+func StreamClaimIdle(d time.Duration) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.StreamClaimIdle = d
+	}
+}
+
+// VersionKey keeps a policy version counter at key that is incremented on
+// every Update. After reconnecting, a watcher whose last seen version is
+// behind the counter invokes the update callback, since it missed updates
+// while disconnected. Updates only carry their version with
+// EnvelopeMessages, without it every reconnect after an update reloads.
+func VersionKey(key string) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.VersionKey = key
+	}
+}
+
+// UpdateLogKey appends every update to a capped log at key before it is
+// published, see ReplaySince
+func UpdateLogKey(key string) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.UpdateLogKey = key
+	}
+}
+
+// UpdateLogMaxLen sets the number of updates kept in the update log, 1000 by
+// default
+func UpdateLogMaxLen(n int64) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.UpdateLogMaxLen = n
+	}
+}
+
+// ReferenceThreshold publishes envelope payloads larger than n bytes by
+// reference: the payload is stored under a key of its own and subscribers
+// fetch it on receipt. It is disabled by default.
+func ReferenceThreshold(n int) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.ReferenceThreshold = n
+	}
+}
+
+// ReferenceKeyPrefix sets the prefix of the keys payloads published by
+// reference are stored under, "casbin:payload:" by default
+func ReferenceKeyPrefix(prefix string) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.ReferenceKeyPrefix = prefix
+	}
+}
+
+// ReferenceTTL sets how long payloads published by reference are kept, five
+// minutes by default
+func ReferenceTTL(d time.Duration) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.ReferenceTTL = d
+	}
+}
+
+// EncryptionKey encrypts the messages and the data stored alongside them with
+// AES-GCM using key, which must be 16, 24 or 32 bytes long. Unencrypted
+// messages are rejected.
+func EncryptionKey(key []byte) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.KeyProvider = staticKey(key)
+	}
+}
+
+// WithKeyProvider encrypts like EncryptionKey with the keys supplied by
+// provider, allowing keys to be rotated
+func WithKeyProvider(provider KeyProvider) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.KeyProvider = provider
+	}
+}
+
+// MaxMessageSize rejects messages larger than n bytes with
+// ErrMessageTooLarge, unless ChunkMessages is enabled
+func MaxMessageSize(n int) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.MaxMessageSize = n
+	}
+}
+
+// ChunkMessages splits envelope messages larger than MaxMessageSize into
+// chunks that receiving watchers reassemble. Messages needing more than 1024
+// chunks are rejected with ErrMessageTooLarge, and receivers reassemble at
+// most 64 messages at a time.
+func ChunkMessages(chunk bool) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.ChunkMessages = chunk
+	}
+}
+
+// WithCodec encodes envelope messages with codec instead of JSON. All
+// watchers on a channel must use the same codec; the codecs module provides
+// msgpack and protobuf implementations.
+func WithCodec(codec Codec) WatcherOption {
+	return func(options *WatcherOptions) {
+		if codec != nil {
+			options.Codec = codec
+		}
+	}
+}
+
+// ShardedPubSub publishes with SPUBLISH and subscribes with SSUBSCRIBE, so
+// that in a redis 7 cluster updates only travel through the shard owning the
+// channel instead of being broadcast to every node. The watcher must connect
+// to a node of that shard. Servers without sharded pub/sub fall back to
+// classic pub/sub. It can't be combined with more than one channel.
+func ShardedPubSub(enabled bool) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.ShardedPubSub = enabled
+	}
+}
+
+// RESP3 negotiates RESP3 with HELLO 3 on the subscribe connection, on which
+// the updates then arrive as push messages, so that the connection can also
+// serve commands. It requires redis 6 and can't be combined with
+// StreamTransport, whose replies differ in RESP3.
+func RESP3(enabled bool) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.RESP3 = enabled
+	}
+}
+
+// KeyspaceDB sets the database whose keyspace notifications are watched with
+// KeyspaceTransport, 0 by default
+func KeyspaceDB(db int) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.KeyspaceDB = db
+	}
+}
+
+// PollInterval sets how often PollTransport reads VersionKey, 5 seconds by
+// default
+func PollInterval(d time.Duration) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.PollInterval = d
+	}
+}
+
+// VersionPollInterval additionally polls VersionKey at interval as a safety
+// net for the push transports. If the version is still past the last update
+// delivered on the next poll, the push was missed: the watcher invokes the
+// update callback and records a MissedPushMetric. Updates only carry their version with
+// EnvelopeMessages, which the senders must therefore enable.
+func VersionPollInterval(interval time.Duration) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.VersionPollInterval = interval
+	}
+}
+
+// Tenants sets the tenants, or domains, hosted by the watcher. Updates
+// published with UpdateTenant for other tenants are ignored, updates without
+// a tenant are delivered to every watcher. All tenants are hosted by default.
+func Tenants(tenants []string) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.Tenants = tenants
+	}
+}
+
+// TenantChannels publishes the updates of each tenant to its own channel,
+// Channel followed by ":" and the tenant, instead of tagging them on Channel,
+// so that watchers only receive the updates of the Tenants they host.
+func TenantChannels(enabled bool) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.TenantChannels = enabled
+	}
+}
+
+// CallbackRetries sets how often a failed callback set with
+// SetUpdateCallbackWithError is retried, backoff is the delay before the
+// first retry which doubles with every further one
+func CallbackRetries(retries int, backoff time.Duration) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.CallbackRetries = retries
+		options.CallbackRetryBackoff = backoff
+	}
+}
+
+// CallbackFailureHandler sets a function called when a callback set with
+// SetUpdateCallbackWithError fails for good, for instance to mark the
+// enforcer as stale or schedule a later reload
+func CallbackFailureHandler(handler func(data string, err error)) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.CallbackFailureHandler = handler
+	}
+}
+
+// CallbackWorkers runs the update callbacks on a pool of n workers instead of
+// one at a time on the message processor, for watchers fanning out to many
+// independent enforcers, e.g. with Route or RegisterCallback. Callbacks may
+// then run concurrently and complete out of order, so more than one worker
+// can't be combined with OrderedDelivery. CancelSuperseded keeps running the
+// update callback on its own goroutine.
+func CallbackWorkers(n int) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.CallbackWorkers = n
+	}
+}
+
+// OrderedDispatch makes the CallbackWorkers invoke the callbacks for the
+// updates of each sender in the order they were received, by always running
+// them on the same worker, so that incremental updates are never applied out
+// of order. Updates held back by squashing mix senders and run on the first
+// worker.
+func OrderedDispatch(enabled bool) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.OrderedDispatch = enabled
+	}
+}
+
+// QueueSize buffers up to n received messages between the subscription and
+// the message processor. The queue is unbuffered by default, so a slow
+// callback stops the watcher from reading the sub connection, which redis
+// drops once its output buffer limit is reached. The queue depth is reported
+// with RuntimeStatsMetric.
+func QueueSize(n int) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.QueueSize = n
+	}
+}
+
+// QueueOverflow sets what happens to a received plain update when the queue
+// sized by QueueSize is full: OverflowBlock, the default, waits for the
+// message processor, OverflowDropOldest drops the oldest queued plain update
+// and OverflowCoalesce drops all queued plain updates in favour of the latest,
+// since a single reload applies all of them. A plain update that is ignored,
+// for instance by IgnoreSelf or while paused, is dropped itself instead.
+// Scoped updates, updates carrying a payload, chunks, acknowledgements and
+// other control messages are never dropped and wait for the processor. The
+// drop policies require a QueueSize. Dropped messages are reported with a
+// DroppedMessageMetric and to the OnDroppedMessage callback.
+func QueueOverflow(policy string) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.QueueOverflow = policy
+	}
+}
+
+// OnDroppedMessage sets a function called with every message dropped by the
+// QueueOverflow policy
+func OnDroppedMessage(callback func(msg *UpdateMessage)) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.OnDroppedMessage = callback
+	}
+}
+
+// SquashMaxDelay invokes the callback of squashed messages at most d after
+// the first of them was received, even if more keep arriving within
+// SquashTimeoutShort
+func SquashMaxDelay(d time.Duration) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.SquashMaxDelay = d
+	}
+}
+
+// SquashMaxCount invokes the callback as soon as n messages were squashed
+func SquashMaxCount(n int) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.SquashMaxCount = n
+	}
+}
+
+// SquashPerSender squashes the messages of each sender on its own, so that
+// a flood of messages from one node doesn't hide the updates of the others.
+// When the squash timeout expires the callback is invoked once per sender
+// with that sender's last message.
+func SquashPerSender(enabled bool) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.SquashPerSender = enabled
+	}
+}
+
+// DedupeWindow drops the messages identical to one processed within the
+// window, to absorb messages delivered twice. Envelope messages are told
+// apart by sender and sequence number, so an update published twice with
+// EnvelopeMessages is delivered twice. Bare messages are told apart by their
+// payload and scope. It applies before squashing, and is disabled when
+// window is zero.
+func DedupeWindow(window time.Duration) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.DedupeWindow = window
+	}
+}
+
+// MinSubscribers counts the subscribers of the channel with PUBSUB NUMSUB
+// before every update is published, and reports a *SubscribersError to the
+// ErrorHandler if there are fewer than n, for instance because watchers are
+// configured with different channels. The update is published regardless.
+func MinSubscribers(n int) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.MinSubscribers = n
+	}
+}
+
+// Heartbeat makes the watcher write its Presence, such as its hostname and
+// the last policy version it applied, to the PresenceKey hash every interval
+// so that the watchers sharing a channel are observable from redis. The
+// presence is removed when the watcher is closed.
+func Heartbeat(interval time.Duration) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.HeartbeatInterval = interval
+	}
+}
+
+// PresenceKey sets the key of the hash the Heartbeat is written to, the
+// channel with a ":presence" suffix by default
+func PresenceKey(key string) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.PresenceKey = key
+	}
+}
+
+// ControlChannel subscribes the watcher to channel for commands sent with
+// SendCommand, such as ReloadAllCommand, so that operations can be run on
+// the whole fleet without a redeploy. Handlers for other commands are added
+// with RegisterCommand.
+func ControlChannel(channel string) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.ControlChannel = channel
+	}
+}
+
+// LeaderElection makes the watchers sharing key elect a leader through a lock
+// held for ttl, 15s if 0, and renewed by the leader every third of it. Only
+// the leader publishes the VersionBroadcast, avoiding redundant traffic in
+// large fleets. A leader that fails to renew the lock steps down before it
+// may expire.
+func LeaderElection(key string, ttl time.Duration) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.LeaderKey = key
+		if ttl <= 0 {
+			ttl = defaultLeaderTTL
+		}
+		options.LeaderTTL = ttl
+	}
+}
+
+// OnLeaderChange sets a function called when the watcher gains or loses the
+// LeaderElection leadership
+func OnLeaderChange(callback func(leader bool)) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.OnLeaderChange = callback
+	}
+}
+
+// VersionBroadcast publishes the value of VersionKey every interval, so that
+// watchers that missed updates notice and reload. With LeaderElection only
+// the leader publishes it.
+func VersionBroadcast(interval time.Duration) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.VersionBroadcastInterval = interval
+	}
+}
+
+// PolicyLock sets the key and the expiry of the lock acquired by LockPolicy
+// and SavePolicy, the channel with a ":lock" suffix and 30s by default
+func PolicyLock(key string, ttl time.Duration) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.PolicyLockKey = key
+		if ttl > 0 {
+			options.PolicyLockTTL = ttl
+		}
+	}
+}
+
+// PublishRetries sets how often a failed publish is retried before the error
+// is returned to the caller of Update, backoff is the delay before the first
+// retry which doubles with every further one. The publish connection is
+// reconnected before every retry, unless it was passed with
+// WithRedisPubConnection.
+func PublishRetries(retries int, backoff time.Duration) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.PublishRetries = retries
+		options.PublishRetryBackoff = backoff
+	}
+}
+
+// CircuitBreaker makes Update fail fast with ErrCircuitOpen once threshold
+// consecutive publishes failed, instead of every caller waiting for redis to
+// time out. After cooldown a single publish is let through to probe redis,
+// closing the circuit again if it succeeds.
+func CircuitBreaker(threshold int, cooldown time.Duration) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.CircuitThreshold = threshold
+		options.CircuitCooldown = cooldown
+	}
+}
+
+// Outbox keeps up to size messages that failed to publish, including those
+// rejected by an open CircuitBreaker, and publishes them once redis is
+// reachable again, so that updates made during a brief outage aren't lost.
+// Update returns nil for messages kept in the outbox. When the outbox is full
+// the oldest message is dropped.
+func Outbox(size int) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.OutboxSize = size
+	}
+}
+
+// FanoutAddresses publishes every update to each of addrs as well, such as
+// one redis per region in deployments that don't replicate pub/sub across
+// regions. Watchers in a region subscribe to their own redis. An update
+// succeeds once published on the main connection, failures on a fanout
+// address are reported to the ErrorHandler as a FanoutError and aren't
+// retried. Not used with StreamTransport.
+func FanoutAddresses(addrs ...string) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.FanoutAddresses = addrs
+	}
+}
+
+// ResolveDNS looks up the host name of the redis address afresh on every
+// connection attempt, bypassing any caching resolver of the system, and
+// dials its addresses in turn, so that the watcher follows the endpoint
+// changes of a Kubernetes service or ElastiCache instead of reconnecting to
+// a dead address. Only used with the tcp protocol.
+func ResolveDNS(enabled bool) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.ResolveDNS = enabled
+	}
+}
+
+// SRVName discovers the redis endpoint from the DNS SRV record name, such as
+// "_redis._tcp.redis.service.consul", instead of the address passed to
+// NewWatcher, which is only used when the lookup fails. The record is looked
+// up again on every connection attempt, so that the watcher follows topology
+// changes, and the next target is used after a connection to one fails.
+func SRVName(name string) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.SRVName = name
+	}
+}
+
+// WithCredentialsProvider authenticates every new connection with the
+// credentials returned by provider instead of Username and Password
+func WithCredentialsProvider(provider CredentialsProvider) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.CredentialsProvider = provider
+	}
+}
+
+// EntraAuth authenticates with Azure Cache for Redis using Microsoft Entra
+// ID, as the identity objectID with the access tokens returned by token. The
+// connections are authenticated again before a token expires. See
+// NewEntraCredentials.
+func EntraAuth(objectID string, token EntraTokenFunc) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.CredentialsProvider = NewEntraCredentials(objectID, token)
+	}
+}
+
+// WithDialer establishes the network connections of the watcher with dial
+// instead of net.Dial, to reach redis through a bastion or proxy. The Dial
+// method of a SOCKS5 dialer from golang.org/x/net/proxy can be passed as is:
+//
+//	dialer, err := proxy.SOCKS5("tcp", "bastion:1080", nil, proxy.Direct)
+//	w, err := rediswatcher.NewWatcher("redis:6379", rediswatcher.WithDialer(dialer.Dial))
+//
+// dial receives the Protocol and the address, resolved first with ResolveDNS.
+func WithDialer(dial func(network, addr string) (net.Conn, error)) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.Dialer = dial
+	}
+}
+
+// KeepAlive PINGs the subscribed connection every interval and reconnects
+// when nothing was received for two intervals, so that a half-open
+// connection, such as one silently dropped by a NAT or load balancer, is
+// detected quickly instead of when the next update is missed. 0, the
+// default, disables it.
+func KeepAlive(interval time.Duration) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.KeepAliveInterval = interval
+	}
+}
+
+// ReceiveTimeout makes the subscription fail and reconnect when nothing was
+// received for d, so that a wedged connection doesn't block the watcher
+// forever. Unless KeepAlive is set, the connection is PINGed every d/2 so
+// that an idle connection doesn't time out. 0, the default, waits forever.
+func ReceiveTimeout(d time.Duration) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.ReceiveTimeout = d
+	}
+}
+
+// TCPKeepAlive sets the TCP keep-alive period of the connections, so that
+// idle subscribed connections aren't silently dropped by firewalls, 5 minutes
+// by default. 0 disables keep-alives. Not used with WithDialer.
+func TCPKeepAlive(period time.Duration) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.TCPKeepAlive = period
+	}
+}
+
+// ConnectTimeout limits how long establishing a connection may take, no
+// limit by default. Not used with WithDialer.
+func ConnectTimeout(d time.Duration) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.ConnectTimeout = d
+	}
+}
+
+// WriteTimeout limits how long writing a command to a connection may take,
+// no limit by default
+func WriteTimeout(d time.Duration) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.WriteTimeout = d
+	}
+}
+
+// WithStorage keeps the watcher's auxiliary state, such as snapshots, on the
+// given Storage instead of the publish connection
+func WithStorage(storage Storage) WatcherOption {
+	return func(options *WatcherOptions) {
+		options.Storage = storage
+	}
+}
+
 // IsCallbackPending
 func IsCallbackPending(w *Watcher, shouldClear bool) bool {
-	r := w.options.callbackPending
-	if shouldClear {
-		w.options.callbackPending = false
+	return w.squash.isPending(shouldClear)
+}
+
+// WatcherOptionsSnapshot is a copy of the watcher's configuration returned by
+// GetWatcherOptions. It holds no connections, callbacks or secrets, so it is
+// safe to log and to inspect concurrently with the watcher's operation.
+type WatcherOptionsSnapshot struct {
+	Channel        string
+	Channels       []string
+	ChannelPrefix  string
+	ControlChannel string
+	Transport      string
+	Protocol       string
+	Addresses      []string
+	Failover       bool
+
+	LocalID     string
+	GroupID     string
+	IgnoreSelf  bool
+	IgnoreGroup bool
+	Tenants     []string
+
+	// Username is the static username, Authenticated is set when a
+	// Password or CredentialsProvider is configured, Encrypted when a
+	// KeyProvider is
+	Username      string
+	Authenticated bool
+	Encrypted     bool
+
+	// InjectedConnections is set when the connections were passed with
+	// WithRedisPubConnection or WithRedisSubConnection
+	InjectedConnections bool
+
+	EnvelopeMessages   bool
+	Codec              string
+	SquashMessages     bool
+	SquashTimeoutShort time.Duration
+	SquashTimeoutLong  time.Duration
+	SquashMaxDelay     time.Duration
+	SquashMaxCount     int
+
+	QueueSize       int
+	QueueOverflow   string
+	CallbackWorkers int
+
+	MaxReconnectAttempts int
+	ReconnectThreshold   time.Duration
+	SubscribeTimeout     time.Duration
+	KeepAliveInterval    time.Duration
+	ReceiveTimeout       time.Duration
+
+	PublishRetries   int
+	CircuitThreshold int
+	OutboxSize       int
+	MaxMessageSize   int
+
+	VersionKey string
+	LeaderKey  string
+}
+
+// snapshot copies the inspectable options
+func (options *WatcherOptions) snapshot() WatcherOptionsSnapshot {
+	return WatcherOptionsSnapshot{
+		Channel:        options.Channel,
+		Channels:       append([]string(nil), options.Channels...),
+		ChannelPrefix:  options.ChannelPrefix,
+		ControlChannel: options.ControlChannel,
+		Transport:      options.Transport,
+		Protocol:       options.Protocol,
+		Addresses:      append([]string(nil), options.Addresses...),
+		Failover:       options.Failover,
+
+		LocalID:     options.LocalID,
+		GroupID:     options.GroupID,
+		IgnoreSelf:  options.IgnoreSelf,
+		IgnoreGroup: options.IgnoreGroup,
+		Tenants:     append([]string(nil), options.Tenants...),
+
+		Username:      options.Username,
+		Authenticated: options.Password != "" || options.CredentialsProvider != nil,
+		Encrypted:     options.KeyProvider != nil,
+
+		InjectedConnections: options.PubConn != nil || options.SubConn != nil,
+
+		EnvelopeMessages:   options.EnvelopeMessages,
+		Codec:              fmt.Sprintf("%T", options.Codec),
+		SquashMessages:     options.SquashMessages,
+		SquashTimeoutShort: options.SquashTimeoutShort,
+		SquashTimeoutLong:  options.SquashTimeoutLong,
+		SquashMaxDelay:     options.SquashMaxDelay,
+		SquashMaxCount:     options.SquashMaxCount,
+
+		QueueSize:       options.QueueSize,
+		QueueOverflow:   options.QueueOverflow,
+		CallbackWorkers: options.CallbackWorkers,
+
+		MaxReconnectAttempts: options.MaxReconnectAttempts,
+		ReconnectThreshold:   options.ReconnectThreshold,
+		SubscribeTimeout:     options.SubscribeTimeout,
+		KeepAliveInterval:    options.KeepAliveInterval,
+		ReceiveTimeout:       options.ReceiveTimeout,
+
+		PublishRetries:   options.PublishRetries,
+		CircuitThreshold: options.CircuitThreshold,
+		OutboxSize:       options.OutboxSize,
+		MaxMessageSize:   options.MaxMessageSize,
+
+		VersionKey: options.VersionKey,
+		LeaderKey:  options.LeaderKey,
 	}
-	return r
 }
@@ -0,0 +1,125 @@
+package rediswatcher
+
+import (
+	"sort"
+	"time"
+)
+
+const defaultReorderTimeout = 100 * time.Millisecond
+
+// reorderBuffer releases envelope messages strictly in sequence order per
+// sender. Messages that arrive ahead of a missing sequence number are held
+// until the gap is filled or the reorder timeout expires, in which case the
+// gap is skipped. Messages without a sequence number pass straight through.
+type reorderBuffer struct {
+	timeout time.Duration
+	senders map[string]*senderOrder
+}
+
+type senderOrder struct {
+	next     uint64
+	pending  map[uint64]*UpdateMessage
+	deadline time.Time
+}
+
+func newReorderBuffer(timeout time.Duration) *reorderBuffer {
+	return &reorderBuffer{
+		timeout: timeout,
+		senders: make(map[string]*senderOrder),
+	}
+}
+
+// add accepts msg, numbered in the sequence identified by key, and returns
+// the messages that are ready for processing, in order. duplicate is set when
+// msg was already released or is already held.
+func (b *reorderBuffer) add(key string, msg *UpdateMessage, now time.Time) (ready []*UpdateMessage, duplicate bool) {
+	if msg.Seq == 0 {
+		return []*UpdateMessage{msg}, false
+	}
+
+	s, ok := b.senders[key]
+	if !ok || msg.Seq == 1 {
+		// first message from this sender, or the sender restarted
+		s = &senderOrder{next: msg.Seq, pending: make(map[uint64]*UpdateMessage)}
+		b.senders[key] = s
+	}
+
+	switch {
+	case msg.Seq < s.next:
+		return nil, true
+	case msg.Seq > s.next:
+		if _, ok := s.pending[msg.Seq]; ok {
+			return nil, true
+		}
+		s.pending[msg.Seq] = msg
+		if s.deadline.IsZero() {
+			s.deadline = now.Add(b.timeout)
+		}
+		return nil, false
+	}
+
+	ready = append(ready, msg)
+	s.next++
+	return s.release(ready, now, b.timeout), false
+}
+
+// release appends the pending messages that directly follow next
+func (s *senderOrder) release(ready []*UpdateMessage, now time.Time, timeout time.Duration) []*UpdateMessage {
+	for {
+		msg, ok := s.pending[s.next]
+		if !ok {
+			break
+		}
+		delete(s.pending, s.next)
+		ready = append(ready, msg)
+		s.next++
+	}
+	if len(s.pending) == 0 {
+		s.deadline = time.Time{}
+	} else {
+		s.deadline = now.Add(timeout)
+	}
+	return ready
+}
+
+// expire skips the gaps of senders whose reorder timeout elapsed and returns
+// the messages released by doing so, along with the number of sequence
+// numbers skipped
+func (b *reorderBuffer) expire(now time.Time) (ready []*UpdateMessage, skipped uint64) {
+	for _, s := range b.senders {
+		if s.deadline.IsZero() || now.Before(s.deadline) {
+			continue
+		}
+		seqs := make([]uint64, 0, len(s.pending))
+		for seq := range s.pending {
+			seqs = append(seqs, seq)
+		}
+		sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+
+		skipped += seqs[0] - s.next
+		s.next = seqs[0]
+		ready = s.release(ready, now, b.timeout)
+	}
+	return ready, skipped
+}
+
+// timer returns a channel that fires at the earliest reorder deadline, or
+// nil if no messages are held back
+func (b *reorderBuffer) timer(now time.Time) <-chan time.Time {
+	var earliest time.Time
+	for _, s := range b.senders {
+		if !s.deadline.IsZero() && (earliest.IsZero() || s.deadline.Before(earliest)) {
+			earliest = s.deadline
+		}
+	}
+	if earliest.IsZero() {
+		return nil
+	}
+	return time.After(earliest.Sub(now))
+}
+
+// sequenceKey identifies the sequence msg is numbered in: updates are
+// numbered per sender and channel
+func (w *Watcher) sequenceKey(msg *UpdateMessage) string {
+	return msg.LocalID + "\x00" + w.messageChannel(msg)
+}
@@ -0,0 +1,87 @@
+package rediswatcher
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func seqs(msgs []*UpdateMessage) []uint64 {
+	var s []uint64
+	for _, msg := range msgs {
+		s = append(s, msg.Seq)
+	}
+	return s
+}
+
+func TestReorderBuffer(t *testing.T) {
+	b := newReorderBuffer(time.Second)
+	now := time.Now()
+
+	msg := func(seq uint64) *UpdateMessage {
+		return &UpdateMessage{Type: UpdateMessageType, LocalID: "node1", Seq: seq}
+	}
+
+	if ready, _ := b.add("node1", msg(3), now); len(ready) != 1 {
+		t.Fatalf("First message should be released, received %v", seqs(ready))
+	}
+	if ready, _ := b.add("node1", msg(5), now); len(ready) != 0 {
+		t.Fatalf("Message ahead of a gap should be held, received %v", seqs(ready))
+	}
+	if b.timer(now) == nil {
+		t.Fatal("Held messages should set a reorder timer")
+	}
+	ready, _ := b.add("node1", msg(4), now)
+	if got := seqs(ready); len(got) != 2 || got[0] != 4 || got[1] != 5 {
+		t.Fatalf("Filling the gap should release 4 and 5, received %v", got)
+	}
+	if _, duplicate := b.add("node1", msg(4), now); !duplicate {
+		t.Error("Replayed message should be reported as a duplicate")
+	}
+
+	b.add("node1", msg(8), now)
+	b.add("node1", msg(7), now)
+	if ready, skipped := b.expire(now); len(ready) != 0 || skipped != 0 {
+		t.Fatalf("Nothing should expire before the timeout, received %v", seqs(ready))
+	}
+	ready, skipped := b.expire(now.Add(2 * time.Second))
+	if got := seqs(ready); len(got) != 2 || got[0] != 7 || got[1] != 8 || skipped != 1 {
+		t.Fatalf("Expiring should skip 6 and release 7 and 8, received %v skipping %d", got, skipped)
+	}
+	if b.timer(now) != nil {
+		t.Error("No reorder timer should be set without held messages")
+	}
+
+	if ready, _ := b.add("node1", msg(1), now); len(ready) != 1 {
+		t.Error("Sequence restart should be accepted")
+	}
+	if ready, _ := b.add("node1", &UpdateMessage{Type: UpdateMessageType, LocalID: "legacy"}, now); len(ready) != 1 {
+		t.Error("Message without sequence should pass through")
+	}
+}
+
+func TestSequencePerChannel(t *testing.T) {
+	c := &publishConn{testConn: NewTestConn()}
+	c.Clear()
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		TenantChannels(true), ControlChannel("/casbin:control"))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	rw.UpdateTenant("tenant1")
+	rw.SendCommand(ReloadAllCommand, "")
+	rw.publishMessage(context.Background(), &UpdateMessage{Type: SnapshotRequestMessageType})
+	rw.UpdateTenant("tenant2")
+	rw.UpdateTenant("tenant1")
+
+	var got []uint64
+	for i, data := range c.published {
+		msg := decodeMessage(c.channels[i], []byte(data))
+		got = append(got, msg.Seq)
+	}
+	if len(got) != 5 || got[0] != 1 || got[1] != 0 || got[2] != 0 || got[3] != 1 || got[4] != 2 {
+		t.Errorf("Expected updates numbered per channel and other messages without a sequence, received %v", got)
+	}
+}
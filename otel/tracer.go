@@ -0,0 +1,101 @@
+// Package otel instruments a rediswatcher.Watcher with OpenTelemetry spans
+// and propagates the W3C trace context through the message envelope.
+package otel
+
+import (
+	"context"
+
+	rediswatcher "github.com/billcobbler/casbin-redis-watcher/v2"
+	otelapi "go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/billcobbler/casbin-redis-watcher/v2/otel"
+
+// Option configures the tracer returned by NewTracer
+type Option func(*tracer)
+
+// WithTracerProvider sets the provider spans are created with, the global
+// provider is used by default
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(t *tracer) {
+		t.provider = provider
+	}
+}
+
+// WithPropagator sets the propagator the trace context is carried with, the
+// W3C trace context propagator is used by default
+func WithPropagator(propagator propagation.TextMapPropagator) Option {
+	return func(t *tracer) {
+		t.propagator = propagator
+	}
+}
+
+type tracer struct {
+	provider   trace.TracerProvider
+	propagator propagation.TextMapPropagator
+	tracer     trace.Tracer
+}
+
+// NewTracer returns a rediswatcher.Tracer creating OpenTelemetry spans, use
+// it with rediswatcher.WithTracer
+func NewTracer(opts ...Option) rediswatcher.Tracer {
+	t := &tracer{
+		provider:   otelapi.GetTracerProvider(),
+		propagator: propagation.TraceContext{},
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	t.tracer = t.provider.Tracer(instrumentationName)
+	return t
+}
+
+func (t *tracer) StartPublish(ctx context.Context, msg *rediswatcher.UpdateMessage) (context.Context, func(err error)) {
+	ctx, span := t.tracer.Start(ctx, "rediswatcher.publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attributes(msg)...))
+	if msg.Trace == nil {
+		msg.Trace = make(map[string]string)
+	}
+	t.propagator.Inject(ctx, propagation.MapCarrier(msg.Trace))
+	return ctx, end(span)
+}
+
+func (t *tracer) StartReceive(ctx context.Context, msg *rediswatcher.UpdateMessage) (context.Context, func(err error)) {
+	if msg.Trace != nil {
+		ctx = t.propagator.Extract(ctx, propagation.MapCarrier(msg.Trace))
+	}
+	ctx, span := t.tracer.Start(ctx, "rediswatcher.receive",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attributes(msg)...))
+	return ctx, end(span)
+}
+
+func attributes(msg *rediswatcher.UpdateMessage) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.String("messaging.system", "redis"),
+		attribute.String("rediswatcher.message.type", msg.Type),
+		attribute.String("rediswatcher.local_id", msg.LocalID),
+	}
+	if msg.Channel != "" {
+		attrs = append(attrs, attribute.String("messaging.destination.name", msg.Channel))
+	}
+	if msg.Seq != 0 {
+		attrs = append(attrs, attribute.Int64("rediswatcher.message.seq", int64(msg.Seq)))
+	}
+	return attrs
+}
+
+func end(span trace.Span) func(err error) {
+	return func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
@@ -0,0 +1,39 @@
+package otel
+
+import (
+	"context"
+	"testing"
+
+	rediswatcher "github.com/billcobbler/casbin-redis-watcher/v2"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracerPropagation(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	tracer := NewTracer(WithTracerProvider(provider))
+
+	msg := &rediswatcher.UpdateMessage{Type: rediswatcher.UpdateMessageType, LocalID: "node1", Seq: 1}
+	_, endPublish := tracer.StartPublish(context.Background(), msg)
+	endPublish(nil)
+	if msg.Trace["traceparent"] == "" {
+		t.Fatal("Publish should inject a traceparent into the message")
+	}
+
+	ctx, endReceive := tracer.StartReceive(context.Background(), msg)
+	endReceive(nil)
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, received %d", len(spans))
+	}
+	publish, receive := spans[0], spans[1]
+	if receive.Parent().SpanID() != publish.SpanContext().SpanID() {
+		t.Error("Receive span should be a child of the publish span")
+	}
+	if trace.SpanContextFromContext(ctx).TraceID() != publish.SpanContext().TraceID() {
+		t.Error("Receive context should continue the publisher's trace")
+	}
+}
@@ -0,0 +1,81 @@
+package rediswatcher
+
+import (
+	"time"
+)
+
+const outboxFlushInterval = time.Second
+
+// outboxEntry is a sealed message waiting in the Outbox
+type outboxEntry struct {
+	channel string
+	data    string
+	id      string
+}
+
+// addOutbox keeps a message that failed to publish in the outbox, dropping
+// the oldest message when it is full
+func (w *Watcher) addOutbox(entry outboxEntry) {
+	w.outboxMu.Lock()
+	defer w.outboxMu.Unlock()
+	if len(w.outbox) >= w.options.OutboxSize {
+		dropped := w.outbox[0]
+		w.outbox = w.outbox[1:]
+		w.options.Logger.Warn("Outbox full, dropping message", "channel", w.options.Channel, "localID", w.options.LocalID, "id", dropped.id)
+		if w.options.RecordMetrics != nil {
+			m := w.createMetrics(DroppedMessageMetric, time.Now(), nil)
+			m.MessageID = dropped.id
+			w.options.RecordMetrics(m)
+		}
+	}
+	w.outbox = append(w.outbox, entry)
+}
+
+// drainOutbox publishes the messages in the outbox every second until the
+// watcher is closed
+func (w *Watcher) drainOutbox() {
+	ticker := time.NewTicker(outboxFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.closed:
+			return
+		case <-ticker.C:
+			w.flushOutbox()
+		}
+	}
+}
+
+// flushOutbox publishes the messages in the outbox, oldest first, until one
+// fails
+func (w *Watcher) flushOutbox() {
+	for {
+		w.outboxMu.Lock()
+		if len(w.outbox) == 0 {
+			w.outboxMu.Unlock()
+			return
+		}
+		entry := w.outbox[0]
+		w.outboxMu.Unlock()
+
+		if w.breaker != nil && !w.breaker.allow() {
+			return
+		}
+		_, err := w.publishOnce(entry.channel, entry.data, entry.id)
+		if w.breaker != nil {
+			w.breaker.record(err)
+		}
+		if err != nil {
+			if err := w.reconnectPub(); err != nil {
+				w.options.Logger.Debug("Failure reconnecting to flush the outbox", "channel", w.options.Channel, "localID", w.options.LocalID, "error", err)
+			}
+			return
+		}
+
+		w.outboxMu.Lock()
+		if len(w.outbox) > 0 && w.outbox[0] == entry {
+			w.outbox = w.outbox[1:]
+		}
+		w.outboxMu.Unlock()
+	}
+}
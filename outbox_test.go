@@ -0,0 +1,39 @@
+package rediswatcher
+
+import (
+	"testing"
+)
+
+func TestOutbox(t *testing.T) {
+	c := &flakyConn{testConn: NewTestConn(), failures: 3}
+	c.Clear()
+	pw, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		WithLogger(&testLogger{}), Outbox(2))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	w := pw.(*Watcher)
+	defer w.Close()
+
+	for i := 0; i < 3; i++ {
+		if err := w.UpdateWithPayload(string(rune('a' + i))); err != nil {
+			t.Fatalf("Update should be kept in the outbox: %v", err)
+		}
+	}
+	if len(w.outbox) != 2 {
+		t.Fatalf("Expected 2 messages in the outbox, got %d", len(w.outbox))
+	}
+	first := w.outbox[0]
+
+	w.flushOutbox()
+	if len(w.outbox) != 0 {
+		t.Errorf("Outbox should be empty after flushing, got %d", len(w.outbox))
+	}
+	published := c.published
+	if len(published) != 2 || published[0] != first.data {
+		t.Fatalf("Expected the outbox to be published oldest first, got %v", published)
+	}
+	if msg := decodeMessage("/casbin", []byte(published[0])); msg.Payload != "b" {
+		t.Errorf("Oldest message should have been dropped, got payload %q", msg.Payload)
+	}
+}
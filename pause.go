@@ -0,0 +1,30 @@
+package rediswatcher
+
+import "sync/atomic"
+
+// Pause stops the watcher from invoking the update callback for received
+// updates until Resume, for instance while the application applies a large
+// batch of policy changes itself. The watcher stays subscribed, updates
+// received and reloads triggered while paused are dropped.
+func (w *Watcher) Pause() {
+	atomic.StoreInt32(&w.paused, 1)
+}
+
+// Resume invokes the update callback for received updates again. If catchUp
+// is set and updates were dropped while paused, the callback is invoked once
+// to reload the policy.
+func (w *Watcher) Resume(catchUp bool) {
+	if atomic.SwapInt32(&w.paused, 0) == 0 {
+		return
+	}
+	missed := atomic.SwapInt32(&w.pausedMissed, 0) == 1
+	if catchUp && missed {
+		w.options.Logger.Info("Updates received while paused, reloading", "channel", w.options.Channel, "localID", w.options.LocalID)
+		w.triggerReload(w.options.LocalID)
+	}
+}
+
+// isPaused reports whether the watcher is paused
+func (w *Watcher) isPaused() bool {
+	return atomic.LoadInt32(&w.paused) == 1
+}
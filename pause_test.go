@@ -0,0 +1,81 @@
+package rediswatcher
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPauseResume(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+	rw.reload = make(chan string, 1)
+
+	var received []string
+	w.SetUpdateCallback(func(msg string) { received = append(received, msg) })
+
+	rw.Pause()
+	if d := rw.Explain(UpdateMessage{Type: UpdateMessageType, LocalID: "node2"}); d != DispositionPaused {
+		t.Errorf("Expected disposition %s, got %s", DispositionPaused, d)
+	}
+	rw.processMessage(decodeMessage("/casbin", []byte("node2")))
+	if len(received) != 0 {
+		t.Errorf("Paused watcher should not invoke the callback, received %v", received)
+	}
+
+	rw.Resume(true)
+	select {
+	case data := <-rw.reload:
+		if data != "node1" {
+			t.Errorf("Catch-up reload should pass the local ID, received '%s'", data)
+		}
+	default:
+		t.Error("Resume should reload the policy when updates were dropped")
+	}
+	rw.processMessage(decodeMessage("/casbin", []byte("node2")))
+	if len(received) != 1 {
+		t.Errorf("Resumed watcher should invoke the callback, received %v", received)
+	}
+
+	rw.Pause()
+	rw.Resume(true)
+	if len(rw.reload) != 0 {
+		t.Error("Resume should not reload when no updates were dropped")
+	}
+}
+
+func TestPauseReload(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	c.Command("SUBSCRIBE", "/casbin").Expect([]interface{}{[]byte("subscribe"), []byte("/casbin"), []byte("1")})
+	w, err := NewWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	defer w.Close()
+	rw := w.(*Watcher)
+
+	ch := make(chan string, 2)
+	w.SetUpdateCallback(func(msg string) { ch <- msg })
+
+	rw.Pause()
+	rw.triggerReload("node1")
+	rw.Resume(true)
+	select {
+	case msg := <-ch:
+		if msg != "node1" {
+			t.Errorf("Catch-up reload should pass the local ID, received '%s'", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Resume should reload the policy when a reload was dropped")
+	}
+	select {
+	case msg := <-ch:
+		t.Errorf("Paused watcher should not invoke the callback for reloads, received '%s'", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
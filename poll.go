@@ -0,0 +1,89 @@
+package rediswatcher
+
+import (
+	"strconv"
+	"time"
+)
+
+const defaultPollInterval = 5 * time.Second
+
+// poll reads VersionKey every interval until the watcher is closed
+func (w *Watcher) poll(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.closed:
+			return
+		case <-ticker.C:
+			w.pollVersion()
+		}
+	}
+}
+
+// pollVersion reads VersionKey and invokes the update callback with its value
+// if it changed since the last poll, it reports whether it did. VersionKey
+// normally holds the counter incremented by Update, any other value, such as
+// a hash of the policy, is compared as is. With the push transports a new
+// counter only invokes the callback once its push was missed, see missedPush.
+func (w *Watcher) pollVersion() bool {
+	startTime := time.Now()
+	data, err := w.storage.Get(w.options.VersionKey)
+	if w.options.RecordMetrics != nil {
+		w.options.RecordMetrics(w.createMetrics(PollMetric, startTime, err))
+	}
+	if err != nil {
+		w.options.Logger.Error("Failure polling policy version", "channel", w.options.Channel, "localID", w.options.LocalID, "key", w.options.VersionKey, "error", err)
+		w.handleError(err)
+		return false
+	}
+
+	value := string(data)
+	var changed bool
+	remote, err := strconv.ParseInt(value, 10, 64)
+	switch {
+	case (err == nil || data == nil) && w.options.Transport != PollTransport:
+		if changed = w.missedPush(remote); changed {
+			w.options.Logger.Warn("Update missed by push, reloading", "channel", w.options.Channel, "localID", w.options.LocalID, "key", w.options.VersionKey, "version", value)
+			if w.options.RecordMetrics != nil {
+				w.options.RecordMetrics(w.createMetrics(MissedPushMetric, startTime, nil))
+			}
+		}
+	case err == nil || data == nil:
+		changed = w.syncVersion(remote)
+	default:
+		w.versionMu.Lock()
+		changed = w.versionSynced && value != w.versionValue
+		w.versionSynced = true
+		w.versionValue = value
+		w.versionMu.Unlock()
+	}
+	if changed {
+		w.triggerReload(value)
+	}
+	return changed
+}
+
+// missedPush records the polled remote version and reports whether the
+// version polled last time is still ahead of the updates delivered by push,
+// which gives the push of an update one poll interval to arrive. The first
+// call only records the version.
+func (w *Watcher) missedPush(remote int64) bool {
+	w.versionMu.Lock()
+	defer w.versionMu.Unlock()
+	if !w.versionSynced {
+		w.versionSynced = true
+		w.version = remote
+		return false
+	}
+	missed := w.versionAhead > w.version
+	if missed && remote > w.version {
+		// the reload applies the latest policy
+		w.version = remote
+	}
+	w.versionAhead = 0
+	if remote > w.version {
+		w.versionAhead = remote
+	}
+	return missed
+}
@@ -0,0 +1,110 @@
+package rediswatcher
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPollTransport(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	c.Command("GET", "casbin:version").Expect([]byte("3")).Expect([]byte("3")).Expect([]byte("5"))
+
+	w, err := NewWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c),
+		WithTransport(PollTransport), VersionKey("casbin:version"), PollInterval(time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	defer w.Close()
+	rw := w.(*Watcher)
+
+	ch := make(chan string, 1)
+	w.SetUpdateCallback(func(msg string) { ch <- msg })
+
+	if rw.pollVersion() {
+		t.Error("An unchanged version should not invoke the update callback")
+	}
+	if !rw.pollVersion() {
+		t.Fatal("A new version should invoke the update callback")
+	}
+	select {
+	case msg := <-ch:
+		if msg != "5" {
+			t.Errorf("Callback should receive the version '5', received '%s' instead", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Update callback was not invoked")
+	}
+
+	c.Command("INCR", "casbin:version").Expect(int64(6))
+	c.Command("GET", "casbin:version").Expect([]byte("6"))
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to update: %v", err)
+	}
+	if rw.pollVersion() {
+		t.Error("The watcher's own update should not invoke the update callback")
+	}
+}
+
+func TestPollHashValue(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	c.Command("GET", "casbin:hash").Expect([]byte("a1f3")).Expect([]byte("9bc0"))
+
+	w, err := NewWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c),
+		WithTransport(PollTransport), VersionKey("casbin:hash"), PollInterval(time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	defer w.Close()
+	w.SetUpdateCallback(func(string) {})
+
+	if !w.(*Watcher).pollVersion() {
+		t.Error("A changed hash should invoke the update callback")
+	}
+}
+
+func TestMissedPush(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	c.Command("GET", "casbin:version").Expect([]byte("1")).Expect([]byte("2")).Expect([]byte("4")).Expect([]byte("4")).
+		Expect([]byte("6")).Expect([]byte("6"))
+
+	var missed int
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c),
+		VersionKey("casbin:version"), VersionPollInterval(time.Hour),
+		RecordMetrics(func(m *WatcherMetrics) {
+			if m.Name == MissedPushMetric {
+				missed++
+			}
+		}))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+	rw.reload = make(chan string, 1)
+	w.SetUpdateCallback(func(string) {})
+
+	rw.pollVersion()
+	// the push of version 2 was delivered
+	rw.processMessage(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Version: 2})
+	if rw.pollVersion() {
+		t.Error("A version delivered by push should not be reported as missed")
+	}
+	if rw.pollVersion() || missed != 0 {
+		t.Error("A version ahead should not be reported as missed before the next poll")
+	}
+	if !rw.pollVersion() || missed != 1 {
+		t.Errorf("Version 4 was missed by push, expected 1 %s metric, received %d", MissedPushMetric, missed)
+	}
+	if data := <-rw.reload; data != "4" {
+		t.Errorf("Reload should be triggered with version '4', received '%s'", data)
+	}
+
+	rw.pollVersion()
+	// the push of version 6 arrived after the poll
+	rw.processMessage(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Version: 6})
+	if rw.pollVersion() || missed != 1 {
+		t.Error("A push delivered before the next poll should not be reported as missed")
+	}
+}
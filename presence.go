@@ -0,0 +1,141 @@
+package rediswatcher
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultHeartbeatInterval is assumed by GetPeers when the watcher
+	// doesn't send heartbeats itself
+	defaultHeartbeatInterval = 10 * time.Second
+	// peers are considered gone after missing this many heartbeats
+	missedHeartbeats = 3
+	// the presence of peers is removed once it is this many times older than
+	// that, leaving room for clock skew between hosts
+	stalePresence = 10
+)
+
+// Presence is the heartbeat a watcher writes to the PresenceKey hash, under
+// its LocalID
+type Presence struct {
+	LocalID  string `json:"localID"`
+	Hostname string `json:"hostname,omitempty"`
+	Channel  string `json:"channel"`
+	// Version is the last policy version the watcher applied, see
+	// VersionKey, and Seq the sequence number of the last update it
+	// published on Channel
+	Version  int64     `json:"version,omitempty"`
+	Seq      uint64    `json:"seq"`
+	Received uint64    `json:"received"`
+	Time     time.Time `json:"time"`
+}
+
+// presenceKey returns the key of the presence hash, the prefixed channel
+// with a ":presence" suffix by default
+func (w *Watcher) presenceKey() string {
+	if w.options.PresenceKey != "" {
+		return w.options.PresenceKey
+	}
+	return w.prefixed(w.options.Channel) + ":presence"
+}
+
+// heartbeat writes the watcher's presence every HeartbeatInterval until the
+// watcher is closed
+func (w *Watcher) heartbeat() {
+	w.writePresence()
+	ticker := time.NewTicker(w.options.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.closed:
+			return
+		case <-ticker.C:
+			w.writePresence()
+		}
+	}
+}
+
+// writePresence writes the watcher's presence to the presence hash
+func (w *Watcher) writePresence() {
+	startTime := time.Now()
+	hostname, _ := os.Hostname()
+	w.versionMu.Lock()
+	version := w.version
+	w.versionMu.Unlock()
+	data, err := json.Marshal(&Presence{
+		LocalID:  w.options.LocalID,
+		Hostname: hostname,
+		Channel:  w.options.Channel,
+		Version:  version,
+		Seq:      w.lastPublishedSeq(),
+		Received: atomic.LoadUint64(&w.stats.received),
+		Time:     startTime,
+	})
+	if err == nil {
+		err = w.storage.HashSet(w.presenceKey(), w.options.LocalID, data)
+	}
+	if w.options.RecordMetrics != nil {
+		w.options.RecordMetrics(w.createMetrics(HeartbeatMetric, startTime, err))
+	}
+	if err != nil {
+		w.options.Logger.Error("Failure writing presence", "channel", w.options.Channel, "localID", w.options.LocalID, "key", w.presenceKey(), "error", err)
+		w.handleError(err)
+	}
+}
+
+// lastPublishedSeq returns the sequence number of the last update published
+// on the channel
+func (w *Watcher) lastPublishedSeq() uint64 {
+	w.seqMu.Lock()
+	defer w.seqMu.Unlock()
+	return w.seqs[w.options.Channel]
+}
+
+// removePresence removes the watcher's presence when it is closed
+func (w *Watcher) removePresence() {
+	if err := w.storage.HashDelete(w.presenceKey(), w.options.LocalID); err != nil {
+		w.options.Logger.Error("Failure removing presence", "channel", w.options.Channel, "localID", w.options.LocalID, "key", w.presenceKey(), "error", err)
+		w.handleError(err)
+	}
+}
+
+// GetPeers returns the presence of the watchers that sent a heartbeat within
+// the last three HeartbeatIntervals, sorted by LocalID, including this
+// watcher if it sends heartbeats. Invalid presence, and that of watchers
+// gone for ten times as long, is removed from the hash unless it changed in
+// the meantime.
+func (w *Watcher) GetPeers() ([]Presence, error) {
+	hash, err := w.storage.HashGetAll(w.presenceKey())
+	if err != nil {
+		return nil, err
+	}
+	interval := w.options.HeartbeatInterval
+	if interval <= 0 {
+		interval = defaultHeartbeatInterval
+	}
+	expiry := time.Now().Add(-missedHeartbeats * interval)
+	stale := time.Now().Add(-stalePresence * missedHeartbeats * interval)
+
+	peers := make([]Presence, 0, len(hash))
+	for localID, data := range hash {
+		var presence Presence
+		err := json.Unmarshal(data, &presence)
+		if err != nil || presence.Time.Before(stale) {
+			// the peer may have written a heartbeat since it was read
+			if _, err := w.storage.HashCompareAndDelete(w.presenceKey(), localID, data); err != nil {
+				w.handleError(err)
+			}
+			continue
+		}
+		if presence.Time.Before(expiry) {
+			continue
+		}
+		peers = append(peers, presence)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].LocalID < peers[j].LocalID })
+	return peers, nil
+}
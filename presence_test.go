@@ -0,0 +1,89 @@
+package rediswatcher
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestHeartbeat(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	storage := newMemoryStorage()
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		WithStorage(storage), Heartbeat(time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+
+	var hash map[string][]byte
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if hash, _ = storage.HashGetAll("/casbin:presence"); len(hash) > 0 {
+			break
+		}
+	}
+	var presence Presence
+	if err := json.Unmarshal(hash["node1"], &presence); err != nil {
+		t.Fatalf("Failed to decode presence: %v", err)
+	}
+	if presence.LocalID != "node1" || presence.Channel != "/casbin" || presence.Time.IsZero() {
+		t.Errorf("Unexpected presence %+v", presence)
+	}
+
+	w.Close()
+	if hash, _ := storage.HashGetAll("/casbin:presence"); len(hash) != 0 {
+		t.Errorf("Presence should be removed on close, found %v", hash)
+	}
+}
+
+func TestGetPeers(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	storage := newMemoryStorage()
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		WithStorage(storage), Heartbeat(time.Second))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	defer w.Close()
+	rw := w.(*Watcher)
+
+	for _, p := range []Presence{
+		{LocalID: "node3", Time: time.Now()},
+		{LocalID: "node2", Time: time.Now().Add(-time.Second)},
+		{LocalID: "node4", Time: time.Now().Add(-time.Minute)},
+		{LocalID: "node6", Time: time.Now().Add(-10 * time.Second)},
+	} {
+		data, _ := json.Marshal(p)
+		storage.HashSet("/casbin:presence", p.LocalID, data)
+	}
+	storage.HashSet("/casbin:presence", "node5", []byte("garbage"))
+	rw.writePresence()
+
+	peers, err := rw.GetPeers()
+	if err != nil {
+		t.Fatalf("GetPeers failed: %v", err)
+	}
+	var ids []string
+	for _, p := range peers {
+		ids = append(ids, p.LocalID)
+	}
+	if len(ids) != 3 || ids[0] != "node1" || ids[1] != "node2" || ids[2] != "node3" {
+		t.Errorf("Expected the live peers node1, node2 and node3, got %v", ids)
+	}
+	hash, _ := storage.HashGetAll("/casbin:presence")
+	if hash["node4"] != nil || hash["node5"] != nil {
+		t.Error("Stale and invalid presence should be removed")
+	}
+	if hash["node6"] == nil {
+		t.Error("Recently expired presence should be kept, its host's clock may be behind")
+	}
+
+	// node4 sends a heartbeat again between reading and removing
+	stale := []byte(`{"localID":"node4","time":"2000-01-01T00:00:00Z"}`)
+	fresh, _ := json.Marshal(Presence{LocalID: "node4", Time: time.Now()})
+	storage.HashSet("/casbin:presence", "node4", fresh)
+	if ok, _ := storage.HashCompareAndDelete("/casbin:presence", "node4", stale); ok {
+		t.Error("Presence should only be removed if unchanged")
+	}
+}
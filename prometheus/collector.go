@@ -0,0 +1,144 @@
+// Package prometheus exposes the metrics reported by a rediswatcher.Watcher
+// as a prometheus.Collector.
+package prometheus
+
+import (
+	"sync"
+
+	rediswatcher "github.com/billcobbler/casbin-redis-watcher/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector turns WatcherMetrics into prometheus counters and latency
+// histograms of the timed redis operations and callbacks. Pass its
+// RecordMetrics method to rediswatcher.RecordMetrics and register it with a
+// prometheus.Registerer; one Collector can be shared by several watchers.
+type Collector struct {
+	publishes  *prometheus.CounterVec
+	receives   *prometheus.CounterVec
+	errors     *prometheus.CounterVec
+	reconnects *prometheus.CounterVec
+	latency    *prometheus.HistogramVec
+
+	mu         sync.Mutex
+	subscribed map[string]bool
+}
+
+// timed are the operations whose latency is observed, other metrics report
+// events or time spent waiting for messages
+var timed = map[string]bool{
+	rediswatcher.RedisDoAuthMetric:       true,
+	rediswatcher.RedisCloseMetric:        true,
+	rediswatcher.RedisDialMetric:         true,
+	rediswatcher.RedisPingMetric:         true,
+	rediswatcher.PubSubPublishMetric:     true,
+	rediswatcher.PubSubSubscribeMetric:   true,
+	rediswatcher.PubSubUnsubscribeMetric: true,
+	rediswatcher.SnapshotWriteMetric:     true,
+	rediswatcher.SnapshotLoadMetric:      true,
+	rediswatcher.StreamAddMetric:         true,
+	rediswatcher.StreamClaimMetric:       true,
+	rediswatcher.PollMetric:              true,
+	rediswatcher.CallbackMetric:          true,
+	rediswatcher.SubscribersMetric:       true,
+	rediswatcher.HeartbeatMetric:         true,
+	rediswatcher.FanoutPublishMetric:     true,
+	rediswatcher.DNSResolveMetric:        true,
+}
+
+// NewCollector returns a Collector whose metrics are prefixed with namespace
+func NewCollector(namespace string) *Collector {
+	return &Collector{
+		publishes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "rediswatcher",
+			Name:      "publishes_total",
+			Help:      "Number of updates published.",
+		}, []string{"channel"}),
+		receives: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "rediswatcher",
+			Name:      "receives_total",
+			Help:      "Number of messages received.",
+		}, []string{"channel"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "rediswatcher",
+			Name:      "errors_total",
+			Help:      "Number of failed operations.",
+		}, []string{"channel", "operation"}),
+		reconnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "rediswatcher",
+			Name:      "reconnects_total",
+			Help:      "Number of times a subscription was re-established.",
+		}, []string{"channel"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "rediswatcher",
+			Name:      "operation_duration_seconds",
+			Help:      "Latency of redis operations.",
+			Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+		}, []string{"channel", "operation"}),
+		subscribed: make(map[string]bool),
+	}
+}
+
+// RecordMetrics records m, it is meant to be passed to
+// rediswatcher.RecordMetrics
+func (c *Collector) RecordMetrics(m *rediswatcher.WatcherMetrics) {
+	if m.Name == rediswatcher.RuntimeStatsMetric {
+		return
+	}
+	if m.Error != nil {
+		c.errors.WithLabelValues(m.Channel, m.Name).Inc()
+	}
+	if timed[m.Name] {
+		c.latency.WithLabelValues(m.Channel, m.Name).Observe(m.LatencyMs / 1000)
+	}
+
+	switch m.Name {
+	case rediswatcher.PubSubPublishMetric, rediswatcher.StreamAddMetric:
+		if m.Error == nil {
+			c.publishes.WithLabelValues(m.Channel).Inc()
+		}
+	case rediswatcher.PubSubReceiveMetric, rediswatcher.StreamReadMetric:
+		// subscription confirmations and empty reads carry no message
+		if m.Error == nil && m.MessageSize > 0 {
+			c.receives.WithLabelValues(m.Channel).Inc()
+		}
+	case rediswatcher.PubSubSubscribeMetric:
+		if m.Error == nil && c.resubscribed(m.LocalID+"\x00"+m.Channel) {
+			c.reconnects.WithLabelValues(m.Channel).Inc()
+		}
+	}
+}
+
+// resubscribed reports whether the watcher identified by key subscribed before
+func (c *Collector) resubscribed(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.subscribed[key] {
+		return true
+	}
+	c.subscribed[key] = true
+	return false
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.publishes.Describe(ch)
+	c.receives.Describe(ch)
+	c.errors.Describe(ch)
+	c.reconnects.Describe(ch)
+	c.latency.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.publishes.Collect(ch)
+	c.receives.Collect(ch)
+	c.errors.Collect(ch)
+	c.reconnects.Collect(ch)
+	c.latency.Collect(ch)
+}
@@ -0,0 +1,57 @@
+package prometheus
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	rediswatcher "github.com/billcobbler/casbin-redis-watcher/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollector(t *testing.T) {
+	c := NewCollector("test")
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(c); err != nil {
+		t.Fatalf("Failed to register collector: %v", err)
+	}
+
+	record := func(name string, size int64, err error) {
+		c.RecordMetrics(&rediswatcher.WatcherMetrics{Name: name, Channel: "/casbin", LocalID: "node1", LatencyMs: 2, MessageSize: size, Error: err})
+	}
+	record(rediswatcher.PubSubSubscribeMetric, 0, nil)
+	// the subscription confirmation
+	record(rediswatcher.PubSubReceiveMetric, 0, nil)
+	record(rediswatcher.PubSubPublishMetric, 0, nil)
+	record(rediswatcher.PubSubPublishMetric, 0, errors.New("connection refused"))
+	record(rediswatcher.PubSubReceiveMetric, 5, nil)
+	record(rediswatcher.PubSubReceiveMetric, 5, nil)
+	record(rediswatcher.SquashedMessageMetric, 0, nil)
+	record(rediswatcher.QueueLagMetric, 0, nil)
+	record(rediswatcher.PubSubSubscribeMetric, 0, nil)
+
+	expected := `
+# HELP test_rediswatcher_errors_total Number of failed operations.
+# TYPE test_rediswatcher_errors_total counter
+test_rediswatcher_errors_total{channel="/casbin",operation="PubSubPublish"} 1
+# HELP test_rediswatcher_publishes_total Number of updates published.
+# TYPE test_rediswatcher_publishes_total counter
+test_rediswatcher_publishes_total{channel="/casbin"} 1
+# HELP test_rediswatcher_receives_total Number of messages received.
+# TYPE test_rediswatcher_receives_total counter
+test_rediswatcher_receives_total{channel="/casbin"} 2
+# HELP test_rediswatcher_reconnects_total Number of times a subscription was re-established.
+# TYPE test_rediswatcher_reconnects_total counter
+test_rediswatcher_reconnects_total{channel="/casbin"} 1
+`
+	err := testutil.GatherAndCompare(registry, strings.NewReader(expected),
+		"test_rediswatcher_errors_total", "test_rediswatcher_publishes_total",
+		"test_rediswatcher_receives_total", "test_rediswatcher_reconnects_total")
+	if err != nil {
+		t.Error(err)
+	}
+	if n := testutil.CollectAndCount(c, "test_rediswatcher_operation_duration_seconds"); n != 2 {
+		t.Errorf("Expected latency histograms for the 2 timed operations, received %d", n)
+	}
+}
@@ -0,0 +1,30 @@
+module github.com/billcobbler/casbin-redis-watcher/v2/prometheus
+
+go 1.24
+
+require (
+	github.com/billcobbler/casbin-redis-watcher/v2 v2.1.0
+	github.com/prometheus/client_golang v1.23.2
+)
+
+require (
+	github.com/Knetic/govaluate v3.0.1-0.20171022003610-9aa49832a739+incompatible // indirect
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/casbin/casbin/v2 v2.1.0 // indirect
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/garyburd/redigo v1.6.0 // indirect
+	github.com/google/uuid v1.1.1 // indirect
+	github.com/kr/text v0.2.0 // indirect
+	github.com/kylelemons/godebug v1.1.0 // indirect
+	github.com/munnerz/goautoneg v0.0.0-20191010083416-a7dc8b61c822 // indirect
+	github.com/prometheus/client_model v0.6.2 // indirect
+	github.com/prometheus/common v0.66.1 // indirect
+	github.com/prometheus/procfs v0.16.1 // indirect
+	go.yaml.in/yaml/v2 v2.4.2 // indirect
+	golang.org/x/sys v0.35.0 // indirect
+	google.golang.org/protobuf v1.36.8 // indirect
+)
+
+// builds against the watcher in this repository, dependents use the
+// required release
+replace github.com/billcobbler/casbin-redis-watcher/v2 => ../
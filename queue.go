@@ -0,0 +1,129 @@
+package rediswatcher
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Overflow policies selectable with QueueOverflow
+const (
+	OverflowBlock      = "block"
+	OverflowDropOldest = "drop-oldest"
+	OverflowCoalesce   = "coalesce"
+)
+
+// enqueue hands a received message to the message processor, applying the
+// QueueOverflow policy when the queue is full
+func (w *Watcher) enqueue(msg *UpdateMessage) {
+	msg.receivedAt = time.Now()
+	if (w.options.QueueOverflow != OverflowDropOldest && w.options.QueueOverflow != OverflowCoalesce) || !coalescable(msg) {
+		w.send(msg)
+		return
+	}
+	for {
+		select {
+		case w.messagesIn <- msg:
+			return
+		case <-w.closed:
+			return
+		default:
+		}
+		// the queue is full, make room unless msg is ignored anyway
+		if !w.delivers(msg) {
+			w.ackStreams(msg.streamIDs)
+			w.dropped(msg)
+			return
+		}
+		if !w.makeRoom(msg) {
+			// only messages that must not be dropped are queued
+			w.send(msg)
+			return
+		}
+	}
+}
+
+// delivers reports whether msg would reload the policy, queued updates are
+// only dropped in favour of such a message
+func (w *Watcher) delivers(msg *UpdateMessage) bool {
+	switch w.Explain(*msg) {
+	case DispositionDelivered, DispositionSquashed:
+		return true
+	}
+	return false
+}
+
+// send queues msg, waiting for the message processor unless the watcher is
+// closed, since the processor exits then
+func (w *Watcher) send(msg *UpdateMessage) {
+	select {
+	case w.messagesIn <- msg:
+	case <-w.closed:
+	}
+}
+
+// makeRoom drops the oldest, or with OverflowCoalesce all, coalescable
+// queued messages in favour of msg and queues the others again in their
+// order. It reports whether a message was dropped.
+func (w *Watcher) makeRoom(msg *UpdateMessage) bool {
+	var queued []*UpdateMessage
+drain:
+	for len(queued) < cap(w.messagesIn) {
+		select {
+		case old := <-w.messagesIn:
+			queued = append(queued, old)
+		default:
+			break drain
+		}
+	}
+	dropped := false
+	for _, old := range queued {
+		if coalescable(old) && (!dropped || w.options.QueueOverflow == OverflowCoalesce) {
+			// the stream entries are acknowledged once msg was handled
+			msg.streamIDs = append(msg.streamIDs, old.streamIDs...)
+			w.dropped(old)
+			dropped = true
+			continue
+		}
+		w.send(old)
+	}
+	return dropped
+}
+
+// coalescable reports whether msg is a plain update, which the reload
+// triggered by a later plain update applies as well. Scoped updates, updates
+// carrying a payload or waiting for acknowledgements, chunks and the other
+// message types are never dropped by the QueueOverflow policy.
+func coalescable(msg *UpdateMessage) bool {
+	return msg.Type == UpdateMessageType && msg.Payload == msg.LocalID && msg.Chunk == nil &&
+		!msg.AckRequested && msg.Filter == "" && len(msg.Domains) == 0 && msg.Route == "" && msg.Tenant == ""
+}
+
+// dropped reports a message dropped by the QueueOverflow policy
+func (w *Watcher) dropped(msg *UpdateMessage) {
+	if w.options.RecordMetrics != nil {
+		m := w.createMetrics(DroppedMessageMetric, time.Now(), nil)
+		m.MessageID = msg.ID()
+		w.options.RecordMetrics(m)
+	}
+	if w.options.OnDroppedMessage != nil {
+		w.options.OnDroppedMessage(msg)
+	}
+}
+
+// recordLag reports how long msg waited in the queue
+func (w *Watcher) recordLag(msg *UpdateMessage) {
+	if msg.receivedAt.IsZero() {
+		return
+	}
+	now := time.Now()
+	lag := now.Sub(msg.receivedAt)
+	atomic.StoreInt64(&w.stats.queueLag, int64(lag))
+	if w.options.RecordMetrics != nil {
+		m := w.createMetrics(QueueLagMetric, now, nil)
+		m.MessageID = msg.ID()
+		m.QueueLag = lag
+		m.QueueDepth = len(w.messagesIn)
+		m.QueueCapacity = cap(w.messagesIn)
+		w.options.RecordMetrics(m)
+	}
+}
@@ -0,0 +1,126 @@
+package rediswatcher
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQueueOverflow(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	var dropped []uint64
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c),
+		QueueSize(2), QueueOverflow(OverflowDropOldest),
+		OnDroppedMessage(func(msg *UpdateMessage) { dropped = append(dropped, msg.Seq) }))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+	rw.messagesIn = make(chan *UpdateMessage, 2)
+
+	for seq := uint64(1); seq <= 3; seq++ {
+		rw.enqueue(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Payload: "node2", Seq: seq})
+	}
+	if len(dropped) != 1 || dropped[0] != 1 {
+		t.Errorf("The oldest message should be dropped, received %v", dropped)
+	}
+	if msg := <-rw.messagesIn; msg.Seq != 2 {
+		t.Errorf("Queue should continue with message 2, received %d", msg.Seq)
+	}
+
+	rw.options.QueueOverflow = OverflowCoalesce
+	dropped = nil
+	rw.enqueue(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Payload: "node2", Seq: 4})
+	rw.enqueue(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Payload: "node2", Seq: 5})
+	if len(dropped) != 2 || len(rw.messagesIn) != 1 {
+		t.Errorf("Full queue should coalesce to the latest message, dropped %v", dropped)
+	}
+	if msg := <-rw.messagesIn; msg.Seq != 5 {
+		t.Errorf("Queue should hold the latest message, received %d", msg.Seq)
+	}
+}
+
+func TestQueueOverflowKeepsControlMessages(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	var dropped []uint64
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c),
+		QueueSize(3), QueueOverflow(OverflowCoalesce),
+		OnDroppedMessage(func(msg *UpdateMessage) { dropped = append(dropped, msg.Seq) }))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+	rw.messagesIn = make(chan *UpdateMessage, 3)
+
+	rw.enqueue(&UpdateMessage{Type: AckMessageType, LocalID: "node2", Seq: 1})
+	rw.enqueue(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Payload: "node2", Seq: 2})
+	rw.enqueue(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Payload: "node2", Seq: 3, Domains: []string{"a"}})
+	rw.enqueue(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Payload: "node2", Seq: 4})
+	if len(dropped) != 1 || dropped[0] != 2 {
+		t.Errorf("Only the plain update should be dropped, dropped %v", dropped)
+	}
+	for _, seq := range []uint64{1, 3, 4} {
+		if msg := <-rw.messagesIn; msg.Seq != seq {
+			t.Errorf("Queue should continue with message %d, received %d", seq, msg.Seq)
+		}
+	}
+}
+
+func TestQueueOverflowDropsIgnored(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	var dropped []uint64
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		QueueSize(1), QueueOverflow(OverflowCoalesce), IgnoreSelf(true),
+		OnDroppedMessage(func(msg *UpdateMessage) { dropped = append(dropped, msg.Seq) }))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+	rw.messagesIn = make(chan *UpdateMessage, 1)
+
+	rw.enqueue(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Payload: "node2", Seq: 1})
+	rw.enqueue(&UpdateMessage{Type: UpdateMessageType, LocalID: "node1", Payload: "node1", Seq: 2})
+	rw.Pause()
+	rw.enqueue(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Payload: "node2", Seq: 3})
+	if len(dropped) != 2 || dropped[0] != 2 || dropped[1] != 3 {
+		t.Errorf("Ignored updates should be dropped instead of the queued update, dropped %v", dropped)
+	}
+	if msg := <-rw.messagesIn; msg.Seq != 1 {
+		t.Errorf("Queue should keep message 1, received %d", msg.Seq)
+	}
+}
+
+func TestQueueLag(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	var lag *WatcherMetrics
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c),
+		RecordMetrics(func(m *WatcherMetrics) {
+			if m.Name == QueueLagMetric {
+				lag = m
+			}
+		}))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+	rw.messagesIn = make(chan *UpdateMessage, 4)
+
+	rw.enqueue(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Seq: 1})
+	rw.enqueue(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Seq: 2})
+	time.Sleep(10 * time.Millisecond)
+	rw.processMessage(<-rw.messagesIn)
+
+	if lag == nil || lag.QueueLag < 10*time.Millisecond || lag.QueueDepth != 1 || lag.QueueCapacity != 4 {
+		t.Fatalf("Queue lag metric should report the wait and queue depth, received %+v", lag)
+	}
+	if stats := rw.Stats(); stats.QueueLag != lag.QueueLag || stats.QueueDepth != 1 {
+		t.Errorf("Stats should report the queue lag and depth, received %+v", stats)
+	}
+}
@@ -0,0 +1,51 @@
+package rediswatcher
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+func TestReceiveTimeout(t *testing.T) {
+	// a server that accepts connections but never replies
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("Failed to listen: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+	sub, err := redis.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+
+	pub := NewTestConn()
+	w, err := NewPublishWatcher("", WithRedisSubConnection(sub), WithRedisPubConnection(pub),
+		WithLogger(&testLogger{}), ReceiveTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	defer w.Close()
+	rw := w.(*Watcher)
+
+	errs := make(chan error, 1)
+	go func() { errs <- rw.receive(rw.pubSub()) }()
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Error("Receiving should fail with a timeout")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Receiving should give up after the timeout")
+	}
+}
@@ -0,0 +1,57 @@
+package rediswatcher
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultReferenceKeyPrefix = "casbin:payload:"
+	defaultReferenceTTL       = 5 * time.Minute
+)
+
+var errReferenceExpired = errors.New("rediswatcher: referenced payload expired")
+
+// storeReference moves a payload larger than ReferenceThreshold to a key of
+// its own and leaves a reference to the key in msg. The key ends in a random
+// suffix, since the sequence numbers start over when a watcher with a fixed
+// LocalID restarts.
+func (w *Watcher) storeReference(msg *UpdateMessage) error {
+	if w.options.ReferenceThreshold <= 0 || len(msg.Payload) <= w.options.ReferenceThreshold {
+		return nil
+	}
+	key := w.options.ReferenceKeyPrefix + msg.LocalID + ":" + strconv.FormatUint(msg.Seq, 10) + ":" + uuid.New().String()[:8]
+	payload, err := w.seal(msg.Payload)
+	if err != nil {
+		return err
+	}
+	if err := w.storage.Set(key, []byte(payload), w.options.ReferenceTTL); err != nil {
+		return err
+	}
+	msg.Ref, msg.Payload = key, ""
+	return nil
+}
+
+// resolveReference fetches the payload of a message published by reference
+func (w *Watcher) resolveReference(msg *UpdateMessage) error {
+	if msg.Ref == "" {
+		return nil
+	}
+	data, err := w.storage.Get(msg.Ref)
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		return errReferenceExpired
+	}
+	if w.options.KeyProvider != nil {
+		if data, err = w.decrypt(w.decode(msg.Channel, data)); err != nil {
+			return err
+		}
+	}
+	msg.Payload, msg.Ref = string(data), ""
+	return nil
+}
@@ -0,0 +1,50 @@
+package rediswatcher
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/rafaeljusto/redigomock"
+)
+
+func TestPublishByReference(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	storage := newMemoryStorage()
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		WithStorage(storage), ReferenceThreshold(16))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	var received string
+	w.SetUpdateCallback(func(s string) { received = s })
+
+	c.Command("PUBLISH", "/casbin", redigomock.NewAnyData()).Expect("1")
+	payload := strings.Repeat("p, alice, data1, read\n", 10)
+	msg := &UpdateMessage{Type: UpdateMessageType, Payload: payload}
+	if err := rw.publishMessage(context.Background(), msg); err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+	if !strings.HasPrefix(msg.Ref, "casbin:payload:node1:1:") || msg.Payload != "" {
+		t.Fatalf("Large payload should be published by reference, received ref '%s'", msg.Ref)
+	}
+
+	// a restarted watcher with the same LocalID starts over at 1
+	restarted := &UpdateMessage{Type: UpdateMessageType, LocalID: "node1", Seq: 1, Payload: payload}
+	if err := rw.storeReference(restarted); err != nil {
+		t.Fatalf("Failed to store reference: %v", err)
+	}
+	if restarted.Ref == msg.Ref {
+		t.Errorf("References of a restarted watcher shouldn't overwrite earlier ones, both are '%s'", msg.Ref)
+	}
+
+	data, _ := encodeMessage(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Ref: msg.Ref})
+	rw.processMessage(decodeMessage("/casbin", data))
+	if received != payload {
+		t.Errorf("Referenced payload should be delivered, received '%s' instead", received)
+	}
+}
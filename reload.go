@@ -0,0 +1,47 @@
+package rediswatcher
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// ForceReload invokes the update callback with the LocalID, as if an update
+// was received, so that admin endpoints and recovery tooling can reload the
+// policy without reaching into the enforcer. Subscribed watchers hand the
+// reload to the message processor, so that it is serialized with the
+// received updates, publish-only watchers invoke the callback directly. If
+// broadcast is set it then publishes an update so that the other watchers
+// reload as well, and returns the publishing error.
+func (w *Watcher) ForceReload(broadcast bool) error {
+	w.options.Logger.Info("Forcing policy reload", "channel", w.options.Channel, "localID", w.options.LocalID, "broadcast", broadcast)
+	w.requestReload(w.options.LocalID)
+	if !broadcast {
+		return nil
+	}
+	return w.Update()
+}
+
+// requestReload hands a reload to the message processor of a subscribed
+// watcher, or invokes the update callback directly otherwise. It must not be
+// called from the message processor, see reloadNow.
+func (w *Watcher) requestReload(data string) {
+	if w.reload != nil {
+		w.triggerReload(data)
+	} else {
+		w.reloadNow(data)
+	}
+}
+
+// reloadNow invokes the update callback with data on the calling goroutine.
+// It is used by the message processor and by the commands it runs, which
+// must not hand the reload back to the processor. While paused the reload
+// is recorded as missed instead, see Resume.
+func (w *Watcher) reloadNow(data string) {
+	if w.isPaused() {
+		atomic.StoreInt32(&w.pausedMissed, 1)
+		return
+	}
+	if w.hasCallback() {
+		w.deliver(context.Background(), data)
+	}
+}
@@ -0,0 +1,63 @@
+package rediswatcher
+
+import (
+	"testing"
+	"time"
+)
+
+func TestForceReload(t *testing.T) {
+	c := &publishConn{testConn: NewTestConn()}
+	c.Clear()
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	var received []string
+	w.SetUpdateCallback(func(msg string) { received = append(received, msg) })
+
+	if err := rw.ForceReload(false); err != nil {
+		t.Fatalf("ForceReload failed: %v", err)
+	}
+	if len(received) != 1 || received[0] != "node1" {
+		t.Errorf("ForceReload should invoke the callback with the local ID, received %v", received)
+	}
+	if len(c.published) != 0 {
+		t.Errorf("ForceReload without broadcast should not publish, published %v", c.published)
+	}
+
+	if err := rw.ForceReload(true); err != nil {
+		t.Fatalf("ForceReload failed: %v", err)
+	}
+	if len(received) != 2 || len(c.published) != 1 {
+		t.Errorf("ForceReload with broadcast should reload and publish, received %v, published %v", received, c.published)
+	}
+}
+
+func TestForceReloadSubscribed(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	c.Command("SUBSCRIBE", "/casbin").Expect([]interface{}{[]byte("subscribe"), []byte("/casbin"), []byte("1")})
+	w, err := NewWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	defer w.Close()
+	rw := w.(*Watcher)
+
+	ch := make(chan string, 1)
+	w.SetUpdateCallback(func(msg string) { ch <- msg })
+
+	if err := rw.ForceReload(false); err != nil {
+		t.Fatalf("ForceReload failed: %v", err)
+	}
+	select {
+	case msg := <-ch:
+		if msg != "node1" {
+			t.Errorf("ForceReload should invoke the callback with the local ID, received %s", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ForceReload should invoke the callback through the message processor")
+	}
+}
@@ -0,0 +1,76 @@
+package rediswatcher
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const defaultResolveTimeout = 5 * time.Second
+
+// resolver looks up host names with the pure Go resolver, which queries the
+// name servers on every call instead of going through a caching system
+// resolver
+var resolver = &net.Resolver{PreferGo: true}
+
+// resolve looks up the host of a tcp addr afresh with ResolveDNS and returns
+// one of its addresses, a different one on each call so that a dead address
+// returned by the lookup is not dialed forever. IP addresses are returned
+// unchanged.
+func (w *Watcher) resolve(addr string) (string, error) {
+	if !w.options.ResolveDNS || w.options.Protocol != "tcp" {
+		return addr, nil
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil {
+		return addr, nil
+	}
+
+	startTime := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), defaultResolveTimeout)
+	defer cancel()
+	ips, err := resolver.LookupHost(ctx, host)
+	if err == nil && len(ips) == 0 {
+		err = &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	if w.options.RecordMetrics != nil {
+		w.options.RecordMetrics(w.createMetrics(DNSResolveMetric, startTime, err))
+	}
+	if err != nil {
+		return "", err
+	}
+	ip := ips[int(atomic.AddUint32(&w.resolved, 1)-1)%len(ips)]
+	w.options.Logger.Debug("Resolved redis address", "channel", w.options.Channel, "localID", w.options.LocalID, "addr", addr, "ip", ip)
+	return net.JoinHostPort(ip, port), nil
+}
+
+// lookupSRV resolves SRVName and returns the "host:port" of one of its
+// targets. The target is kept until a connection to it fails, see
+// endpointFailed, since the lookup shuffles the targets of equal priority on
+// every call.
+func (w *Watcher) lookupSRV() (string, error) {
+	if target, _ := w.srvTarget.Load().(string); target != "" {
+		return target, nil
+	}
+	startTime := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), defaultResolveTimeout)
+	defer cancel()
+	_, records, err := resolver.LookupSRV(ctx, "", "", w.options.SRVName)
+	if err == nil && len(records) == 0 {
+		err = &net.DNSError{Err: "no SRV records", Name: w.options.SRVName, IsNotFound: true}
+	}
+	if w.options.RecordMetrics != nil {
+		w.options.RecordMetrics(w.createMetrics(DNSResolveMetric, startTime, err))
+	}
+	if err != nil {
+		return "", err
+	}
+	record := records[int(atomic.LoadUint32(&w.srvFailures))%len(records)]
+	host := strings.TrimSuffix(record.Target, ".")
+	target := net.JoinHostPort(host, strconv.Itoa(int(record.Port)))
+	w.srvTarget.Store(target)
+	return target, nil
+}
@@ -0,0 +1,70 @@
+package rediswatcher
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestResolveDNS(t *testing.T) {
+	w := &Watcher{options: defaultWatcherOptions()}
+	w.options.Logger = &testLogger{}
+
+	if addr, _ := w.resolve("localhost:6379"); addr != "localhost:6379" {
+		t.Errorf("Address should not be resolved unless enabled, received '%s'", addr)
+	}
+
+	ResolveDNS(true)(&w.options)
+	if addr, err := w.resolve("10.0.0.1:6379"); err != nil || addr != "10.0.0.1:6379" {
+		t.Errorf("IP address should be returned unchanged, received '%s' (%v)", addr, err)
+	}
+	addr, err := w.resolve("localhost:6379")
+	if err != nil {
+		t.Skipf("localhost can't be resolved: %v", err)
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) == nil || port != "6379" {
+		t.Errorf("Expected a resolved IP address, received '%s'", addr)
+	}
+}
+
+func TestSRVNameFallback(t *testing.T) {
+	defer func(r *net.Resolver) { resolver = r }(resolver)
+	resolver = &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+		return nil, errors.New("no name server")
+	}}
+
+	var handled error
+	w := &Watcher{options: defaultWatcherOptions(), stats: &watcherStats{}}
+	w.options.Logger = &testLogger{}
+	SRVName("_redis._tcp.example.com")(&w.options)
+	WithErrorHandler(func(err error) { handled = err })(&w.options)
+
+	if addr := w.endpoint("10.0.0.1:6379"); addr != "10.0.0.1:6379" {
+		t.Errorf("Failed lookup should fall back to the address, received '%s'", addr)
+	}
+	if handled == nil {
+		t.Error("Failed lookup should be reported")
+	}
+}
+
+func TestSRVNameKeepsTarget(t *testing.T) {
+	defer func(r *net.Resolver) { resolver = r }(resolver)
+	resolver = &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+		return nil, errors.New("no name server")
+	}}
+
+	w := &Watcher{options: defaultWatcherOptions(), stats: &watcherStats{}}
+	w.options.Logger = &testLogger{}
+	SRVName("_redis._tcp.example.com")(&w.options)
+	w.srvTarget.Store("redis-1.example.com:6379")
+
+	if addr := w.endpoint("10.0.0.1:6379"); addr != "redis-1.example.com:6379" {
+		t.Errorf("The target should be kept without a new lookup, received '%s'", addr)
+	}
+	w.endpointFailed("redis-1.example.com:6379", errors.New("connection refused"))
+	if addr := w.endpoint("10.0.0.1:6379"); addr != "10.0.0.1:6379" {
+		t.Errorf("A failed target should be looked up again, received '%s'", addr)
+	}
+}
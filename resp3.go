@@ -0,0 +1,333 @@
+package rediswatcher
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+var errConnClosed = errors.New("rediswatcher: connection closed")
+
+// resp3Conn is a redis.Conn speaking RESP3, which redigo can't read, used for
+// the subscribe connection with RESP3. Push messages are returned by Receive
+// as the arrays of RESP2 pub/sub, so that redis.PubSubConn and
+// shardedPubSubConn read them unchanged. Maps are returned as flat arrays of
+// keys and values, like RESP2 returns them.
+type resp3Conn struct {
+	conn         net.Conn
+	writeTimeout time.Duration
+
+	// mu guards the writer and err, Send and Flush may be called while
+	// another goroutine receives
+	mu  sync.Mutex
+	bw  *bufio.Writer
+	err error
+
+	// readMu guards the reader and the push messages received by Do, which
+	// are returned by the following Receive calls
+	readMu sync.Mutex
+	br     *bufio.Reader
+	pushes []interface{}
+}
+
+// dialRESP3 establishes a connection for a resp3Conn with the Dialer, or
+// with the keep-alive period and ConnectTimeout otherwise
+func (w *Watcher) dialRESP3(network, addr string) (redis.Conn, error) {
+	var conn net.Conn
+	var err error
+	if w.options.Dialer != nil {
+		conn, err = w.options.Dialer(network, addr)
+	} else {
+		dialer := net.Dialer{Timeout: w.options.ConnectTimeout, KeepAlive: w.tcpKeepAlive()}
+		conn, err = dialer.Dial(network, addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newRESP3Conn(conn, w.options.WriteTimeout), nil
+}
+
+func newRESP3Conn(conn net.Conn, writeTimeout time.Duration) *resp3Conn {
+	return &resp3Conn{
+		conn:         conn,
+		writeTimeout: writeTimeout,
+		bw:           bufio.NewWriter(conn),
+		br:           bufio.NewReader(conn),
+	}
+}
+
+func (c *resp3Conn) Close() error {
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = errConnClosed
+	}
+	c.mu.Unlock()
+	return c.conn.Close()
+}
+
+func (c *resp3Conn) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// fatal closes the connection after an I/O or protocol error and returns err
+func (c *resp3Conn) fatal(err error) error {
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = err
+		c.conn.Close()
+	}
+	c.mu.Unlock()
+	return err
+}
+
+func (c *resp3Conn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	return c.DoWithTimeout(0, commandName, args...)
+}
+
+// DoWithTimeout sends a command and returns its reply. Push messages received
+// meanwhile are kept for Receive.
+func (c *resp3Conn) DoWithTimeout(timeout time.Duration, commandName string, args ...interface{}) (interface{}, error) {
+	if commandName != "" {
+		if err := c.Send(commandName, args...); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.Flush(); err != nil || commandName == "" {
+		return nil, err
+	}
+
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	for {
+		reply, push, err := c.read(timeout)
+		if err != nil {
+			return nil, err
+		}
+		if push {
+			c.pushes = append(c.pushes, reply)
+			continue
+		}
+		if err, ok := reply.(redis.Error); ok {
+			return nil, err
+		}
+		return reply, nil
+	}
+}
+
+func (c *resp3Conn) Send(commandName string, args ...interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	if c.writeTimeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+	c.writeLen('*', 1+len(args))
+	c.writeBulk([]byte(commandName))
+	for _, arg := range args {
+		c.writeBulk(argBytes(arg))
+	}
+	return nil
+}
+
+func (c *resp3Conn) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	if c.writeTimeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+	if err := c.bw.Flush(); err != nil {
+		c.err = err
+		c.conn.Close()
+		return err
+	}
+	return nil
+}
+
+func (c *resp3Conn) Receive() (interface{}, error) {
+	return c.ReceiveWithTimeout(0)
+}
+
+// ReceiveWithTimeout returns the next push message. Replies to the commands
+// sent on the subscribed connection, which RESP3 allows, can only answer the
+// PING of the keep-alive and are returned as the pong notification of RESP2.
+func (c *resp3Conn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	if len(c.pushes) > 0 {
+		reply := c.pushes[0]
+		c.pushes = c.pushes[1:]
+		return reply, nil
+	}
+
+	reply, push, err := c.read(timeout)
+	if err != nil || push {
+		return reply, err
+	}
+	switch reply := reply.(type) {
+	case redis.Error:
+		return nil, reply
+	case []byte:
+		return []interface{}{[]byte("pong"), reply}, nil
+	}
+	return []interface{}{[]byte("pong"), []byte{}}, nil
+}
+
+// read reads the next reply and reports whether it is a push message, a
+// timeout of 0 waits forever
+func (c *resp3Conn) read(timeout time.Duration) (interface{}, bool, error) {
+	if err := c.Err(); err != nil {
+		return nil, false, err
+	}
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	c.conn.SetReadDeadline(deadline)
+	reply, push, err := readRESP3(c.br)
+	if err != nil {
+		return nil, false, c.fatal(err)
+	}
+	return reply, push, nil
+}
+
+func (c *resp3Conn) writeLen(prefix byte, n int) {
+	c.bw.WriteByte(prefix)
+	c.bw.WriteString(strconv.Itoa(n))
+	c.bw.WriteString("\r\n")
+}
+
+func (c *resp3Conn) writeBulk(b []byte) {
+	c.writeLen('$', len(b))
+	c.bw.Write(b)
+	c.bw.WriteString("\r\n")
+}
+
+// argBytes formats a command argument the way redigo does
+func argBytes(arg interface{}) []byte {
+	switch arg := arg.(type) {
+	case string:
+		return []byte(arg)
+	case []byte:
+		return arg
+	case int:
+		return strconv.AppendInt(nil, int64(arg), 10)
+	case int64:
+		return strconv.AppendInt(nil, arg, 10)
+	case float64:
+		return strconv.AppendFloat(nil, arg, 'g', -1, 64)
+	case bool:
+		if arg {
+			return []byte("1")
+		}
+		return []byte("0")
+	case nil:
+		return []byte{}
+	case redis.Argument:
+		return argBytes(arg.RedisArg())
+	}
+	return []byte(fmt.Sprint(arg))
+}
+
+type resp3Error string
+
+func (e resp3Error) Error() string {
+	return "rediswatcher: bad RESP3 reply: " + string(e)
+}
+
+// readRESP3 reads a reply in the types returned by redigo: simple strings
+// as string, integers and booleans as int64, blob strings, verbatim strings,
+// doubles and big numbers as []byte, errors as redis.Error and aggregates as
+// []interface{}. Attributes are skipped. It reports whether the reply is a
+// push message.
+func readRESP3(br *bufio.Reader) (interface{}, bool, error) {
+	line, err := br.ReadSlice('\n')
+	if err != nil {
+		return nil, false, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, false, resp3Error("bad line ending")
+	}
+	kind, line := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return string(line), false, nil
+	case '-':
+		return redis.Error(line), false, nil
+	case ':':
+		n, err := strconv.ParseInt(string(line), 10, 64)
+		if err != nil {
+			return nil, false, resp3Error("bad integer")
+		}
+		return n, false, nil
+	case '_':
+		return nil, false, nil
+	case '#':
+		if string(line) == "t" {
+			return int64(1), false, nil
+		}
+		return int64(0), false, nil
+	case ',', '(':
+		return []byte(string(line)), false, nil
+	case '$', '=', '!':
+		n, err := strconv.Atoi(string(line))
+		if err != nil || n < -1 {
+			return nil, false, resp3Error("bad length")
+		}
+		if n == -1 {
+			return nil, false, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return nil, false, err
+		}
+		data = data[:n]
+		switch kind {
+		case '=':
+			// verbatim strings start with their format, such as "txt:"
+			if len(data) >= 4 && data[3] == ':' {
+				data = data[4:]
+			}
+		case '!':
+			return redis.Error(data), false, nil
+		}
+		return data, false, nil
+	case '*', '~', '>', '%', '|':
+		n, err := strconv.Atoi(string(line))
+		if err != nil || n < -1 {
+			return nil, false, resp3Error("bad length")
+		}
+		if n == -1 {
+			return nil, false, nil
+		}
+		if kind == '%' || kind == '|' {
+			n *= 2
+		}
+		values := make([]interface{}, n)
+		for i := range values {
+			if values[i], _, err = readRESP3(br); err != nil {
+				return nil, false, err
+			}
+		}
+		if kind == '|' {
+			// attributes describe the following reply
+			return readRESP3(br)
+		}
+		return values, kind == '>', nil
+	}
+	return nil, false, resp3Error("unknown type " + strconv.Quote(string(kind)))
+}
@@ -0,0 +1,98 @@
+package rediswatcher
+
+import (
+	"bufio"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+func TestReadRESP3(t *testing.T) {
+	tests := []struct {
+		data  string
+		reply interface{}
+		push  bool
+	}{
+		{"+OK\r\n", "OK", false},
+		{"-ERR unknown\r\n", redis.Error("ERR unknown"), false},
+		{":42\r\n", int64(42), false},
+		{"_\r\n", nil, false},
+		{"#t\r\n", int64(1), false},
+		{",3.14\r\n", []byte("3.14"), false},
+		{"$5\r\nhello\r\n", []byte("hello"), false},
+		{"=9\r\ntxt:hello\r\n", []byte("hello"), false},
+		{"%1\r\n+proto\r\n:3\r\n", []interface{}{"proto", int64(3)}, false},
+		{"|1\r\n+ttl\r\n:10\r\n~1\r\n:1\r\n", []interface{}{int64(1)}, false},
+		{">3\r\n$7\r\nmessage\r\n$7\r\n/casbin\r\n$5\r\nnode2\r\n", []interface{}{[]byte("message"), []byte("/casbin"), []byte("node2")}, true},
+	}
+	for _, test := range tests {
+		reply, push, err := readRESP3(bufio.NewReader(strings.NewReader(test.data)))
+		if err != nil {
+			t.Errorf("Failed to read %q: %v", test.data, err)
+			continue
+		}
+		if !reflect.DeepEqual(reply, test.reply) || push != test.push {
+			t.Errorf("Expected %#v (push %v) for %q, received %#v (push %v)", test.reply, test.push, test.data, reply, push)
+		}
+	}
+}
+
+func TestRESP3Subscribe(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("Failed to listen: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		br := bufio.NewReader(c)
+		for {
+			cmd, _, err := readRESP3(br)
+			if err != nil {
+				return
+			}
+			args := cmd.([]interface{})
+			switch strings.ToUpper(string(args[0].([]byte))) {
+			case "HELLO":
+				c.Write([]byte("%1\r\n$5\r\nproto\r\n:3\r\n"))
+			case "SUBSCRIBE":
+				c.Write([]byte(">3\r\n$9\r\nsubscribe\r\n$7\r\n/casbin\r\n:1\r\n"))
+				c.Write([]byte(">3\r\n$7\r\nmessage\r\n$7\r\n/casbin\r\n$5\r\nnode2\r\n"))
+			case "PING":
+				c.Write([]byte("$4\r\nping\r\n"))
+			}
+		}
+	}()
+
+	w := &Watcher{options: defaultWatcherOptions(), stats: &watcherStats{}}
+	RESP3(true)(&w.options)
+	c, err := w.dialConn(l.Addr().String(), true)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer (*c).Close()
+
+	psc := redis.PubSubConn{Conn: *c}
+	if err := psc.Subscribe("/casbin"); err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+	if s, ok := psc.Receive().(redis.Subscription); !ok || s.Channel != "/casbin" || s.Count != 1 {
+		t.Errorf("Expected the subscription to /casbin, received %#v", s)
+	}
+	if m, ok := psc.Receive().(redis.Message); !ok || string(m.Data) != "node2" {
+		t.Errorf("Expected the update of node2, received %#v", m)
+	}
+	if err := psc.Ping("ping"); err != nil {
+		t.Fatalf("Failed to ping: %v", err)
+	}
+	if p, ok := psc.Receive().(redis.Pong); !ok || p.Data != "ping" {
+		t.Errorf("The reply to PING should be received as pong, received %#v", p)
+	}
+}
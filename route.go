@@ -0,0 +1,70 @@
+package rediswatcher
+
+import (
+	"context"
+
+	"github.com/casbin/casbin/v2/persist"
+)
+
+// RoutedWatcher shares the connections and subscription of a Watcher between
+// several enforcers. Updates published through it are tagged with its route
+// and only invoke the update callback of the RoutedWatcher with the same
+// route, on any watcher subscribed to the channel.
+type RoutedWatcher struct {
+	w     *Watcher
+	route string
+}
+
+// Route returns a persist.Watcher for the enforcer identified by route, so
+// that one Watcher serves several enforcers instead of each enforcer needing
+// its own watcher and connections
+//
+//	w, _ := rediswatcher.NewWatcher("127.0.0.1:6379")
+//	e1.SetWatcher(w.(*rediswatcher.Watcher).Route("app1"))
+//	e2.SetWatcher(w.(*rediswatcher.Watcher).Route("app2"))
+func (w *Watcher) Route(route string) persist.Watcher {
+	return &RoutedWatcher{w: w, route: route}
+}
+
+// SetUpdateCallback sets the callback invoked for updates on the route
+func (r *RoutedWatcher) SetUpdateCallback(callback func(string)) error {
+	r.w.callbacksMu.Lock()
+	defer r.w.callbacksMu.Unlock()
+	if r.w.routeCallbacks == nil {
+		r.w.routeCallbacks = make(map[string]func(string))
+	}
+	r.w.routeCallbacks[r.route] = callback
+	return nil
+}
+
+// Update publishes an update for the enforcers on the route
+func (r *RoutedWatcher) Update() error {
+	version, err := r.w.incrVersion()
+	if err != nil {
+		return err
+	}
+	return r.w.publishMessage(context.Background(), &UpdateMessage{
+		Type:    UpdateMessageType,
+		Route:   r.route,
+		Version: version,
+		Payload: r.w.options.LocalID,
+	})
+}
+
+// Close removes the route's callback, the shared Watcher stays connected
+// until it is closed itself
+func (r *RoutedWatcher) Close() {
+	r.w.callbacksMu.Lock()
+	defer r.w.callbacksMu.Unlock()
+	delete(r.w.routeCallbacks, r.route)
+}
+
+// routeCallback returns the callback registered for route, if any
+func (w *Watcher) routeCallback(route string) func(string) {
+	if route == "" {
+		return nil
+	}
+	w.callbacksMu.Lock()
+	defer w.callbacksMu.Unlock()
+	return w.routeCallbacks[route]
+}
@@ -0,0 +1,38 @@
+package rediswatcher
+
+import (
+	"testing"
+
+	"github.com/casbin/casbin/v2/persist"
+)
+
+func TestRoute(t *testing.T) {
+	c := &publishConn{testConn: NewTestConn()}
+	c.Clear()
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	var app1, app2 persist.Watcher = rw.Route("app1"), rw.Route("app2")
+	var updated []string
+	app1.SetUpdateCallback(func(string) { updated = append(updated, "app1") })
+	app2.SetUpdateCallback(func(string) { updated = append(updated, "app2") })
+	w.SetUpdateCallback(func(string) { updated = append(updated, "default") })
+
+	if err := app2.Update(); err != nil {
+		t.Fatalf("Failed to publish routed update: %v", err)
+	}
+	rw.processMessage(decodeMessage("/casbin", []byte(c.published[0])))
+	rw.processMessage(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Route: "app3"})
+	if len(updated) != 1 || updated[0] != "app2" {
+		t.Errorf("Routed update should only reach its route's callback, received %v", updated)
+	}
+
+	app2.Close()
+	rw.processMessage(decodeMessage("/casbin", []byte(c.published[0])))
+	if len(updated) != 1 {
+		t.Errorf("Closed route should not receive updates, received %v", updated)
+	}
+}
@@ -0,0 +1,29 @@
+package rediswatcher
+
+// initSenders builds the sets of AllowSenders and DenySenders
+func (w *Watcher) initSenders() {
+	if len(w.options.AllowedSenders) > 0 {
+		w.allowedSenders = make(map[string]bool, len(w.options.AllowedSenders))
+		for _, id := range w.options.AllowedSenders {
+			w.allowedSenders[id] = true
+		}
+	}
+	if len(w.options.DeniedSenders) > 0 {
+		w.deniedSenders = make(map[string]bool, len(w.options.DeniedSenders))
+		for _, id := range w.options.DeniedSenders {
+			w.deniedSenders[id] = true
+		}
+	}
+}
+
+// senderAllowed reports whether messages from the watcher localID are
+// processed. The watcher's own messages are always allowed.
+func (w *Watcher) senderAllowed(localID string) bool {
+	if localID == w.options.LocalID {
+		return true
+	}
+	if w.deniedSenders[localID] {
+		return false
+	}
+	return w.allowedSenders == nil || w.allowedSenders[localID]
+}
@@ -0,0 +1,111 @@
+package rediswatcher
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+var errUnknownShardNotification = errors.New("rediswatcher: unknown sharded pubsub notification")
+
+// pubSubConn is a subscribed connection, either classic or sharded pub/sub
+type pubSubConn interface {
+	Subscribe(channel ...interface{}) error
+	Unsubscribe(channel ...interface{}) error
+	Ping(data string) error
+	Receive() interface{}
+	ReceiveWithTimeout(timeout time.Duration) interface{}
+}
+
+// shardedPubSubConn subscribes with SSUBSCRIBE and translates the sharded
+// notifications into the redis.Message and redis.Subscription values returned
+// by redis.PubSubConn, which doesn't know them
+type shardedPubSubConn struct {
+	conn redis.Conn
+}
+
+func (c shardedPubSubConn) Subscribe(channel ...interface{}) error {
+	c.conn.Send("SSUBSCRIBE", channel...)
+	return c.conn.Flush()
+}
+
+func (c shardedPubSubConn) Unsubscribe(channel ...interface{}) error {
+	c.conn.Send("SUNSUBSCRIBE", channel...)
+	return c.conn.Flush()
+}
+
+func (c shardedPubSubConn) Ping(data string) error {
+	c.conn.Send("PING", data)
+	return c.conn.Flush()
+}
+
+func (c shardedPubSubConn) Receive() interface{} {
+	return c.notification(c.conn.Receive())
+}
+
+func (c shardedPubSubConn) ReceiveWithTimeout(timeout time.Duration) interface{} {
+	return c.notification(redis.ReceiveWithTimeout(c.conn, timeout))
+}
+
+// notification translates a reply received on the connection
+func (c shardedPubSubConn) notification(r interface{}, err error) interface{} {
+	reply, err := redis.Values(r, err)
+	if err != nil {
+		return err
+	}
+
+	var kind string
+	if reply, err = redis.Scan(reply, &kind); err != nil {
+		return err
+	}
+
+	switch kind {
+	case "smessage":
+		var m redis.Message
+		if _, err := redis.Scan(reply, &m.Channel, &m.Data); err != nil {
+			return err
+		}
+		return m
+	case "ssubscribe", "sunsubscribe":
+		s := redis.Subscription{Kind: strings.TrimPrefix(kind, "s")}
+		if _, err := redis.Scan(reply, &s.Channel, &s.Count); err != nil {
+			return err
+		}
+		return s
+	case "pong":
+		var p redis.Pong
+		if _, err := redis.Scan(reply, &p.Data); err != nil {
+			return err
+		}
+		return p
+	}
+	return errUnknownShardNotification
+}
+
+// sharded reports whether updates go through sharded pub/sub
+func (w *Watcher) sharded() bool {
+	return atomic.LoadInt32(&w.shardedPubSub) == 1
+}
+
+// pubSub returns the sub connection as a classic or sharded pubSubConn
+func (w *Watcher) pubSub() pubSubConn {
+	if w.sharded() {
+		return shardedPubSubConn{conn: w.subConn}
+	}
+	return redis.PubSubConn{Conn: w.subConn}
+}
+
+// shardFallback switches to classic pub/sub if err shows that the server
+// doesn't support sharded pub/sub, which was added in redis 7
+func (w *Watcher) shardFallback(err error) bool {
+	if _, ok := err.(redis.Error); !ok || !strings.HasPrefix(strings.ToLower(err.Error()), "err unknown command") {
+		return false
+	}
+	if atomic.CompareAndSwapInt32(&w.shardedPubSub, 1, 0) {
+		w.options.Logger.Warn("Sharded pub/sub not supported, falling back to pub/sub", "channel", w.options.Channel, "localID", w.options.LocalID, "error", err)
+	}
+	return true
+}
@@ -0,0 +1,71 @@
+package rediswatcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/rafaeljusto/redigomock"
+)
+
+func TestShardedPubSub(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	c.ReceiveWait = true
+	c.Command("SSUBSCRIBE", "/casbin").Expect([]interface{}{[]byte("ssubscribe"), []byte("/casbin"), []byte("1")})
+	c.Command("SUNSUBSCRIBE").Expect([]interface{}{[]byte("sunsubscribe"), []byte("/casbin"), []byte("0")})
+	c.Command("SPUBLISH", "/casbin", redigomock.NewAnyData()).Expect("1")
+	c.AddSubscriptionMessage([]interface{}{[]byte("smessage"), []byte("/casbin"), []byte("node2")})
+
+	w, err := NewWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), ShardedPubSub(true))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	defer w.Close()
+
+	ch := make(chan string, 1)
+	w.SetUpdateCallback(func(msg string) { ch <- msg })
+	go func() {
+		// the SSUBSCRIBE confirmation, then the message
+		c.ReceiveNow <- true
+		c.ReceiveNow <- true
+	}()
+
+	select {
+	case msg := <-ch:
+		if msg != "node2" {
+			t.Errorf("Callback should receive 'node2', received '%s' instead", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Sharded message was not delivered")
+	}
+
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+	if c.Stats(c.Command("SPUBLISH", "/casbin", redigomock.NewAnyData())) != 1 {
+		t.Error("Update should publish with SPUBLISH")
+	}
+}
+
+func TestShardedPubSubFallback(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), ShardedPubSub(true))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	c.Command("SPUBLISH", "/casbin", rw.options.LocalID).ExpectError(redis.Error("ERR unknown command 'SPUBLISH'"))
+	c.Command("PUBLISH", "/casbin", rw.options.LocalID).Expect("1")
+	if err := w.Update(); err != nil {
+		t.Fatalf("Update should fall back to PUBLISH: %v", err)
+	}
+	if rw.sharded() {
+		t.Error("Watcher should stop using sharded pub/sub")
+	}
+	if _, ok := rw.pubSub().(redis.PubSubConn); !ok {
+		t.Error("Subscriptions should fall back to classic pub/sub")
+	}
+}
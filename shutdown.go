@@ -0,0 +1,87 @@
+package rediswatcher
+
+import (
+	"context"
+	"time"
+)
+
+// Shutdown closes the watcher like Close, but first processes the messages
+// already queued and invokes the callbacks of the updates held back by
+// squashing. It returns the first error closing the Redis connections. If ctx
+// is done before the queue was drained the watcher is closed anyway and the
+// context's error is returned.
+func (w *Watcher) Shutdown(ctx context.Context) error {
+	select {
+	case <-w.closed:
+		return w.close()
+	default:
+	}
+	if w.flushes == nil {
+		// publish only watchers have no message processor
+		w.flushSquashed()
+		return w.close()
+	}
+
+	done := make(chan struct{})
+	select {
+	case w.flushes <- done:
+	case <-ctx.Done():
+		w.close()
+		return ctx.Err()
+	}
+	select {
+	case <-done:
+	case <-ctx.Done():
+		w.close()
+		return ctx.Err()
+	}
+	return w.close()
+}
+
+// receiveMessage hands a message taken off the queue to the message
+// processor, through the reorder buffer with OrderedDelivery
+func (w *Watcher) receiveMessage(msg *UpdateMessage) {
+	if w.ordering == nil {
+		w.processMessage(msg)
+		return
+	}
+	ready, duplicate := w.ordering.add(w.sequenceKey(msg), msg, time.Now())
+	if duplicate {
+		w.ackStreams(msg.streamIDs)
+		if w.options.RecordMetrics != nil {
+			w.options.RecordMetrics(w.createMetrics(SequenceDuplicateMetric, time.Now(), nil))
+		}
+	}
+	for _, msg := range ready {
+		w.processMessage(msg)
+	}
+}
+
+// flushQueue processes the queued messages, the messages held by the reorder
+// buffer and then the squashed updates
+func (w *Watcher) flushQueue() {
+	for {
+		select {
+		case msg := <-w.messagesIn:
+			w.receiveMessage(msg)
+			continue
+		default:
+		}
+		break
+	}
+	if w.ordering != nil {
+		// give up waiting for the missing messages, one gap at a time
+		now := time.Now()
+		for {
+			now = now.Add(w.options.ReorderTimeout)
+			ready, _ := w.ordering.expire(now)
+			if len(ready) == 0 {
+				break
+			}
+			for _, msg := range ready {
+				w.processMessage(msg)
+			}
+		}
+	}
+	w.flushSquashed()
+}
@@ -0,0 +1,74 @@
+package rediswatcher
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestShutdown(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	c.ReceiveWait = true
+	c.Command("SUBSCRIBE", "/casbin").Expect([]interface{}{[]byte("subscribe"), []byte("/casbin"), []byte("1")})
+
+	w, err := NewWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c),
+		SquashMessages(true), SquashTimeoutShort(time.Minute), QueueSize(8))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	received := make(chan string, 8)
+	w.SetUpdateCallback(func(msg string) { received <- msg })
+	rw.messagesIn <- decodeMessage("/casbin", []byte("first"))
+	rw.messagesIn <- decodeMessage("/casbin", []byte("second"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := rw.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	select {
+	case msg := <-received:
+		if msg != "second" {
+			t.Errorf("Squashed callback should receive the last message, received '%s'", msg)
+		}
+	default:
+		t.Fatal("Shutdown should invoke the callback of squashed messages")
+	}
+	if len(received) != 0 {
+		t.Errorf("Squashed callback should be invoked once")
+	}
+	select {
+	case <-rw.closed:
+	default:
+		t.Error("Shutdown should close the watcher")
+	}
+	if err := rw.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown of a closed watcher should report the close error, got %v", err)
+	}
+}
+
+func TestShutdownContext(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+	// a message processor that never answers
+	rw.flushes = make(chan chan struct{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := rw.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	select {
+	case <-rw.closed:
+	default:
+		t.Error("Shutdown should close the watcher when the context is done")
+	}
+}
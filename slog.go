@@ -0,0 +1,37 @@
+//go:build go1.21
+// +build go1.21
+
+package rediswatcher
+
+import "log/slog"
+
+// slogLogger routes the watcher's log messages to a *slog.Logger, passing
+// the key/value pairs along as structured attributes
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+func (l slogLogger) Debug(msg string, keyvals ...interface{}) {
+	l.logger.Debug(msg, keyvals...)
+}
+
+func (l slogLogger) Info(msg string, keyvals ...interface{}) {
+	l.logger.Info(msg, keyvals...)
+}
+
+func (l slogLogger) Warn(msg string, keyvals ...interface{}) {
+	l.logger.Warn(msg, keyvals...)
+}
+
+func (l slogLogger) Error(msg string, keyvals ...interface{}) {
+	l.logger.Error(msg, keyvals...)
+}
+
+// WithSlog routes the watcher's internal log messages to logger with
+// structured attributes such as channel, localID, attempt and error
+func WithSlog(logger *slog.Logger) WatcherOption {
+	if logger == nil {
+		return WithLogger(nil)
+	}
+	return WithLogger(slogLogger{logger: logger})
+}
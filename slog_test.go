@@ -0,0 +1,33 @@
+//go:build go1.21
+// +build go1.21
+
+package rediswatcher
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestWithSlog(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		MaxReconnectAttempts(1), WithSlog(logger))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	rw.reconnectFailed(errors.New("connection refused"))
+	for _, attr := range []string{"level=ERROR", "channel=/casbin", "localID=node1", "attempt=1", "connection refused"} {
+		if !strings.Contains(buf.String(), attr) {
+			t.Errorf("Log output should contain '%s', received '%s'", attr, buf.String())
+		}
+	}
+}
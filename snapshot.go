@@ -0,0 +1,240 @@
+package rediswatcher
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultSnapshotKeyPrefix = "casbin:snapshot:"
+	defaultSnapshotTTL       = 5 * time.Minute
+	defaultSnapshotTimeout   = 30 * time.Second
+)
+
+var (
+	errNoSnapshotLoader = errors.New("rediswatcher: no SnapshotLoader configured")
+	errSnapshotExpired  = errors.New("rediswatcher: policy snapshot expired")
+	errSnapshotPanicked = errors.New("rediswatcher: snapshot hook panicked")
+)
+
+// snapshotState tracks the last snapshot written by a provider so that a
+// burst of requests during a mass catch-up is served from a single write
+type snapshotState struct {
+	key       string
+	version   uint64
+	createdAt time.Time
+}
+
+// RequestSnapshot asks the designated snapshot provider to write a compressed
+// policy snapshot to redis. Once the provider publishes its location the
+// snapshot is fetched and handed to the SnapshotLoader. If no snapshot is
+// loaded within the SnapshotTimeout, the update callback is invoked to
+// reload the whole policy instead.
+//
+// RequestSnapshot is also invoked automatically when SnapshotGapThreshold is
+// set and a sender's sequence numbers show that this watcher fell behind.
+func (w *Watcher) RequestSnapshot() error {
+	if w.options.SnapshotLoader == nil {
+		return errNoSnapshotLoader
+	}
+	atomic.StoreInt32(&w.snapshotPending, 1)
+	w.awaitSnapshot()
+	if err := w.publishMessage(context.Background(), &UpdateMessage{Type: SnapshotRequestMessageType}); err != nil {
+		atomic.StoreInt32(&w.snapshotPending, 0)
+		return err
+	}
+	return nil
+}
+
+// trackSequence records the sequence number of an update from another
+// watcher and requests a snapshot if too many updates were missed. Updates
+// older than the last one tracked are ignored, unless the sender restarted.
+func (w *Watcher) trackSequence(msg *UpdateMessage) {
+	if msg.Seq == 0 || msg.LocalID == w.options.LocalID {
+		return
+	}
+	key := w.sequenceKey(msg)
+	last, seen := w.lastSeq[key]
+	if seen && msg.Seq <= last && msg.Seq != 1 {
+		return
+	}
+	w.lastSeq[key] = msg.Seq
+	if !seen || w.options.SnapshotGapThreshold == 0 || msg.Seq <= last {
+		return
+	}
+	if msg.Seq-last-1 < w.options.SnapshotGapThreshold {
+		return
+	}
+	if w.options.SnapshotLoader == nil || !atomic.CompareAndSwapInt32(&w.snapshotPending, 0, 1) {
+		return
+	}
+	w.options.Logger.Info("Requesting policy snapshot", "channel", w.options.Channel, "localID", w.options.LocalID, "sender", msg.LocalID, "missed", msg.Seq-last-1)
+	w.awaitSnapshot()
+	w.background(func() {
+		if err := w.publishMessage(context.Background(), &UpdateMessage{Type: SnapshotRequestMessageType}); err != nil {
+			atomic.StoreInt32(&w.snapshotPending, 0)
+			w.options.Logger.Error("Failure requesting policy snapshot", "channel", w.options.Channel, "localID", w.options.LocalID, "error", err)
+			w.handleError(err)
+		}
+	})
+}
+
+// awaitSnapshot starts the SnapshotTimeout for the pending snapshot request.
+// If no snapshot was loaded by then the request is abandoned and the whole
+// policy is reloaded instead.
+func (w *Watcher) awaitSnapshot() {
+	request := atomic.AddUint64(&w.snapshotRequests, 1)
+	if w.options.SnapshotTimeout <= 0 {
+		return
+	}
+	w.background(func() {
+		timer := time.NewTimer(w.options.SnapshotTimeout)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-w.closed:
+			return
+		}
+		if atomic.LoadUint64(&w.snapshotRequests) != request || !atomic.CompareAndSwapInt32(&w.snapshotPending, 1, 0) {
+			return
+		}
+		w.options.Logger.Warn("Policy snapshot timed out, reloading", "channel", w.options.Channel, "localID", w.options.LocalID, "timeout", w.options.SnapshotTimeout)
+		w.requestReload(w.options.LocalID)
+	})
+}
+
+// handleControlMessage processes snapshot requests and responses, message
+// chunks, acknowledgements, commands and version broadcasts
+func (w *Watcher) handleControlMessage(msg *UpdateMessage) {
+	switch msg.Type {
+	case ChunkMessageType:
+		w.addChunk(msg)
+	case AckMessageType:
+		w.handleAck(msg)
+	case CommandMessageType:
+		w.handleCommand(msg)
+	case VersionMessageType:
+		w.handleVersion(msg)
+	case SnapshotRequestMessageType:
+		if w.options.SnapshotProvider != nil && msg.LocalID != w.options.LocalID {
+			w.background(func() {
+				if err := w.respondSnapshot(msg.LocalID); err != nil {
+					w.options.Logger.Error("Failure providing policy snapshot", "channel", w.options.Channel, "localID", w.options.LocalID, "requester", msg.LocalID, "error", err)
+					w.handleError(err)
+				}
+			})
+		}
+	case SnapshotMessageType:
+		if msg.Target == w.options.LocalID && w.options.SnapshotLoader != nil {
+			if err := w.loadSnapshot(msg.Payload); err != nil {
+				w.options.Logger.Error("Failure loading policy snapshot", "channel", w.options.Channel, "localID", w.options.LocalID, "key", msg.Payload, "error", err)
+				w.handleError(err)
+			}
+		}
+	}
+}
+
+// respondSnapshot writes a compressed snapshot to redis, reusing a recent one
+// if no update has been seen since, and publishes its key to the requester
+func (w *Watcher) respondSnapshot(target string) error {
+	w.snapshotMu.Lock()
+	defer w.snapshotMu.Unlock()
+
+	version := atomic.LoadUint64(&w.policyVersion)
+	s := w.snapshot
+	if s.key == "" || s.version != version || time.Since(s.createdAt) > w.options.SnapshotTTL/2 {
+		startTime := time.Now()
+		key, err := w.writeSnapshot(version)
+		if w.options.RecordMetrics != nil {
+			w.options.RecordMetrics(w.createMetrics(SnapshotWriteMetric, startTime, err))
+		}
+		if err != nil {
+			return err
+		}
+		w.snapshot = snapshotState{key: key, version: version, createdAt: startTime}
+	}
+
+	return w.publishMessage(context.Background(), &UpdateMessage{
+		Type:    SnapshotMessageType,
+		Target:  target,
+		Payload: w.snapshot.key,
+	})
+}
+
+func (w *Watcher) writeSnapshot(version uint64) (string, error) {
+	var data []byte
+	var err error
+	if !w.safeHook("Snapshot provider", func() { data, err = w.options.SnapshotProvider() }) {
+		return "", errSnapshotPanicked
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+
+	key := fmt.Sprintf("%s%s:%d", w.options.SnapshotKeyPrefix, w.options.LocalID, version)
+	sealed, err := w.seal(buf.String())
+	if err != nil {
+		return "", err
+	}
+	if err := w.storage.Set(key, []byte(sealed), w.options.SnapshotTTL); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// loadSnapshot fetches and decompresses the snapshot stored at key and hands
+// it to the SnapshotLoader
+func (w *Watcher) loadSnapshot(key string) error {
+	startTime := time.Now()
+	err := w.fetchSnapshot(key)
+	if w.options.RecordMetrics != nil {
+		w.options.RecordMetrics(w.createMetrics(SnapshotLoadMetric, startTime, err))
+	}
+	atomic.StoreInt32(&w.snapshotPending, 0)
+	return err
+}
+
+func (w *Watcher) fetchSnapshot(key string) error {
+	compressed, err := w.storage.Get(key)
+	if err != nil {
+		return err
+	}
+	if compressed == nil {
+		return errSnapshotExpired
+	}
+	if w.options.KeyProvider != nil {
+		if compressed, err = w.decrypt(w.decode(w.options.Channel, compressed)); err != nil {
+			return err
+		}
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return err
+	}
+	if !w.safeHook("Snapshot loader", func() { err = w.options.SnapshotLoader(data) }) {
+		return errSnapshotPanicked
+	}
+	return err
+}
@@ -0,0 +1,183 @@
+package rediswatcher
+
+import (
+	"bytes"
+	"compress/gzip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rafaeljusto/redigomock"
+)
+
+func TestSnapshotProvider(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	provided := 0
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("provider"),
+		SnapshotProvider(func() ([]byte, error) {
+			provided++
+			return []byte("p, alice, data1, read"), nil
+		}))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	set := c.Command("SET", redigomock.NewAnyData(), redigomock.NewAnyData(), "PX", redigomock.NewAnyInt()).Expect("OK")
+	publish := c.Command("PUBLISH", "/casbin", redigomock.NewAnyData()).Expect("1")
+
+	if err := rw.respondSnapshot("node1"); err != nil {
+		t.Fatalf("Failed to respond with snapshot: %v", err)
+	}
+	if err := rw.respondSnapshot("node2"); err != nil {
+		t.Fatalf("Failed to respond with snapshot: %v", err)
+	}
+
+	if provided != 1 || c.Stats(set) != 1 {
+		t.Errorf("Snapshot should be written once, provider called %d times and SET %d times", provided, c.Stats(set))
+	}
+	if c.Stats(publish) != 2 {
+		t.Errorf("Snapshot location should be published twice, published %d times", c.Stats(publish))
+	}
+}
+
+func TestSnapshotLoader(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	var loaded []byte
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		SnapshotLoader(func(data []byte) error {
+			loaded = data
+			return nil
+		}))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte("p, alice, data1, read"))
+	zw.Close()
+	c.Command("GET", "casbin:snapshot:provider:1").Expect(buf.Bytes())
+
+	msg := &UpdateMessage{Type: SnapshotMessageType, LocalID: "provider", Target: "node2", Payload: "casbin:snapshot:provider:1"}
+	rw.handleControlMessage(msg)
+	if loaded != nil {
+		t.Fatal("Snapshot for another watcher should not be loaded")
+	}
+
+	msg.Target = "node1"
+	rw.handleControlMessage(msg)
+	if string(loaded) != "p, alice, data1, read" {
+		t.Errorf("Snapshot should be 'p, alice, data1, read', received '%s' instead", loaded)
+	}
+}
+
+func TestSnapshotGapThreshold(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		SnapshotGapThreshold(5), SnapshotLoader(func([]byte) error { return nil }))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+	c.Command("PUBLISH", "/casbin", redigomock.NewAnyData()).Expect("1")
+
+	rw.trackSequence(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Seq: 1})
+	rw.trackSequence(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Seq: 4})
+	// a late update doesn't rewind the sequence
+	rw.trackSequence(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Seq: 2})
+	rw.trackSequence(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Seq: 5})
+	if atomic.LoadInt32(&rw.snapshotPending) != 0 {
+		t.Fatal("Small gap should not request a snapshot")
+	}
+
+	rw.trackSequence(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Seq: 11})
+	if atomic.LoadInt32(&rw.snapshotPending) != 1 {
+		t.Fatal("Large gap should request a snapshot")
+	}
+}
+
+func TestSnapshotGapUntrustedSender(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		SnapshotGapThreshold(5), SnapshotLoader(func([]byte) error { return nil }), DenySenders("node3"))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+	w.SetUpdateCallback(func(string) {})
+
+	rw.processMessage(&UpdateMessage{Type: UpdateMessageType, LocalID: "node3", Seq: 1, Payload: "node3"})
+	rw.processMessage(&UpdateMessage{Type: UpdateMessageType, LocalID: "node3", Seq: 100, Payload: "node3"})
+	if atomic.LoadInt32(&rw.snapshotPending) != 0 || len(rw.lastSeq) != 0 {
+		t.Error("Messages of a denied sender should not be tracked")
+	}
+}
+
+func TestSnapshotTimeout(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		SnapshotLoader(func([]byte) error { return nil }), SnapshotTimeout(20*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	defer w.Close()
+	rw := w.(*Watcher)
+	c.Command("PUBLISH", "/casbin", redigomock.NewAnyData()).Expect("1")
+
+	ch := make(chan string, 1)
+	w.SetUpdateCallback(func(msg string) { ch <- msg })
+	if err := rw.RequestSnapshot(); err != nil {
+		t.Fatalf("RequestSnapshot failed: %v", err)
+	}
+	select {
+	case msg := <-ch:
+		if msg != "node1" {
+			t.Errorf("Snapshot timeout should reload with the local ID, received '%s'", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Snapshot timeout should reload the policy")
+	}
+	if atomic.LoadInt32(&rw.snapshotPending) != 0 {
+		t.Error("Snapshot timeout should reset the pending request")
+	}
+}
+
+func TestSnapshotLoaderPanic(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	var errs []error
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		SnapshotLoader(func([]byte) error { panic("boom") }), WithErrorHandler(func(err error) { errs = append(errs, err) }))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte("p, alice, data1, read"))
+	zw.Close()
+	c.Command("GET", "casbin:snapshot:provider:1").Expect(buf.Bytes())
+
+	atomic.StoreInt32(&rw.snapshotPending, 1)
+	rw.handleControlMessage(&UpdateMessage{Type: SnapshotMessageType, LocalID: "provider", Target: "node1", Payload: "casbin:snapshot:provider:1"})
+	if len(errs) == 0 {
+		t.Error("Snapshot loader panic should be reported")
+	}
+	if atomic.LoadInt32(&rw.snapshotPending) != 0 {
+		t.Error("Snapshot loader panic should reset the pending request")
+	}
+}
@@ -0,0 +1,128 @@
+package rediswatcher
+
+import (
+	"sync"
+	"time"
+)
+
+// squashState holds the updates held back by squashing until the squash
+// timeout. It is shared by the message processor, the options helpers and
+// Shutdown, so all access goes through its mutex.
+type squashState struct {
+	mu      sync.Mutex
+	pending bool
+	start   time.Time
+	count   int
+	batch   squashBatch
+}
+
+// squashBatch is the last update held back for each callback
+type squashBatch struct {
+	routes   map[string]string
+	channels map[string]string
+	senders  map[string]string
+	data     string
+	hasData  bool
+
+	// streamIDs are the stream entries of the held updates
+	streamIDs []string
+}
+
+// squashTarget selects the callback a squashed update is held for
+type squashTarget int
+
+const (
+	squashDefault squashTarget = iota
+	squashRoute
+	squashChannel
+	squashSender
+)
+
+func newSquashState() *squashState {
+	return &squashState{batch: newSquashBatch()}
+}
+
+func newSquashBatch() squashBatch {
+	return squashBatch{
+		routes:   make(map[string]string),
+		channels: make(map[string]string),
+		senders:  make(map[string]string),
+	}
+}
+
+// add holds data for the callback selected by target and key, replacing the
+// update held before, and returns the number of updates held since the last
+// take
+func (s *squashState) add(target squashTarget, key, data string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch target {
+	case squashRoute:
+		s.batch.routes[key] = data
+	case squashChannel:
+		s.batch.channels[key] = data
+	case squashSender:
+		s.batch.senders[key] = data
+	default:
+		s.batch.data = data
+		s.batch.hasData = true
+	}
+	if !s.pending {
+		s.start = time.Now()
+		s.pending = true
+	}
+	s.count++
+	return s.count
+}
+
+// addStreamIDs holds the stream entries of a squashed update, they are
+// acknowledged once the batch was delivered
+func (s *squashState) addStreamIDs(ids []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batch.streamIDs = append(s.batch.streamIDs, ids...)
+}
+
+// take returns the held updates and resets the state
+func (s *squashState) take() squashBatch {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	batch := s.batch
+	s.batch = newSquashBatch()
+	s.pending = false
+	s.count = 0
+	return batch
+}
+
+// isPending reports whether updates are held, clearing the flag if clear is
+// set
+func (s *squashState) isPending(clear bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pending := s.pending
+	if clear {
+		s.pending = false
+	}
+	return pending
+}
+
+// timeout returns how long the message processor waits for more messages:
+// long while nothing is held, short once updates are held but no longer than
+// maxDelay after the first of them, if set
+func (s *squashState) timeout(short, long, maxDelay time.Duration) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.pending {
+		return long
+	}
+	if maxDelay > 0 {
+		// don't let a steady stream of messages defer the callback
+		if remaining := maxDelay - time.Since(s.start); remaining < short {
+			short = remaining
+		}
+		if short < 0 {
+			short = 0
+		}
+	}
+	return short
+}
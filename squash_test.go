@@ -0,0 +1,70 @@
+package rediswatcher
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSquashState(t *testing.T) {
+	s := newSquashState()
+	if s.isPending(false) {
+		t.Error("New squash state should not be pending")
+	}
+	if d := s.timeout(time.Millisecond, time.Second, 0); d != time.Second {
+		t.Errorf("Timeout should be long while nothing is held, got %v", d)
+	}
+
+	s.add(squashDefault, "", "first")
+	s.add(squashDefault, "", "second")
+	s.add(squashChannel, "channel", "update")
+	if count := s.add(squashRoute, "route", "update"); count != 4 {
+		t.Errorf("Expected 4 held updates, got %d", count)
+	}
+	if !s.isPending(false) {
+		t.Error("Squash state should be pending")
+	}
+	if d := s.timeout(time.Millisecond, time.Second, 0); d != time.Millisecond {
+		t.Errorf("Timeout should be short while updates are held, got %v", d)
+	}
+	if d := s.timeout(time.Second, time.Second, time.Nanosecond); d != 0 {
+		t.Errorf("Timeout should not exceed the max delay, got %v", d)
+	}
+
+	batch := s.take()
+	if !batch.hasData || batch.data != "second" {
+		t.Errorf("Batch should hold the last update, got '%s'", batch.data)
+	}
+	if batch.channels["channel"] != "update" || batch.routes["route"] != "update" {
+		t.Errorf("Batch should hold the channel and route updates, got %v %v", batch.channels, batch.routes)
+	}
+	if s.isPending(false) {
+		t.Error("Squash state should not be pending after take")
+	}
+	if batch := s.take(); batch.hasData || len(batch.channels) > 0 {
+		t.Error("Take should reset the held updates")
+	}
+}
+
+func TestSquashStateConcurrent(t *testing.T) {
+	s := newSquashState()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				s.add(squashSender, "node", "update")
+				s.timeout(time.Millisecond, time.Second, time.Second)
+				if s.isPending(false) {
+					s.take()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	s.take()
+	if s.isPending(false) {
+		t.Error("Squash state should not be pending after take")
+	}
+}
@@ -0,0 +1,132 @@
+package rediswatcher
+
+import (
+	"expvar"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WatcherStats are the cumulative counters returned by Stats
+type WatcherStats struct {
+	Published         uint64
+	Received          uint64
+	Squashed          uint64
+	IgnoredSelf       uint64
+	ReconnectAttempts uint64
+	LastMessage       time.Time
+	LastError         time.Time
+
+	// QueueDepth is the number of received messages waiting for the message
+	// processor, QueueLag how long the last processed message waited
+	QueueDepth int
+	QueueLag   time.Duration
+}
+
+// Stats returns the watcher's cumulative counters, it is safe to call
+// concurrently with the watcher's operation
+func (w *Watcher) Stats() WatcherStats {
+	stats := w.stats.snapshot()
+	stats.QueueDepth = len(w.messagesIn)
+	return stats
+}
+
+// watcherStats holds the watcher's cumulative counters, it is allocated on
+// its own so the 64 bit fields are aligned for atomic access
+type watcherStats struct {
+	published   uint64
+	received    uint64
+	squashed    uint64
+	ignoredSelf uint64
+	reconnects  uint64
+	lastMessage int64
+	lastError   int64
+	queueLag    int64
+}
+
+func (s *watcherStats) receivedAt(t time.Time) {
+	atomic.AddUint64(&s.received, 1)
+	atomic.StoreInt64(&s.lastMessage, t.UnixNano())
+}
+
+func (s *watcherStats) errored(t time.Time) {
+	atomic.StoreInt64(&s.lastError, t.UnixNano())
+}
+
+func (s *watcherStats) snapshot() WatcherStats {
+	return WatcherStats{
+		Published:         atomic.LoadUint64(&s.published),
+		Received:          atomic.LoadUint64(&s.received),
+		Squashed:          atomic.LoadUint64(&s.squashed),
+		IgnoredSelf:       atomic.LoadUint64(&s.ignoredSelf),
+		ReconnectAttempts: atomic.LoadUint64(&s.reconnects),
+		LastMessage:       unixTime(atomic.LoadInt64(&s.lastMessage)),
+		LastError:         unixTime(atomic.LoadInt64(&s.lastError)),
+		QueueLag:          time.Duration(atomic.LoadInt64(&s.queueLag)),
+	}
+}
+
+func unixTime(nsec int64) time.Time {
+	if nsec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nsec)
+}
+
+// vars returns the counters in the form published through expvar
+func (s *watcherStats) vars() map[string]interface{} {
+	stats := s.snapshot()
+	vars := map[string]interface{}{
+		"published":   stats.Published,
+		"received":    stats.Received,
+		"squashed":    stats.Squashed,
+		"ignoredSelf": stats.IgnoredSelf,
+		"reconnects":  stats.ReconnectAttempts,
+		"queueLag":    stats.QueueLag.Seconds(),
+		"lastMessage": "",
+		"lastError":   "",
+	}
+	if !stats.LastMessage.IsZero() {
+		vars["lastMessage"] = stats.LastMessage.UTC().Format(time.RFC3339Nano)
+	}
+	if !stats.LastError.IsZero() {
+		vars["lastError"] = stats.LastError.UTC().Format(time.RFC3339Nano)
+	}
+	return vars
+}
+
+var (
+	expvarMu    sync.Mutex
+	expvarStats = make(map[string]*watcherStats)
+)
+
+// publishExpvar makes the stats visible under name on /debug/vars. expvar
+// names can't be removed, so a watcher created later with the same name takes
+// the name over.
+func publishExpvar(name string, stats *watcherStats) {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+
+	if _, ok := expvarStats[name]; !ok && expvar.Get(name) == nil {
+		expvar.Publish(name, expvar.Func(func() interface{} {
+			expvarMu.Lock()
+			s := expvarStats[name]
+			expvarMu.Unlock()
+			if s == nil {
+				return nil
+			}
+			return s.vars()
+		}))
+	}
+	expvarStats[name] = stats
+}
+
+// unpublishExpvar stops publishing stats under name
+func unpublishExpvar(name string, stats *watcherStats) {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+
+	if expvarStats[name] == stats {
+		expvarStats[name] = nil
+	}
+}
@@ -0,0 +1,71 @@
+package rediswatcher
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/rafaeljusto/redigomock"
+)
+
+func TestExpvar(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), ExpvarName("rediswatcher_test"))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	c.Command("PUBLISH", "/casbin", redigomock.NewAnyData()).Expect("1")
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+	rw.reconnectFailed(errors.New("connection refused"))
+	rw.handleError(errors.New("connection refused"))
+
+	var vars struct {
+		Published  uint64
+		Reconnects uint64
+		LastError  string
+	}
+	if err := json.Unmarshal([]byte(expvar.Get("rediswatcher_test").String()), &vars); err != nil {
+		t.Fatalf("Failed to decode expvar: %v", err)
+	}
+	if vars.Published != 1 || vars.Reconnects != 1 || vars.LastError == "" {
+		t.Errorf("Unexpected expvar counters %+v", vars)
+	}
+
+	w.Close()
+	if expvar.Get("rediswatcher_test").String() != "null" {
+		t.Error("Closed watcher should no longer be published")
+	}
+}
+
+func TestStats(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		IgnoreSelf(true), SquashMessages(true))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+	w.SetUpdateCallback(func(string) {})
+
+	rw.processMessage(decodeMessage("/casbin", []byte("node1")))
+	rw.processMessage(decodeMessage("/casbin", []byte("node2")))
+	rw.stats.receivedAt(time.Now())
+
+	stats := rw.Stats()
+	if stats.IgnoredSelf != 1 || stats.Squashed != 1 || stats.Received != 1 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	if stats.LastMessage.IsZero() || !stats.LastError.IsZero() {
+		t.Errorf("Only the last message time should be set, received %+v", stats)
+	}
+}
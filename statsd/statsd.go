@@ -0,0 +1,113 @@
+// Package statsd forwards the metrics reported by a rediswatcher.Watcher to
+// a statsd or Datadog agent over UDP.
+package statsd
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	rediswatcher "github.com/billcobbler/casbin-redis-watcher/v2"
+)
+
+// Option configures an Emitter
+type Option func(*Emitter)
+
+// Prefix sets the prefix of every metric name, "rediswatcher." by default
+func Prefix(prefix string) Option {
+	return func(e *Emitter) {
+		e.prefix = prefix
+	}
+}
+
+// Tags adds Datadog style tags such as "env:prod" to every metric. The
+// channel and operation tags are always added.
+func Tags(tags ...string) Option {
+	return func(e *Emitter) {
+		e.tags = append(e.tags, tags...)
+	}
+}
+
+// timed are the operations whose latency is sent, other metrics report
+// events or time spent waiting for messages
+var timed = map[string]bool{
+	rediswatcher.RedisDoAuthMetric:       true,
+	rediswatcher.RedisCloseMetric:        true,
+	rediswatcher.RedisDialMetric:         true,
+	rediswatcher.RedisPingMetric:         true,
+	rediswatcher.PubSubPublishMetric:     true,
+	rediswatcher.PubSubSubscribeMetric:   true,
+	rediswatcher.PubSubUnsubscribeMetric: true,
+	rediswatcher.SnapshotWriteMetric:     true,
+	rediswatcher.SnapshotLoadMetric:      true,
+	rediswatcher.StreamAddMetric:         true,
+	rediswatcher.StreamClaimMetric:       true,
+	rediswatcher.PollMetric:              true,
+	rediswatcher.CallbackMetric:          true,
+	rediswatcher.SubscribersMetric:       true,
+	rediswatcher.HeartbeatMetric:         true,
+	rediswatcher.FanoutPublishMetric:     true,
+	rediswatcher.DNSResolveMetric:        true,
+}
+
+// Emitter sends WatcherMetrics to a statsd endpoint. Pass its RecordMetrics
+// method to rediswatcher.RecordMetrics.
+type Emitter struct {
+	mu     sync.Mutex
+	conn   net.Conn
+	prefix string
+	tags   []string
+}
+
+// NewEmitter returns an Emitter sending to the statsd endpoint at addr, such
+// as "127.0.0.1:8125"
+func NewEmitter(addr string, opts ...Option) (*Emitter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	e := &Emitter{conn: conn, prefix: "rediswatcher."}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e, nil
+}
+
+// RecordMetrics sends m, it is meant to be passed to
+// rediswatcher.RecordMetrics. Send failures are ignored, as usual for statsd.
+func (e *Emitter) RecordMetrics(m *rediswatcher.WatcherMetrics) {
+	tags := append([]string{"channel:" + m.Channel, "operation:" + m.Name}, e.tags...)
+
+	var buf bytes.Buffer
+	if m.Name == rediswatcher.RuntimeStatsMetric {
+		e.write(&buf, "goroutines", m.Goroutines, "g", tags)
+		e.write(&buf, "queue.depth", m.QueueDepth, "g", tags)
+		e.write(&buf, "queue.utilization", m.QueueUtilization, "g", tags)
+	} else {
+		e.write(&buf, "operations", 1, "c", tags)
+		if timed[m.Name] {
+			e.write(&buf, "latency", m.LatencyMs, "ms", tags)
+		}
+		if m.Error != nil {
+			e.write(&buf, "errors", 1, "c", tags)
+		}
+		if m.MessageSize > 0 {
+			e.write(&buf, "message.size", m.MessageSize, "h", tags)
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.conn.Write(bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+}
+
+func (e *Emitter) write(buf *bytes.Buffer, name string, value interface{}, kind string, tags []string) {
+	fmt.Fprintf(buf, "%s%s:%v|%s|#%s\n", e.prefix, name, value, kind, strings.Join(tags, ","))
+}
+
+// Close closes the connection to the statsd endpoint
+func (e *Emitter) Close() error {
+	return e.conn.Close()
+}
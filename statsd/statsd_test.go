@@ -0,0 +1,46 @@
+package statsd
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	rediswatcher "github.com/billcobbler/casbin-redis-watcher/v2"
+)
+
+func TestEmitter(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer server.Close()
+
+	e, err := NewEmitter(server.LocalAddr().String(), Prefix("app."), Tags("env:test"))
+	if err != nil {
+		t.Fatalf("Failed to create emitter: %v", err)
+	}
+	defer e.Close()
+
+	e.RecordMetrics(&rediswatcher.WatcherMetrics{Name: rediswatcher.PubSubPublishMetric, Channel: "/casbin", LatencyMs: 1.5})
+
+	buf := make([]byte, 1024)
+	server.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := server.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("Failed to read packet: %v", err)
+	}
+	expected := "app.operations:1|c|#channel:/casbin,operation:PubSubPublish,env:test\n" +
+		"app.latency:1.5|ms|#channel:/casbin,operation:PubSubPublish,env:test"
+	if string(buf[:n]) != expected {
+		t.Errorf("Packet should be '%s', received '%s' instead", expected, buf[:n])
+	}
+
+	e.RecordMetrics(&rediswatcher.WatcherMetrics{Name: rediswatcher.PubSubReceiveMetric, Channel: "/casbin", LatencyMs: 5000})
+	if n, _, err = server.ReadFrom(buf); err != nil {
+		t.Fatalf("Failed to read packet: %v", err)
+	}
+	expected = "app.operations:1|c|#channel:/casbin,operation:PubSubReceive,env:test"
+	if string(buf[:n]) != expected {
+		t.Errorf("Untimed metrics should not send a latency, received '%s'", buf[:n])
+	}
+}
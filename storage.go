@@ -0,0 +1,186 @@
+package rediswatcher
+
+import (
+	"bytes"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Storage holds the watcher's auxiliary state: policy snapshots, revision
+// counters, the update backlog, the instance registry and locks. By default
+// it is kept on the watcher's publish connection, WithStorage points it
+// elsewhere without affecting the pub/sub path.
+type Storage interface {
+	// Get returns the value stored at key, or nil if the key does not exist
+	Get(key string) ([]byte, error)
+	// Set stores value at key, expiring it after ttl unless ttl is 0
+	Set(key string, value []byte, ttl time.Duration) error
+	// Incr atomically increments the counter at key and returns its new value
+	Incr(key string) (int64, error)
+
+	// SetNX stores value at key only if the key does not exist yet
+	SetNX(key string, value []byte, ttl time.Duration) (bool, error)
+	// SetNXIncr atomically stores value at key only if the key does not
+	// exist yet and, if it was stored, increments the counter at counterKey.
+	// It returns the new counter value, or 0 if the key exists.
+	SetNXIncr(key string, value []byte, ttl time.Duration, counterKey string) (int64, error)
+	// CompareAndDelete deletes key only if it holds value
+	CompareAndDelete(key string, value []byte) (bool, error)
+	// CompareAndExpire resets the ttl of key only if it holds value
+	CompareAndExpire(key string, value []byte, ttl time.Duration) (bool, error)
+
+	// HashSet stores value under field in the hash at key
+	HashSet(key, field string, value []byte) error
+	// HashGetAll returns all fields of the hash at key
+	HashGetAll(key string) (map[string][]byte, error)
+	// HashDelete removes field from the hash at key
+	HashDelete(key, field string) error
+	// HashCompareAndDelete removes field from the hash at key only if it
+	// holds value
+	HashCompareAndDelete(key, field string, value []byte) (bool, error)
+
+	// LogAppend appends value with sequence number seq to the log at key,
+	// keeping at most maxLen entries unless maxLen is 0
+	LogAppend(key string, seq int64, value []byte, maxLen int64) error
+	// LogSince returns the entries appended to the log at key with a sequence
+	// number greater than seq, oldest first
+	LogSince(key string, seq int64) ([]LogEntry, error)
+}
+
+// LogEntry is a value appended to a log in Storage with its sequence number
+type LogEntry struct {
+	Seq   int64
+	Value []byte
+}
+
+const (
+	compareAndDeleteScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) else return 0 end`
+	setNXIncrScript        = `if redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2], "NX") then return redis.call("INCR", KEYS[2]) else return 0 end`
+	compareAndExpireScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("PEXPIRE", KEYS[1], ARGV[2]) else return 0 end`
+	hashCompareAndDelete   = `if redis.call("HGET", KEYS[1], ARGV[1]) == ARGV[2] then return redis.call("HDEL", KEYS[1], ARGV[1]) else return 0 end`
+)
+
+type redisStorage struct {
+	do func(commandName string, args ...interface{}) (interface{}, error)
+}
+
+// NewRedisStorage returns a Storage kept on its own redis connection
+func NewRedisStorage(conn redis.Conn) Storage {
+	var mu sync.Mutex
+	return &redisStorage{
+		do: func(commandName string, args ...interface{}) (interface{}, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			return conn.Do(commandName, args...)
+		},
+	}
+}
+
+func (s *redisStorage) Get(key string) ([]byte, error) {
+	value, err := redis.Bytes(s.do("GET", key))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	return value, err
+}
+
+func (s *redisStorage) Set(key string, value []byte, ttl time.Duration) error {
+	var err error
+	if ttl > 0 {
+		_, err = s.do("SET", key, value, "PX", int64(ttl/time.Millisecond))
+	} else {
+		_, err = s.do("SET", key, value)
+	}
+	return err
+}
+
+func (s *redisStorage) Incr(key string) (int64, error) {
+	return redis.Int64(s.do("INCR", key))
+}
+
+func (s *redisStorage) SetNX(key string, value []byte, ttl time.Duration) (bool, error) {
+	reply, err := s.do("SET", key, value, "PX", int64(ttl/time.Millisecond), "NX")
+	if err != nil {
+		return false, err
+	}
+	return reply != nil, nil
+}
+
+func (s *redisStorage) SetNXIncr(key string, value []byte, ttl time.Duration, counterKey string) (int64, error) {
+	return redis.Int64(s.do("EVAL", setNXIncrScript, 2, key, counterKey, value, int64(ttl/time.Millisecond)))
+}
+
+func (s *redisStorage) CompareAndDelete(key string, value []byte) (bool, error) {
+	n, err := redis.Int(s.do("EVAL", compareAndDeleteScript, 1, key, value))
+	return n == 1, err
+}
+
+func (s *redisStorage) CompareAndExpire(key string, value []byte, ttl time.Duration) (bool, error) {
+	n, err := redis.Int(s.do("EVAL", compareAndExpireScript, 1, key, value, int64(ttl/time.Millisecond)))
+	return n == 1, err
+}
+
+func (s *redisStorage) HashSet(key, field string, value []byte) error {
+	_, err := s.do("HSET", key, field, value)
+	return err
+}
+
+func (s *redisStorage) HashGetAll(key string) (map[string][]byte, error) {
+	values, err := redis.ByteSlices(s.do("HGETALL", key))
+	if err != nil {
+		return nil, err
+	}
+	hash := make(map[string][]byte, len(values)/2)
+	for i := 0; i+1 < len(values); i += 2 {
+		hash[string(values[i])] = values[i+1]
+	}
+	return hash, nil
+}
+
+func (s *redisStorage) HashDelete(key, field string) error {
+	_, err := s.do("HDEL", key, field)
+	return err
+}
+
+func (s *redisStorage) HashCompareAndDelete(key, field string, value []byte) (bool, error) {
+	n, err := redis.Int(s.do("EVAL", hashCompareAndDelete, 1, key, field, value))
+	return n == 1, err
+}
+
+// log entries are kept in a sorted set scored by sequence number, members
+// are prefixed with the sequence number to keep equal values distinct
+func (s *redisStorage) LogAppend(key string, seq int64, value []byte, maxLen int64) error {
+	member := append([]byte(strconv.FormatInt(seq, 10)+":"), value...)
+	if _, err := s.do("ZADD", key, seq, member); err != nil {
+		return err
+	}
+	if maxLen > 0 {
+		if _, err := s.do("ZREMRANGEBYRANK", key, 0, -maxLen-1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *redisStorage) LogSince(key string, seq int64) ([]LogEntry, error) {
+	members, err := redis.ByteSlices(s.do("ZRANGEBYSCORE", key, "("+strconv.FormatInt(seq, 10), "+inf"))
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]LogEntry, 0, len(members))
+	for _, member := range members {
+		i := bytes.IndexByte(member, ':')
+		if i < 0 {
+			continue
+		}
+		n, err := strconv.ParseInt(string(member[:i]), 10, 64)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, LogEntry{Seq: n, Value: member[i+1:]})
+	}
+	return entries, nil
+}
@@ -0,0 +1,225 @@
+package rediswatcher
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryStorage is an in-process Storage used to test the features built on
+// auxiliary keys without a redis server
+type memoryStorage struct {
+	mu     sync.Mutex
+	values map[string][]byte
+	hashes map[string]map[string][]byte
+	logs   map[string]map[int64][]byte
+}
+
+func newMemoryStorage() *memoryStorage {
+	return &memoryStorage{
+		values: make(map[string][]byte),
+		hashes: make(map[string]map[string][]byte),
+		logs:   make(map[string]map[int64][]byte),
+	}
+}
+
+func (s *memoryStorage) Get(key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values[key], nil
+}
+
+func (s *memoryStorage) Set(key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+	return nil
+}
+
+func (s *memoryStorage) Incr(key string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, _ := strconv.ParseInt(string(s.values[key]), 10, 64)
+	n++
+	s.values[key] = []byte(strconv.FormatInt(n, 10))
+	return n, nil
+}
+
+func (s *memoryStorage) SetNX(key string, value []byte, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.values[key]; ok {
+		return false, nil
+	}
+	s.values[key] = value
+	return true, nil
+}
+
+func (s *memoryStorage) SetNXIncr(key string, value []byte, ttl time.Duration, counterKey string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.values[key]; ok {
+		return 0, nil
+	}
+	s.values[key] = value
+	n, _ := strconv.ParseInt(string(s.values[counterKey]), 10, 64)
+	n++
+	s.values[counterKey] = []byte(strconv.FormatInt(n, 10))
+	return n, nil
+}
+
+func (s *memoryStorage) CompareAndDelete(key string, value []byte) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if string(s.values[key]) != string(value) {
+		return false, nil
+	}
+	delete(s.values, key)
+	return true, nil
+}
+
+func (s *memoryStorage) CompareAndExpire(key string, value []byte, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return string(s.values[key]) == string(value), nil
+}
+
+func (s *memoryStorage) HashSet(key, field string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.hashes[key] == nil {
+		s.hashes[key] = make(map[string][]byte)
+	}
+	s.hashes[key][field] = value
+	return nil
+}
+
+func (s *memoryStorage) HashGetAll(key string) (map[string][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hash := make(map[string][]byte)
+	for field, value := range s.hashes[key] {
+		hash[field] = value
+	}
+	return hash, nil
+}
+
+func (s *memoryStorage) HashDelete(key, field string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.hashes[key], field)
+	return nil
+}
+
+func (s *memoryStorage) HashCompareAndDelete(key, field string, value []byte) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if current, ok := s.hashes[key][field]; !ok || string(current) != string(value) {
+		return false, nil
+	}
+	delete(s.hashes[key], field)
+	return true, nil
+}
+
+func (s *memoryStorage) LogAppend(key string, seq int64, value []byte, maxLen int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.logs[key] == nil {
+		s.logs[key] = make(map[int64][]byte)
+	}
+	s.logs[key][seq] = value
+	for maxLen > 0 && int64(len(s.logs[key])) > maxLen {
+		oldest := seq
+		for n := range s.logs[key] {
+			if n < oldest {
+				oldest = n
+			}
+		}
+		delete(s.logs[key], oldest)
+	}
+	return nil
+}
+
+func (s *memoryStorage) LogSince(key string, seq int64) ([]LogEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var seqs []int64
+	for n := range s.logs[key] {
+		if n > seq {
+			seqs = append(seqs, n)
+		}
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	entries := make([]LogEntry, 0, len(seqs))
+	for _, n := range seqs {
+		entries = append(entries, LogEntry{Seq: n, Value: s.logs[key][n]})
+	}
+	return entries, nil
+}
+
+func TestRedisStorage(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	s := NewRedisStorage(c)
+
+	c.Command("GET", "missing").Expect(nil)
+	if value, err := s.Get("missing"); value != nil || err != nil {
+		t.Errorf("Missing key should return nil, received '%s' and '%v' instead", value, err)
+	}
+
+	c.Command("SET", "lock", []byte("node1"), "PX", int64(1000), "NX").Expect(nil)
+	if ok, err := s.SetNX("lock", []byte("node1"), time.Second); ok || err != nil {
+		t.Errorf("SetNX on an existing key should fail, received %v and '%v' instead", ok, err)
+	}
+
+	c.Command("EVAL", setNXIncrScript, 2, "lock", "lock:fence", []byte("node1"), int64(1000)).Expect(int64(4))
+	if token, err := s.SetNXIncr("lock", []byte("node1"), time.Second, "lock:fence"); token != 4 || err != nil {
+		t.Errorf("SetNXIncr should return the new token, received %d and '%v' instead", token, err)
+	}
+
+	c.Command("HGETALL", "registry").Expect([]interface{}{[]byte("node1"), []byte("a"), []byte("node2"), []byte("b")})
+	hash, err := s.HashGetAll("registry")
+	if err != nil || len(hash) != 2 || string(hash["node2"]) != "b" {
+		t.Errorf("Hash should contain node1 and node2, received %v and '%v' instead", hash, err)
+	}
+
+	c.Command("ZRANGEBYSCORE", "log", "(3", "+inf").Expect([]interface{}{[]byte("4:a"), []byte("5:b:c")})
+	entries, err := s.LogSince("log", 3)
+	if err != nil || len(entries) != 2 || entries[0].Seq != 4 || string(entries[0].Value) != "a" ||
+		entries[1].Seq != 5 || string(entries[1].Value) != "b:c" {
+		t.Errorf("Log should return 4:'a' and 5:'b:c', received %v and '%v' instead", entries, err)
+	}
+}
+
+func TestWithStorage(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	storage := newMemoryStorage()
+
+	var loaded []byte
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), WithStorage(storage),
+		SnapshotProvider(func() ([]byte, error) {
+			return []byte("p, alice, data1, read"), nil
+		}),
+		SnapshotLoader(func(data []byte) error {
+			loaded = data
+			return nil
+		}))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	key, err := rw.writeSnapshot(1)
+	if err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+	if err := rw.loadSnapshot(key); err != nil {
+		t.Fatalf("Failed to load snapshot: %v", err)
+	}
+	if string(loaded) != "p, alice, data1, read" {
+		t.Errorf("Snapshot should be 'p, alice, data1, read', received '%s' instead", loaded)
+	}
+}
@@ -0,0 +1,360 @@
+package rediswatcher
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Transports selectable with WithTransport
+const (
+	PubSubTransport   = "pubsub"
+	StreamTransport   = "stream"
+	KeyspaceTransport = "keyspace"
+	PollTransport     = "poll"
+	DualTransport     = "dual"
+)
+
+const (
+	streamDataField        = "data"
+	defaultStreamBlock     = 30 * time.Second
+	defaultStreamClaimIdle = time.Minute
+)
+
+// streamKey returns the key of the update stream, the prefixed channel by
+// default
+func (w *Watcher) streamKey() string {
+	if w.options.StreamKey != "" {
+		return w.options.StreamKey
+	}
+	return w.prefixed(w.options.Channel)
+}
+
+// addStream appends data to the update stream, id is the message ID reported
+// with the metric
+func (w *Watcher) addStream(data string, id string) error {
+	startTime := time.Now()
+	args := []interface{}{w.streamKey()}
+	if w.options.StreamMaxLen > 0 {
+		args = append(args, w.streamTrim("MAXLEN", w.options.StreamMaxLen)...)
+	} else if w.options.StreamMaxAge > 0 {
+		args = append(args, w.streamTrim("MINID", w.streamMinID())...)
+	}
+	args = append(args, "*", streamDataField, data)
+	_, err := w.pubDo("XADD", args...)
+	if err == nil && w.options.StreamMaxLen > 0 && w.options.StreamMaxAge > 0 {
+		// XADD takes a single trimming strategy
+		_, err = w.pubDo("XTRIM", append([]interface{}{w.streamKey()}, w.streamTrim("MINID", w.streamMinID())...)...)
+	}
+	if w.options.RecordMetrics != nil {
+		m := w.createMetrics(StreamAddMetric, startTime, err)
+		m.MessageID = id
+		w.options.RecordMetrics(m)
+	}
+	return err
+}
+
+// streamTrim returns the trimming arguments for strategy, approximate unless
+// StreamTrimExact is set
+func (w *Watcher) streamTrim(strategy string, threshold interface{}) []interface{} {
+	if w.options.StreamTrimExact {
+		return []interface{}{strategy, threshold}
+	}
+	return []interface{}{strategy, "~", threshold}
+}
+
+// streamMinID returns the id of the oldest entry kept with StreamMaxAge
+func (w *Watcher) streamMinID() string {
+	return strconv.FormatInt(time.Now().Add(-w.options.StreamMaxAge).UnixNano()/int64(time.Millisecond), 10)
+}
+
+// initStream sets the position the watcher starts reading the stream from to
+// its current last entry, so that entries added afterwards are not missed.
+// The position is kept across reconnects, entries added while disconnected
+// are read once the watcher is connected again.
+func (w *Watcher) initStream() error {
+	if w.streamID != "" {
+		return nil
+	}
+	entries, err := redis.Values(w.subConn.Do("XREVRANGE", w.streamKey(), "+", "-", "COUNT", 1))
+	if err != nil {
+		return err
+	}
+	w.streamID = "0-0"
+	if len(entries) > 0 {
+		id, _, err := parseStreamEntry(entries[0])
+		if err != nil {
+			return err
+		}
+		w.streamID = id
+	}
+	return nil
+}
+
+// readStream reads new stream entries until the connection fails
+func (w *Watcher) readStream() error {
+	if err := w.initStream(); err != nil {
+		return err
+	}
+	w.reconnected()
+
+	for {
+		startTime := time.Now()
+		reply, err := w.subConn.Do("XREAD", "BLOCK", int64(defaultStreamBlock/time.Millisecond),
+			"STREAMS", w.streamKey(), w.streamID)
+		if err != nil {
+			if w.options.RecordMetrics != nil {
+				w.options.RecordMetrics(w.createMetrics(StreamReadMetric, startTime, err))
+			}
+			return err
+		}
+		if reply == nil {
+			// block timeout
+			continue
+		}
+		entries, err := streamEntries(reply)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			w.streamID = entry.id
+			w.receiveEntry(entry, startTime)
+		}
+	}
+}
+
+// streamConsumer returns the consumer name used in StreamGroup, the LocalID
+// by default
+func (w *Watcher) streamConsumer() string {
+	if w.options.StreamConsumer != "" {
+		return w.options.StreamConsumer
+	}
+	return w.options.LocalID
+}
+
+// createGroup creates the consumer group and the stream unless they exist
+func (w *Watcher) createGroup() error {
+	_, err := w.subConn.Do("XGROUP", "CREATE", w.streamKey(), w.options.StreamGroup, "$", "MKSTREAM")
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil
+	}
+	return err
+}
+
+// readGroup reads the stream through the consumer group until the connection
+// fails. Entries delivered to this consumer before but never acknowledged are
+// read first, then new entries.
+func (w *Watcher) readGroup() error {
+	if err := w.createGroup(); err != nil {
+		return err
+	}
+	w.reconnected()
+
+	pendingID := "0"
+	for {
+		id := pendingID
+		if id == "" {
+			id = ">"
+		}
+		startTime := time.Now()
+		reply, err := w.subConn.Do("XREADGROUP", "GROUP", w.options.StreamGroup, w.streamConsumer(),
+			"BLOCK", int64(defaultStreamBlock/time.Millisecond), "STREAMS", w.streamKey(), id)
+		if err != nil {
+			if w.options.RecordMetrics != nil {
+				w.options.RecordMetrics(w.createMetrics(StreamReadMetric, startTime, err))
+			}
+			return err
+		}
+		if reply == nil {
+			// block timeout
+			continue
+		}
+		entries, err := streamEntries(reply)
+		if err != nil {
+			return err
+		}
+		if pendingID != "" {
+			if len(entries) == 0 {
+				pendingID = ""
+				continue
+			}
+			pendingID = entries[len(entries)-1].id
+		}
+		for _, entry := range entries {
+			if entry.data == nil {
+				// deleted while pending
+				w.ackStream(entry.id)
+				continue
+			}
+			w.receiveEntry(entry, startTime)
+		}
+	}
+}
+
+// claimPending periodically takes over the entries other consumers of the
+// group left unacknowledged for longer than StreamClaimIdle, such as those of
+// a watcher that crashed, until the watcher is closed
+func (w *Watcher) claimPending() {
+	ticker := time.NewTicker(w.options.StreamClaimInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.closed:
+			return
+		case <-ticker.C:
+			if err := w.claim(); err != nil {
+				w.options.Logger.Error("Failure claiming pending stream entries", "channel", w.options.Channel, "localID", w.options.LocalID, "error", err)
+				w.handleError(err)
+			}
+		}
+	}
+}
+
+// claim runs XAUTOCLAIM over the whole pending entries list
+func (w *Watcher) claim() error {
+	start := "0-0"
+	for {
+		startTime := time.Now()
+		reply, err := redis.Values(w.pubDo("XAUTOCLAIM", w.streamKey(), w.options.StreamGroup, w.streamConsumer(),
+			int64(w.options.StreamClaimIdle/time.Millisecond), start, "COUNT", 100))
+		if w.options.RecordMetrics != nil {
+			w.options.RecordMetrics(w.createMetrics(StreamClaimMetric, startTime, err))
+		}
+		if err != nil {
+			return err
+		}
+		if len(reply) < 2 {
+			return redis.Error("rediswatcher: unexpected XAUTOCLAIM reply")
+		}
+		if start, err = redis.String(reply[0], nil); err != nil {
+			return err
+		}
+		entries, err := parseStreamEntries(reply[1])
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if entry.data == nil {
+				w.ackStream(entry.id)
+				continue
+			}
+			select {
+			case <-w.closed:
+				return nil
+			default:
+			}
+			w.receiveEntry(entry, startTime)
+		}
+		if start == "0-0" {
+			return nil
+		}
+	}
+}
+
+// ackStreams acknowledges the stream entries a handled message was read from
+func (w *Watcher) ackStreams(ids []string) {
+	for _, id := range ids {
+		w.ackStream(id)
+	}
+}
+
+// ackStream acknowledges a stream entry read through the consumer group
+func (w *Watcher) ackStream(id string) {
+	if _, err := w.pubDo("XACK", w.streamKey(), w.options.StreamGroup, id); err != nil {
+		w.options.Logger.Error("Failure acknowledging stream entry", "channel", w.options.Channel, "localID", w.options.LocalID, "id", id, "error", err)
+		w.handleError(err)
+	}
+}
+
+// receiveEntry hands a stream entry to the message processor
+func (w *Watcher) receiveEntry(entry streamEntry, startTime time.Time) {
+	msg, err := w.open(w.options.Channel, entry.data)
+	if err != nil {
+		w.options.Logger.Error("Failure decrypting stream entry", "channel", w.options.Channel, "localID", w.options.LocalID, "id", entry.id, "error", err)
+		w.handleError(err)
+		if w.options.StreamGroup != "" {
+			w.ackStream(entry.id)
+		}
+		return
+	}
+	if w.options.StreamGroup != "" {
+		msg.streamIDs = []string{entry.id}
+	}
+	if w.options.Transport == DualTransport && w.seenLive(msg) {
+		return
+	}
+	if w.options.RecordMetrics != nil {
+		m := w.createMetrics(StreamReadMetric, startTime, nil)
+		m.MessageSize = int64(len(entry.data))
+		m.MessageID = msg.ID()
+		w.options.RecordMetrics(m)
+	}
+	w.stats.receivedAt(time.Now())
+	w.enqueue(msg)
+}
+
+type streamEntry struct {
+	id   string
+	data []byte
+}
+
+// streamEntries parses the entries of the single stream in an XREAD reply
+func streamEntries(reply interface{}) ([]streamEntry, error) {
+	streams, err := redis.Values(reply, nil)
+	if err != nil || len(streams) == 0 {
+		return nil, err
+	}
+	stream, err := redis.Values(streams[0], nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(stream) != 2 {
+		return nil, redis.Error("rediswatcher: unexpected stream reply")
+	}
+	return parseStreamEntries(stream[1])
+}
+
+func parseStreamEntries(reply interface{}) ([]streamEntry, error) {
+	values, err := redis.Values(reply, nil)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]streamEntry, 0, len(values))
+	for _, value := range values {
+		id, data, err := parseStreamEntry(value)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, streamEntry{id: id, data: data})
+	}
+	return entries, nil
+}
+
+// parseStreamEntry parses an [id, [field, value, ...]] stream entry and
+// returns its id and data field
+func parseStreamEntry(reply interface{}) (string, []byte, error) {
+	entry, err := redis.Values(reply, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(entry) != 2 {
+		return "", nil, redis.Error("rediswatcher: unexpected stream entry")
+	}
+	id, err := redis.String(entry[0], nil)
+	if err != nil || entry[1] == nil {
+		return id, nil, err
+	}
+	fields, err := redis.ByteSlices(entry[1], nil)
+	if err != nil {
+		return "", nil, err
+	}
+	for i := 0; i+1 < len(fields); i += 2 {
+		if string(fields[i]) == streamDataField {
+			return id, fields[i+1], nil
+		}
+	}
+	return id, nil, nil
+}
@@ -0,0 +1,187 @@
+package rediswatcher
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rafaeljusto/redigomock"
+)
+
+func streamReply(key string, entries ...[]interface{}) interface{} {
+	values := make([]interface{}, 0, len(entries))
+	for _, entry := range entries {
+		values = append(values, entry)
+	}
+	return []interface{}{[]interface{}{[]byte(key), values}}
+}
+
+func streamEntryReply(id, data string) []interface{} {
+	return []interface{}{[]byte(id), []interface{}{[]byte(streamDataField), []byte(data)}}
+}
+
+func TestStreamPublish(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c),
+		WithTransport(StreamTransport), StreamKey("casbin:updates"))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+
+	xadd := c.Command("XADD", "casbin:updates", "*", streamDataField, redigomock.NewAnyData()).Expect("1-0")
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+	if c.Stats(xadd) != 1 {
+		t.Errorf("Update should be appended to the stream")
+	}
+}
+
+func TestStreamRead(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c),
+		WithTransport(StreamTransport))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+	rw.messagesIn = make(chan *UpdateMessage, 10)
+
+	c.Command("XREVRANGE", "/casbin", "+", "-", "COUNT", 1).Expect([]interface{}{streamEntryReply("1-0", "node2")})
+	c.Command("XREAD", "BLOCK", redigomock.NewAnyInt(), "STREAMS", "/casbin", "1-0").
+		Expect(streamReply("/casbin", streamEntryReply("2-0", "node3"), streamEntryReply("3-0", "node4")))
+	closed := errors.New("connection closed")
+	c.Command("XREAD", "BLOCK", redigomock.NewAnyInt(), "STREAMS", "/casbin", "3-0").ExpectError(closed)
+
+	if err := rw.readStream(); err != closed {
+		t.Fatalf("Reading should stop with the connection error, received %v", err)
+	}
+	if len(rw.messagesIn) != 2 {
+		t.Fatalf("Expected 2 messages, received %d", len(rw.messagesIn))
+	}
+	if msg := <-rw.messagesIn; msg.Payload != "node3" {
+		t.Errorf("First message should be 'node3', received '%s' instead", msg.Payload)
+	}
+	if rw.streamID != "3-0" {
+		t.Errorf("Stream position should be '3-0', received '%s' instead", rw.streamID)
+	}
+}
+
+func TestStreamGroup(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c),
+		WithTransport(StreamTransport), StreamGroup("host1"), StreamConsumer("node1"))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+	rw.messagesIn = make(chan *UpdateMessage, 10)
+
+	c.Command("XGROUP", "CREATE", "/casbin", "host1", "$", "MKSTREAM").ExpectError(errors.New("BUSYGROUP Consumer Group name already exists"))
+	c.Command("XREADGROUP", "GROUP", "host1", "node1", "BLOCK", redigomock.NewAnyInt(), "STREAMS", "/casbin", "0").
+		Expect(streamReply("/casbin", streamEntryReply("1-0", "node2")))
+	c.Command("XREADGROUP", "GROUP", "host1", "node1", "BLOCK", redigomock.NewAnyInt(), "STREAMS", "/casbin", "1-0").
+		Expect(streamReply("/casbin"))
+	closed := errors.New("connection closed")
+	c.Command("XREADGROUP", "GROUP", "host1", "node1", "BLOCK", redigomock.NewAnyInt(), "STREAMS", "/casbin", ">").ExpectError(closed)
+	xack := c.Command("XACK", "/casbin", "host1", "1-0").Expect(int64(1))
+
+	if err := rw.readGroup(); err != closed {
+		t.Fatalf("Reading should stop with the connection error, received %v", err)
+	}
+	if len(rw.messagesIn) != 1 {
+		t.Fatalf("Pending entry should be read again, received %d messages", len(rw.messagesIn))
+	}
+	rw.processMessage(<-rw.messagesIn)
+	if c.Stats(xack) != 1 {
+		t.Error("Processed entry should be acknowledged")
+	}
+}
+
+func TestStreamTrimming(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c),
+		WithTransport(StreamTransport), StreamMaxLen(1000), StreamMaxAge(time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+
+	xadd := c.Command("XADD", "/casbin", "MAXLEN", "~", int64(1000), "*", streamDataField, redigomock.NewAnyData()).Expect("1-0")
+	xtrim := c.Command("XTRIM", "/casbin", "MINID", "~", redigomock.NewAnyData()).Expect(int64(0))
+	if err := w.Update(); err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+	if c.Stats(xadd) != 1 || c.Stats(xtrim) != 1 {
+		t.Errorf("Stream should be trimmed by length and age, XADD %d and XTRIM %d times", c.Stats(xadd), c.Stats(xtrim))
+	}
+}
+
+func TestStreamClaim(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c),
+		WithTransport(StreamTransport), StreamGroup("host1"), StreamConsumer("node1"), StreamClaimIdle(time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+	rw.messagesIn = make(chan *UpdateMessage, 10)
+
+	c.Command("XAUTOCLAIM", "/casbin", "host1", "node1", int64(60000), "0-0", "COUNT", 100).
+		Expect([]interface{}{[]byte("5-0"), []interface{}{streamEntryReply("4-0", "node2")}})
+	c.Command("XAUTOCLAIM", "/casbin", "host1", "node1", int64(60000), "5-0", "COUNT", 100).
+		Expect([]interface{}{[]byte("0-0"), []interface{}{streamEntryReply("5-0", "node3")}})
+
+	if err := rw.claim(); err != nil {
+		t.Fatalf("Failed to claim: %v", err)
+	}
+	if len(rw.messagesIn) != 2 {
+		t.Fatalf("Expected 2 claimed messages, received %d", len(rw.messagesIn))
+	}
+	if msg := <-rw.messagesIn; len(msg.streamIDs) != 1 || msg.streamIDs[0] != "4-0" {
+		t.Errorf("Claimed message should be acknowledged as '4-0', received %v instead", msg.streamIDs)
+	}
+}
+
+func TestStreamAckAfterCallback(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c),
+		WithTransport(StreamTransport), StreamGroup("host1"), SquashMessages(true),
+		QueueSize(1), QueueOverflow(OverflowCoalesce))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+	rw.messagesIn = make(chan *UpdateMessage, 1)
+	var received []string
+	w.SetUpdateCallback(func(msg string) { received = append(received, msg) })
+
+	xack1 := c.Command("XACK", "/casbin", "host1", "1-0").Expect(int64(1))
+	xack2 := c.Command("XACK", "/casbin", "host1", "2-0").Expect(int64(1))
+	rw.enqueue(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Payload: "node2", streamIDs: []string{"1-0"}})
+	rw.enqueue(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Payload: "node2", streamIDs: []string{"2-0"}})
+	msg := <-rw.messagesIn
+	if len(msg.streamIDs) != 2 {
+		t.Fatalf("Coalesced message should carry the dropped entry, received %v", msg.streamIDs)
+	}
+
+	rw.processMessage(msg)
+	if c.Stats(xack1) != 0 || c.Stats(xack2) != 0 {
+		t.Error("Squashed entries should not be acknowledged before the callback ran")
+	}
+	rw.flushSquashed()
+	if len(received) != 1 || c.Stats(xack1) != 1 || c.Stats(xack2) != 1 {
+		t.Errorf("Entries should be acknowledged once the callback ran, received %v", received)
+	}
+}
@@ -0,0 +1,59 @@
+package rediswatcher
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// SubscribersError is passed to the ErrorHandler when fewer than
+// MinSubscribers watchers subscribe to the channel an update is published to
+type SubscribersError struct {
+	Channel     string
+	Subscribers int64
+	Min         int64
+}
+
+func (e *SubscribersError) Error() string {
+	return fmt.Sprintf("rediswatcher: %d subscribers on %s, expected at least %d", e.Subscribers, e.Channel, e.Min)
+}
+
+// checkSubscribers counts the subscribers of channel with PUBSUB NUMSUB, or
+// SHARDNUMSUB with sharded pub/sub, and reports fewer than MinSubscribers.
+// The update is published regardless.
+func (w *Watcher) checkSubscribers(channel string) {
+	startTime := time.Now()
+	subcommand := "NUMSUB"
+	if w.sharded() {
+		subcommand = "SHARDNUMSUB"
+	}
+	subscribers, err := numSub(w.pubDo("PUBSUB", subcommand, channel))
+	if w.options.RecordMetrics != nil {
+		m := w.createMetrics(SubscribersMetric, startTime, err)
+		m.Receivers = subscribers
+		w.options.RecordMetrics(m)
+	}
+	if err != nil {
+		w.options.Logger.Error("Failure counting subscribers", "channel", channel, "localID", w.options.LocalID, "error", err)
+		w.handleError(err)
+		return
+	}
+	if subscribers < int64(w.options.MinSubscribers) {
+		err := &SubscribersError{Channel: channel, Subscribers: subscribers, Min: int64(w.options.MinSubscribers)}
+		w.options.Logger.Warn("Too few subscribers", "channel", channel, "localID", w.options.LocalID, "subscribers", subscribers, "min", w.options.MinSubscribers)
+		w.handleError(err)
+	}
+}
+
+// numSub parses the [channel, count] reply of PUBSUB NUMSUB for one channel
+func numSub(reply interface{}, err error) (int64, error) {
+	values, err := redis.Values(reply, err)
+	if err != nil {
+		return 0, err
+	}
+	if len(values) != 2 {
+		return 0, redis.Error("rediswatcher: unexpected PUBSUB NUMSUB reply")
+	}
+	return redis.Int64(values[1], nil)
+}
@@ -0,0 +1,43 @@
+package rediswatcher
+
+import "testing"
+
+func TestMinSubscribers(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	c.Command("PUBSUB", "NUMSUB", "/casbin").Expect([]interface{}{[]byte("/casbin"), int64(1)})
+	c.Command("PUBLISH", "/casbin", "node1").Expect(int64(1))
+
+	var errs []error
+	var subscribers int64 = -1
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		MinSubscribers(2), WithErrorHandler(func(err error) { errs = append(errs, err) }),
+		RecordMetrics(func(m *WatcherMetrics) {
+			if m.Name == SubscribersMetric {
+				subscribers = m.Receivers
+			}
+		}))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+
+	if err := w.Update(); err != nil {
+		t.Fatalf("Update should publish regardless of the subscribers: %v", err)
+	}
+	if subscribers != 1 {
+		t.Errorf("Expected 1 subscriber in the metric, got %d", subscribers)
+	}
+	if len(errs) != 1 {
+		t.Fatalf("Expected a SubscribersError, got %v", errs)
+	}
+	if err, ok := errs[0].(*SubscribersError); !ok || err.Subscribers != 1 || err.Min != 2 {
+		t.Errorf("Expected a SubscribersError, got %v", errs[0])
+	}
+
+	c.Command("PUBSUB", "NUMSUB", "/casbin").Expect([]interface{}{[]byte("/casbin"), int64(2)})
+	errs = nil
+	w.Update()
+	if len(errs) != 0 {
+		t.Errorf("Enough subscribers should not be reported, got %v", errs)
+	}
+}
@@ -0,0 +1,53 @@
+package rediswatcher
+
+import "context"
+
+// UpdateTenant publishes an update of the policy of a single tenant, or
+// domain. The update is delivered to the watchers hosting tenant, see
+// Tenants, whose update callback receives the tenant. It is always published
+// as an envelope.
+func (w *Watcher) UpdateTenant(tenant string) error {
+	version, err := w.incrVersion()
+	if err != nil {
+		return err
+	}
+	return w.publishMessage(context.Background(), &UpdateMessage{
+		Type:    UpdateMessageType,
+		Tenant:  tenant,
+		Version: version,
+		Payload: tenant,
+	})
+}
+
+// tenantChannel returns the channel of tenant with TenantChannels
+func (w *Watcher) tenantChannel(tenant string) string {
+	return w.options.Channel + ":" + tenant
+}
+
+// messageChannel returns the channel msg is published to
+func (w *Watcher) messageChannel(msg *UpdateMessage) string {
+	if msg.Type == CommandMessageType {
+		return w.options.ControlChannel
+	}
+	if msg.Type == AckMessageType && w.replies() {
+		return w.replyChannel(msg.Target)
+	}
+	if w.options.TenantChannels && msg.Tenant != "" {
+		return w.tenantChannel(msg.Tenant)
+	}
+	return w.options.Channel
+}
+
+// hostsTenant reports whether updates for tenant are delivered, updates
+// without a tenant always are
+func (w *Watcher) hostsTenant(tenant string) bool {
+	if tenant == "" || len(w.options.Tenants) == 0 {
+		return true
+	}
+	for _, t := range w.options.Tenants {
+		if t == tenant {
+			return true
+		}
+	}
+	return false
+}
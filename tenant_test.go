@@ -0,0 +1,36 @@
+package rediswatcher
+
+import (
+	"testing"
+
+	"github.com/rafaeljusto/redigomock"
+)
+
+func TestUpdateTenant(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		Tenants([]string{"tenant1"}), TenantChannels(true), VerifyChannel(true))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	publish := c.Command("PUBLISH", "/casbin:tenant2", redigomock.NewAnyData()).Expect("1")
+	if err := rw.UpdateTenant("tenant2"); err != nil {
+		t.Fatalf("Failed to publish tenant update: %v", err)
+	}
+	if c.Stats(publish) != 1 {
+		t.Error("Tenant update should be published to the tenant's channel")
+	}
+
+	if d := rw.Explain(UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Tenant: "tenant1", Channel: "/casbin:tenant1"}); d != DispositionDelivered {
+		t.Errorf("Update for a hosted tenant should be delivered, received '%s' instead", d)
+	}
+	if d := rw.Explain(UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Tenant: "tenant2", Channel: "/casbin"}); d != DispositionOtherTenant {
+		t.Errorf("Update for another tenant should be ignored, received '%s' instead", d)
+	}
+	if d := rw.Explain(UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Channel: "/casbin"}); d != DispositionDelivered {
+		t.Errorf("Update without a tenant should be delivered, received '%s' instead", d)
+	}
+}
@@ -0,0 +1,31 @@
+package rediswatcher
+
+import (
+	"context"
+)
+
+// Tracer instruments publishing and receiving updates. StartPublish starts a
+// span for msg and injects its context into msg.Trace before the message is
+// published; StartReceive extracts the publisher's context from msg.Trace and
+// starts the receive span. The context returned by StartReceive is passed to
+// the update callback, so a reload continues the publisher's trace. The
+// returned func ends the span.
+//
+// The trace context only reaches other watchers with EnvelopeMessages, bare
+// updates are traced locally. The otel sub-package provides an OpenTelemetry
+// implementation.
+type Tracer interface {
+	StartPublish(ctx context.Context, msg *UpdateMessage) (context.Context, func(err error))
+	StartReceive(ctx context.Context, msg *UpdateMessage) (context.Context, func(err error))
+}
+
+// tracePublish runs publish inside a publish span for msg, started from ctx
+func (w *Watcher) tracePublish(ctx context.Context, msg *UpdateMessage, publish func() error) error {
+	if w.options.Tracer == nil {
+		return publish()
+	}
+	_, end := w.options.Tracer.StartPublish(ctx, msg)
+	err := publish()
+	end(err)
+	return err
+}
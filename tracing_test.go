@@ -0,0 +1,82 @@
+package rediswatcher
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rafaeljusto/redigomock"
+)
+
+type traceKey struct{}
+
+type testTracer struct {
+	published int
+	received  int
+	parent    interface{}
+}
+
+func (t *testTracer) StartPublish(ctx context.Context, msg *UpdateMessage) (context.Context, func(err error)) {
+	t.parent = ctx.Value(traceKey{})
+	msg.Trace = map[string]string{"traceparent": "00-trace-span-01"}
+	return ctx, func(error) { t.published++ }
+}
+
+func (t *testTracer) StartReceive(ctx context.Context, msg *UpdateMessage) (context.Context, func(err error)) {
+	return context.WithValue(ctx, traceKey{}, msg.Trace["traceparent"]), func(error) { t.received++ }
+}
+
+func TestWithTracer(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	tracer := &testTracer{}
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		EnvelopeMessages(true), WithTracer(tracer))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	var traceparent interface{}
+	rw.SetUpdateCallbackWithContext(func(ctx context.Context, s string) {
+		traceparent = ctx.Value(traceKey{})
+	})
+
+	c.Command("PUBLISH", "/casbin", redigomock.NewAnyData()).Expect("1")
+	msg := &UpdateMessage{Type: UpdateMessageType, Payload: "node1"}
+	if err := rw.publishMessage(context.Background(), msg); err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+	if tracer.published != 1 {
+		t.Errorf("Expected 1 publish span, received %d", tracer.published)
+	}
+
+	data, _ := encodeMessage(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Payload: "node2", Trace: msg.Trace})
+	rw.processMessage(decodeMessage("/casbin", data))
+	if tracer.received != 1 {
+		t.Errorf("Expected 1 receive span, received %d", tracer.received)
+	}
+	if traceparent != "00-trace-span-01" {
+		t.Errorf("Callback context should carry the publisher's trace, received '%v' instead", traceparent)
+	}
+}
+
+func TestUpdateWithContext(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	tracer := &testTracer{}
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		EnvelopeMessages(true), WithTracer(tracer))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+
+	c.Command("PUBLISH", "/casbin", redigomock.NewAnyData()).Expect("1")
+	ctx := context.WithValue(context.Background(), traceKey{}, "parent")
+	if err := w.(*Watcher).UpdateWithContext(ctx); err != nil {
+		t.Fatalf("Failed to update: %v", err)
+	}
+	if tracer.parent != "parent" {
+		t.Errorf("The publish span should be started from the caller's context, received '%v' instead", tracer.parent)
+	}
+}
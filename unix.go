@@ -0,0 +1,34 @@
+package rediswatcher
+
+import (
+	"errors"
+	"os"
+
+	"github.com/casbin/casbin/v2/persist"
+)
+
+var errNotSocket = errors.New("rediswatcher: not a unix socket")
+
+// NewUnixSocketWatcher creates a new Watcher connected to redis through the
+// unix domain socket at path, such as that of a sidecar redis
+//
+//	Example:
+//		w, err := rediswatcher.NewUnixSocketWatcher("/var/run/redis/redis.sock", rediswatcher.Channel("/yourchan"))
+func NewUnixSocketWatcher(path string, setters ...WatcherOption) (persist.Watcher, error) {
+	if err := checkSocket(path); err != nil {
+		return nil, err
+	}
+	return NewWatcher(path, append([]WatcherOption{Protocol("unix")}, setters...)...)
+}
+
+// checkSocket returns an error unless path is a unix domain socket
+func checkSocket(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return errNotSocket
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package rediswatcher
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewUnixSocketWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rediswatcher")
+	if err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := NewUnixSocketWatcher(filepath.Join(dir, "missing.sock")); !os.IsNotExist(err) {
+		t.Errorf("Missing socket should be rejected, received %v", err)
+	}
+	file := filepath.Join(dir, "redis.conf")
+	if err := ioutil.WriteFile(file, nil, 0600); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if _, err := NewUnixSocketWatcher(file); err != errNotSocket {
+		t.Errorf("Expected %v, received %v", errNotSocket, err)
+	}
+
+	path := filepath.Join(dir, "redis.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skipf("Unix sockets unavailable: %v", err)
+	}
+	defer l.Close()
+	w, err := NewUnixSocketWatcher(path, WithLogger(&testLogger{}))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	defer w.Close()
+	if w.(*Watcher).options.Protocol != "unix" {
+		t.Errorf("Watcher should connect through the unix protocol")
+	}
+}
@@ -0,0 +1,55 @@
+package rediswatcher
+
+import "errors"
+
+const defaultUpdateLogMaxLen = 1000
+
+var errNoUpdateLog = errors.New("rediswatcher: no update log configured")
+
+// appendLog appends data to the update log under the next log sequence number
+func (w *Watcher) appendLog(data string) error {
+	if w.options.UpdateLogKey == "" {
+		return nil
+	}
+	data, err := w.seal(data)
+	if err != nil {
+		return err
+	}
+	seq, err := w.storage.Incr(w.options.UpdateLogKey + ":seq")
+	if err != nil {
+		return err
+	}
+	return w.storage.LogAppend(w.options.UpdateLogKey, seq, []byte(data), w.options.UpdateLogMaxLen)
+}
+
+// ReplaySince returns the updates appended to the update log after seq,
+// oldest first, along with the sequence number of the last one, which is
+// passed to the next call. The sequence number of each update is reported by
+// its LogSeq. A node that starts with a policy loaded at a known
+// sequence number can apply the updates it missed before subscribing.
+// Payloads published by reference are logged in full.
+func (w *Watcher) ReplaySince(seq int64) ([]*UpdateMessage, int64, error) {
+	if w.options.UpdateLogKey == "" {
+		return nil, seq, errNoUpdateLog
+	}
+	entries, err := w.storage.LogSince(w.options.UpdateLogKey, seq)
+	if err != nil {
+		return nil, seq, err
+	}
+	msgs := make([]*UpdateMessage, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Seq > seq {
+			seq = entry.Seq
+		}
+		msg, err := w.open(w.options.Channel, entry.Value)
+		if err != nil {
+			return nil, seq, err
+		}
+		msg.logSeq = entry.Seq
+		if err := w.resolveReference(msg); err != nil {
+			return nil, seq, err
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, seq, nil
+}
@@ -0,0 +1,93 @@
+package rediswatcher
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rafaeljusto/redigomock"
+)
+
+func TestReplaySince(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		WithStorage(newMemoryStorage()), UpdateLogKey("casbin:log"), UpdateLogMaxLen(2))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	c.Command("PUBLISH", "/casbin", redigomock.NewAnyData()).Expect("1")
+	for i := 0; i < 3; i++ {
+		if err := w.Update(); err != nil {
+			t.Fatalf("Failed to publish: %v", err)
+		}
+	}
+
+	msgs, seq, err := rw.ReplaySince(0)
+	if err != nil {
+		t.Fatalf("Failed to replay: %v", err)
+	}
+	if len(msgs) != 2 || seq != 3 {
+		t.Fatalf("Expected the last 2 updates up to 3, received %d up to %d", len(msgs), seq)
+	}
+	if msgs[0].LocalID != "node1" {
+		t.Errorf("Replayed update should be from 'node1', received '%s' instead", msgs[0].LocalID)
+	}
+	if msgs[0].LogSeq() != 2 || msgs[1].LogSeq() != 3 {
+		t.Errorf("Replayed updates should be 2 and 3, received %d and %d", msgs[0].LogSeq(), msgs[1].LogSeq())
+	}
+	if payload := msgs[1].Payload; strings.HasPrefix(payload, "3:") {
+		t.Errorf("The payload should not carry the sequence number, received '%s'", payload)
+	}
+
+	msgs, seq, err = rw.ReplaySince(seq)
+	if err != nil || len(msgs) != 0 || seq != 3 {
+		t.Errorf("Expected no updates after 3, received %d up to %d (%v)", len(msgs), seq, err)
+	}
+}
+
+func TestReplaySinceReference(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	storage := newMemoryStorage()
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		WithStorage(storage), UpdateLogKey("casbin:log"), ReferenceThreshold(16), EnvelopeMessages(true))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	c.Command("PUBLISH", "/casbin", redigomock.NewAnyData()).Expect("1")
+	payload := strings.Repeat("p, alice, data1, read\n", 10)
+	if err := rw.UpdateWithPayload(payload); err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+
+	msgs, _, err := rw.ReplaySince(0)
+	if err != nil {
+		t.Fatalf("Failed to replay: %v", err)
+	}
+	if len(msgs) != 1 || msgs[0].Payload != payload || msgs[0].Ref != "" {
+		t.Fatalf("Replayed update should carry the payload published by reference, received %+v", msgs)
+	}
+
+	// entries logged with a reference are resolved
+	ref := &UpdateMessage{Type: UpdateMessageType, LocalID: "node1", Seq: 2, Payload: payload}
+	if err := rw.storeReference(ref); err != nil {
+		t.Fatalf("Failed to store reference: %v", err)
+	}
+	data, _ := rw.encode(ref)
+	if err := rw.appendLog(string(data)); err != nil {
+		t.Fatalf("Failed to append: %v", err)
+	}
+	msgs, _, err = rw.ReplaySince(1)
+	if err != nil {
+		t.Fatalf("Failed to replay: %v", err)
+	}
+	if len(msgs) != 1 || msgs[0].Payload != payload {
+		t.Errorf("Referenced payload should be resolved on replay, received %+v", msgs)
+	}
+}
@@ -0,0 +1,117 @@
+package rediswatcher
+
+import "fmt"
+
+// OptionError is returned by NewWatcher and NewPublishWatcher when the
+// options are invalid or can't be combined
+type OptionError struct {
+	Option string
+	Reason string
+}
+
+func (e *OptionError) Error() string {
+	return fmt.Sprintf("rediswatcher: invalid option %s: %s", e.Option, e.Reason)
+}
+
+// validate checks the options for values and combinations that would only
+// fail later at runtime, addr is the address passed to the constructor
+func (options *WatcherOptions) validate(addr string) error {
+	if options.Channel == "" && len(options.Channels) == 0 {
+		return &OptionError{"Channel", "the channel must not be empty"}
+	}
+	for _, channel := range options.Channels {
+		if channel == "" {
+			return &OptionError{"Channels", "channel names must not be empty"}
+		}
+	}
+	if addr == "" && len(options.Addresses) == 0 && options.SRVName == "" &&
+		options.PubConn == nil && options.SubConn == nil {
+		return &OptionError{"addr", "no redis address given and no connections injected with WithRedisPubConnection or WithRedisSubConnection"}
+	}
+
+	switch options.Transport {
+	case PubSubTransport, StreamTransport, KeyspaceTransport, PollTransport, DualTransport:
+	default:
+		return &OptionError{"WithTransport", fmt.Sprintf("unknown transport %q", options.Transport)}
+	}
+	switch options.QueueOverflow {
+	case OverflowBlock:
+	case OverflowDropOldest, OverflowCoalesce:
+		if options.QueueSize <= 0 {
+			return &OptionError{"QueueOverflow", fmt.Sprintf("%s requires a QueueSize", options.QueueOverflow)}
+		}
+	default:
+		return &OptionError{"QueueOverflow", fmt.Sprintf("unknown overflow policy %q", options.QueueOverflow)}
+	}
+
+	if options.Protocol == "unix" {
+		if options.ResolveDNS {
+			return &OptionError{"ResolveDNS", "a unix socket path can't be resolved through DNS"}
+		}
+		if options.SRVName != "" {
+			return &OptionError{"SRVName", "SRV records can't point to a unix socket"}
+		}
+	}
+
+	if options.SquashMessages && options.SquashTimeoutShort > options.SquashTimeoutLong {
+		return &OptionError{"SquashTimeoutShort", fmt.Sprintf("the short timeout %v exceeds the long timeout %v",
+			options.SquashTimeoutShort, options.SquashTimeoutLong)}
+	}
+	if options.OrderedDelivery && options.CallbackWorkers > 1 {
+		return &OptionError{"OrderedDelivery", "callbacks run on several CallbackWorkers can't keep the publish order"}
+	}
+	if options.VersionKey == "" {
+		if options.VersionPollInterval > 0 {
+			return &OptionError{"VersionPollInterval", "requires a VersionKey"}
+		}
+		if options.VersionBroadcastInterval > 0 {
+			return &OptionError{"VersionBroadcast", "requires a VersionKey"}
+		}
+	}
+	if options.ShardedPubSub && options.subscribedChannels() > 1 {
+		return &OptionError{"ShardedPubSub", "SSUBSCRIBE takes a single channel, the channels may belong to different shards"}
+	}
+	if options.RESP3 && options.Transport == StreamTransport {
+		return &OptionError{"RESP3", "the stream replies read by StreamTransport differ in RESP3"}
+	}
+	if options.IgnoreGroup && options.GroupID == "" {
+		return &OptionError{"IgnoreGroup", "requires a GroupID"}
+	}
+	if options.KeepAliveInterval > 0 && options.ReceiveTimeout > 0 && options.KeepAliveInterval >= options.ReceiveTimeout {
+		return &OptionError{"KeepAlive", fmt.Sprintf("the interval %v must be shorter than the ReceiveTimeout %v",
+			options.KeepAliveInterval, options.ReceiveTimeout)}
+	}
+
+	for _, n := range []struct {
+		option string
+		value  int
+	}{
+		{"QueueSize", options.QueueSize},
+		{"CallbackWorkers", options.CallbackWorkers},
+		{"PublishRetries", options.PublishRetries},
+		{"CircuitBreaker", options.CircuitThreshold},
+		{"Outbox", options.OutboxSize},
+		{"MaxReconnectAttempts", options.MaxReconnectAttempts},
+	} {
+		if n.value < 0 {
+			return &OptionError{n.option, fmt.Sprintf("must not be negative, got %d", n.value)}
+		}
+	}
+	return nil
+}
+
+// subscribedChannels returns the number of channels the watcher subscribes to
+// initially, see initChannels
+func (options *WatcherOptions) subscribedChannels() int {
+	n := len(options.Channels)
+	if n == 0 {
+		n = 1
+	}
+	if options.TenantChannels {
+		n += len(options.Tenants)
+	}
+	if options.ControlChannel != "" {
+		n++
+	}
+	return n
+}
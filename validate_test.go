@@ -0,0 +1,47 @@
+package rediswatcher
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	for _, test := range []struct {
+		addr    string
+		setters []WatcherOption
+		option  string
+	}{
+		{"127.0.0.1:6379", []WatcherOption{Channel("")}, "Channel"},
+		{"127.0.0.1:6379", []WatcherOption{Channels([]string{"/casbin", ""})}, "Channels"},
+		{"", nil, "addr"},
+		{"127.0.0.1:6379", []WatcherOption{WithTransport("carrier-pigeon")}, "WithTransport"},
+		{"127.0.0.1:6379", []WatcherOption{QueueOverflow("drop-everything")}, "QueueOverflow"},
+		{"127.0.0.1:6379", []WatcherOption{QueueOverflow(OverflowCoalesce)}, "QueueOverflow"},
+		{"/tmp/redis.sock", []WatcherOption{Protocol("unix"), ResolveDNS(true)}, "ResolveDNS"},
+		{"127.0.0.1:6379", []WatcherOption{SquashMessages(true), SquashTimeoutShort(time.Second), SquashTimeoutLong(time.Millisecond)}, "SquashTimeoutShort"},
+		{"127.0.0.1:6379", []WatcherOption{OrderedDelivery(true), CallbackWorkers(4)}, "OrderedDelivery"},
+		{"127.0.0.1:6379", []WatcherOption{IgnoreGroup(true)}, "IgnoreGroup"},
+		{"127.0.0.1:6379", []WatcherOption{KeepAlive(time.Minute), ReceiveTimeout(time.Second)}, "KeepAlive"},
+		{"127.0.0.1:6379", []WatcherOption{QueueSize(-1)}, "QueueSize"},
+		{"127.0.0.1:6379", []WatcherOption{VersionPollInterval(time.Second)}, "VersionPollInterval"},
+		{"127.0.0.1:6379", []WatcherOption{VersionBroadcast(time.Second)}, "VersionBroadcast"},
+		{"127.0.0.1:6379", []WatcherOption{ShardedPubSub(true), Channels([]string{"/casbin", "/casbin2"})}, "ShardedPubSub"},
+		{"127.0.0.1:6379", []WatcherOption{ShardedPubSub(true), ControlChannel("/casbin:control")}, "ShardedPubSub"},
+		{"127.0.0.1:6379", []WatcherOption{RESP3(true), WithTransport(StreamTransport)}, "RESP3"},
+	} {
+		_, err := newWatcher(test.addr, test.setters)
+		optionErr, ok := err.(*OptionError)
+		if !ok {
+			t.Errorf("Expected an OptionError for %s, received %v", test.option, err)
+			continue
+		}
+		if optionErr.Option != test.option {
+			t.Errorf("Expected an error for %s, received %v", test.option, optionErr)
+		}
+	}
+
+	c := NewTestConn()
+	if _, err := newWatcher("", []WatcherOption{WithRedisPubConnection(c)}); err != nil {
+		t.Errorf("Injected connections should not require an address: %v", err)
+	}
+}
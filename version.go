@@ -0,0 +1,66 @@
+package rediswatcher
+
+import (
+	"strconv"
+)
+
+// incrVersion bumps the remote policy version on Update and returns the new
+// version, or 0 when no VersionKey is configured
+func (w *Watcher) incrVersion() (int64, error) {
+	if w.options.VersionKey == "" {
+		return 0, nil
+	}
+	version, err := w.storage.Incr(w.options.VersionKey)
+	if err != nil {
+		return 0, err
+	}
+	w.seenVersion(version)
+	return version, nil
+}
+
+// seenVersion records that the policy at version was applied
+func (w *Watcher) seenVersion(version int64) {
+	w.versionMu.Lock()
+	defer w.versionMu.Unlock()
+	if version > w.version {
+		w.version = version
+	}
+}
+
+// catchUp compares the remote policy version with the last one seen after
+// the subscription was re-established and triggers a reload if updates were
+// published while the watcher was disconnected. The first call only records
+// the remote version.
+func (w *Watcher) catchUp() {
+	data, err := w.storage.Get(w.options.VersionKey)
+	if err != nil {
+		w.options.Logger.Error("Failure reading policy version", "channel", w.options.Channel, "localID", w.options.LocalID, "key", w.options.VersionKey, "error", err)
+		w.handleError(err)
+		return
+	}
+	var remote int64
+	if data != nil {
+		if remote, err = strconv.ParseInt(string(data), 10, 64); err != nil {
+			w.handleError(err)
+			return
+		}
+	}
+
+	if w.syncVersion(remote) {
+		w.options.Logger.Info("Updates missed while disconnected, reloading", "channel", w.options.Channel, "localID", w.options.LocalID, "version", remote)
+		w.triggerReload(w.options.LocalID)
+	}
+}
+
+// syncVersion records the remote policy version and reports whether it is
+// ahead of the last version seen. The first call only records it.
+func (w *Watcher) syncVersion(remote int64) bool {
+	w.versionMu.Lock()
+	defer w.versionMu.Unlock()
+	ahead := w.versionSynced && remote > w.version
+	w.versionSynced = true
+	if remote > w.version {
+		w.version = remote
+	}
+	return ahead
+}
@@ -0,0 +1,41 @@
+package rediswatcher
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVersionCatchUp(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	storage := newMemoryStorage()
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c),
+		WithStorage(storage), VersionKey("casbin:version"))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+	rw.reload = make(chan string, 1)
+
+	storage.Incr("casbin:version")
+	rw.catchUp()
+	if len(rw.reload) != 0 {
+		t.Fatal("First connect should only record the remote version")
+	}
+
+	rw.processMessage(&UpdateMessage{Type: UpdateMessageType, LocalID: "node2", Version: 2})
+	storage.Incr("casbin:version")
+	rw.catchUp()
+	if len(rw.reload) != 0 {
+		t.Fatal("Received updates should not trigger a reload")
+	}
+
+	storage.Incr("casbin:version")
+	rw.catchUp()
+	select {
+	case <-rw.reload:
+	case <-time.After(time.Second):
+		t.Fatal("Missed update should trigger a reload")
+	}
+}
@@ -1,50 +1,272 @@
 package rediswatcher
 
 import (
-	"runtime"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"fmt"
 
 	"github.com/casbin/casbin/v2/persist"
 	"github.com/garyburd/redigo/redis"
-	"github.com/google/uuid"
 )
 
 type Watcher struct {
-	options    WatcherOptions
-	pubConn    redis.Conn
-	subConn    redis.Conn
-	callback   func(string)
-	closed     chan struct{}
-	messagesIn chan redis.Message
-	once       sync.Once
+	options   WatcherOptions
+	pubConn   redis.Conn
+	pubMu     sync.Mutex
+	subConn   redis.Conn
+	subAddr   string
+	endpoints *endpointSelector
+	storage   Storage
+	breaker   *circuitBreaker
+	fanout    []*fanoutTarget
+
+	// allowedSenders and deniedSenders are the LocalIDs of AllowSenders
+	// and DenySenders
+	allowedSenders map[string]bool
+	deniedSenders  map[string]bool
+
+	// resolved counts the lookups with ResolveDNS, it selects the address
+	// dialed among the results
+	resolved uint32
+	// srvFailures counts the failed connections to SRVName targets, it
+	// selects the target dialed, srvTarget is the target dialed until a
+	// connection to it fails
+	srvFailures uint32
+	srvTarget   atomic.Value
+
+	// outbox keeps the messages that failed to publish until redis is
+	// reachable again
+	outboxMu sync.Mutex
+	outbox   []outboxEntry
+
+	callback func(context.Context, string)
+	// filteredCallback receives the filter of updates published with
+	// UpdateForFilter
+	filteredCallback func([]byte)
+	// domainCallback receives the domains of updates published with
+	// UpdateForDomains
+	domainCallback func([]string)
+	ordering       *reorderBuffer
+	closed         chan struct{}
+	messagesIn     chan *UpdateMessage
+	once           sync.Once
+	ready          chan struct{}
+	readyOnce      sync.Once
+
+	// addr is the address the watcher connects to on Start, subscriber is
+	// set for watchers created with NewWatcher
+	addr        string
+	subscriber  bool
+	lifecycleMu sync.Mutex
+	routines    sync.WaitGroup
+
+	subscribeErr chan error
+
+	connected         int32
+	reconnectAttempts int32
+	reauthing         int32
+	disconnectedAt    time.Time
+	escalated         bool
+	logLevel          int32
+	leader            int32
+	paused            int32
+	pausedMissed      int32
+	reload            chan string
+	flushes           chan chan struct{}
+	closeErr          error
+	// lastReceived is when the subscribed connection last received a
+	// reply, in unix nanoseconds, with KeepAlive
+	lastReceived int64
+	// leaderRenewed is when the leader lock was last acquired or renewed,
+	// in unix nanoseconds
+	leaderRenewed int64
+
+	// seqs is the last sequence number of the updates published on each
+	// channel
+	seqMu           sync.Mutex
+	seqs            map[string]uint64
+	chunkSeq        uint64
+	lastSeq         map[string]uint64
+	policyVersion   uint64
+	snapshotPending int32
+	// snapshotRequests counts the snapshot requests, so that a timeout
+	// only resets the request it was started for
+	snapshotRequests uint64
+	snapshotMu       sync.Mutex
+	snapshot         snapshotState
+
+	cancelInFlight context.CancelFunc
+	inFlight       chan []func()
+
+	stats   *watcherStats
+	metrics *metricsBatch
+
+	// shardedPubSub is set while updates go through sharded pub/sub, it is
+	// cleared when the server turns out not to support it
+	shardedPubSub int32
+
+	remoteAddr atomic.Value
+
+	streamID string
+	// liveSeq is the last sequence number of each sender received through
+	// pub/sub since the stream position was last read, with DualTransport
+	liveSeq map[string]uint64
+
+	chunks map[string]*partialMessage
+
+	versionMu     sync.Mutex
+	version       int64
+	versionSynced bool
+	versionValue  string
+	// versionAhead is the polled version not yet delivered by push, see
+	// missedPush
+	versionAhead int64
+
+	// callbacks registered per channel with RegisterCallback and per route
+	// with Route
+	callbacksMu      sync.Mutex
+	channelCallbacks map[string]func(string)
+	routeCallbacks   map[string]func(string)
+	commands         map[string]func(string) error
+	updateCallbacks  []updateCallback
+	lastHandle       uint64
+
+	// updates held back by squashing until the squash timeout
+	squash *squashState
+
+	// work queues callbacks for the CallbackWorkers
+	work []chan func()
+
+	// channels subscribed to, subscribing is set while the sub connection is
+	// subscribed so that AddChannel and RemoveChannel apply immediately
+	channelsMu  sync.Mutex
+	channels    []string
+	subscribing bool
+
+	// acks passes acknowledgements to UpdateAndWait by update ID
+	acksMu sync.Mutex
+	acks   map[string]*ackWaiter
+
+	// payloads processed within the DedupeWindow
+	dedupeMu   sync.Mutex
+	dedupeSeen map[[sha256.Size]byte]time.Time
 }
 
 type WatcherMetrics struct {
-	Name        string
-	LatencyMs   float64
-	LocalID     string
-	Channel     string
-	Protocol    string
-	Error       error
+	Name      string
+	LatencyMs float64
+	LocalID   string
+	Channel   string
+	Protocol  string
+	Error     error
+	// MessageSize is set on PubSubReceiveMetric and StreamReadMetric for
+	// the messages received, it is 0 for subscription confirmations
 	MessageSize int64
+
+	// Timestamp is when the operation started, MessageID identifies the
+	// envelope message published or received, see UpdateMessage.ID
+	Timestamp        time.Time
+	RemoteAddr       string
+	ReconnectAttempt int
+	MessageID        string
+
+	// set on RuntimeStatsMetric only
+	Goroutines       int
+	QueueDepth       int
+	QueueCapacity    int
+	QueueUtilization float64
+
+	// QueueLag is how long a message waited between being received and
+	// processed, it is set on QueueLagMetric with QueueDepth and
+	// QueueCapacity
+	QueueLag time.Duration
+
+	// Receivers is the number of subscribers a message was delivered to, it
+	// is set on PubSubPublishMetric, and the number of subscribers of the
+	// channel on SubscribersMetric
+	Receivers int64
 }
 
 const (
-	RedisDoAuthMetric       = "RedisDoAuth"
-	RedisCloseMetric        = "RedisClose"
-	RedisDialMetric         = "RedisDial"
-	PubSubPublishMetric     = "PubSubPublish"
-	PubSubReceiveMetric     = "PubSubReceive"
-	PubSubSubscribeMetric   = "PubSubSubscribe"
-	PubSubUnsubscribeMetric = "PubSubUnsubscribe"
+	RedisDoAuthMetric        = "RedisDoAuth"
+	RedisCloseMetric         = "RedisClose"
+	RedisDialMetric          = "RedisDial"
+	RedisPingMetric          = "RedisPing"
+	PubSubPublishMetric      = "PubSubPublish"
+	PubSubReceiveMetric      = "PubSubReceive"
+	PubSubSubscribeMetric    = "PubSubSubscribe"
+	PubSubUnsubscribeMetric  = "PubSubUnsubscribe"
+	ReconnectThresholdMetric = "ReconnectThreshold"
+	SnapshotWriteMetric      = "SnapshotWrite"
+	SnapshotLoadMetric       = "SnapshotLoad"
+	RuntimeStatsMetric       = "RuntimeStats"
+	SequenceGapMetric        = "SequenceGap"
+	SequenceDuplicateMetric  = "SequenceDuplicate"
+	UnexpectedChannelMetric  = "UnexpectedChannel"
+	SupersededCallbackMetric = "SupersededCallback"
+	SquashedMessageMetric    = "SquashedMessage"
+	IgnoredSelfMetric        = "IgnoredSelf"
+	IgnoredGroupMetric       = "IgnoredGroup"
+	DeniedSenderMetric       = "DeniedSender"
+	FilteredMessageMetric    = "FilteredMessage"
+	StreamAddMetric          = "StreamAdd"
+	StreamReadMetric         = "StreamRead"
+	StreamClaimMetric        = "StreamClaim"
+	PollMetric               = "Poll"
+	MissedPushMetric         = "MissedPush"
+	CallbackPanicMetric      = "CallbackPanic"
+	CallbackMetric           = "Callback"
+	DroppedMessageMetric     = "DroppedMessage"
+	QueueLagMetric           = "QueueLag"
+	DuplicateMessageMetric   = "DuplicateMessage"
+	SubscribersMetric        = "Subscribers"
+	HeartbeatMetric          = "Heartbeat"
+	CircuitOpenMetric        = "CircuitOpen"
+	FanoutPublishMetric      = "FanoutPublish"
+	DNSResolveMetric         = "DNSResolve"
+	KeepAliveTimeoutMetric   = "KeepAliveTimeout"
+)
+
+var (
+	// ErrWatcherClosed is returned when waiting on a watcher that was closed
+	ErrWatcherClosed = errors.New("rediswatcher: watcher closed")
+
+	errSubscribeTimeout = errors.New("rediswatcher: timed out waiting for subscription")
 )
 
+// ReconnectError is passed to the ReconnectFailureCallback when the watcher
+// stops reconnecting after MaxReconnectAttempts consecutive failures
+type ReconnectError struct {
+	Attempts int
+	Err      error
+}
+
+func (e *ReconnectError) Error() string {
+	return fmt.Sprintf("rediswatcher: giving up after %d reconnect attempts: %v", e.Attempts, e.Err)
+}
+
+// ReconnectThresholdError is passed to the ReconnectFailureCallback once the
+// watcher has been disconnected for longer than ReconnectThreshold
+type ReconnectThresholdError struct {
+	Disconnected time.Duration
+	Err          error
+}
+
+func (e *ReconnectThresholdError) Error() string {
+	return fmt.Sprintf("rediswatcher: disconnected for %v: %v", e.Disconnected, e.Err)
+}
+
 const (
 	defaultShortMessageInTimeout = 1 * time.Millisecond
 	defaultLongMessageInTimeout  = 1 * time.Minute
+	defaultSubscribeTimeout      = 10 * time.Second
+	defaultPublishRetryBackoff   = 100 * time.Millisecond
 )
 
 // NewWatcher creates a new Watcher to be used with a Casbin enforcer
@@ -61,77 +283,211 @@ const (
 // 				w, err := rediswatcher.NewWatcher("", rediswatcher.WithRedisConnection(c)
 //
 func NewWatcher(addr string, setters ...WatcherOption) (persist.Watcher, error) {
-	w := &Watcher{
-		closed:     make(chan struct{}),
-		messagesIn: make(chan redis.Message),
+	w, err := newWatcher(addr, setters)
+	if err != nil {
+		return nil, err
 	}
 
-	w.options = WatcherOptions{
-		Channel:            "/casbin",
-		Protocol:           "tcp",
-		LocalID:            uuid.New().String(),
-		SquashTimeoutShort: defaultShortMessageInTimeout,
-		SquashTimeoutLong:  defaultLongMessageInTimeout,
+	w.subscriber = true
+	w.messagesIn = make(chan *UpdateMessage, w.options.QueueSize)
+	w.reload = make(chan string)
+	w.flushes = make(chan chan struct{})
+	if w.options.OrderedDelivery {
+		w.ordering = newReorderBuffer(w.options.ReorderTimeout)
 	}
-
-	for _, setter := range setters {
-		setter(&w.options)
+	if err := w.start(); err != nil {
+		return nil, err
 	}
+	return w, nil
+}
+
+// start connects to redis and starts the watcher's goroutines, it is shared
+// by the constructors and Start
+func (w *Watcher) start() error {
+	w.closed = make(chan struct{})
+	w.ready = make(chan struct{})
+	w.once = sync.Once{}
+	w.readyOnce = sync.Once{}
+	w.closeErr = nil
+	atomic.StoreInt32(&w.connected, 0)
+	atomic.StoreInt32(&w.reconnectAttempts, 0)
+	w.disconnectedAt = time.Time{}
 
+	addr := w.addr
 	if err := w.connect(addr); err != nil {
-		return nil, err
+		w.once.Do(func() { close(w.closed) })
+		return err
+	}
+	if w.endpoints != nil && len(w.endpoints.addrs) > 1 && w.options.LatencyProbeInterval > 0 && !w.options.Failover {
+		w.background(w.probeEndpoints)
+	}
+	if w.options.MetricsSink != nil && w.options.MetricsFlushInterval > 0 {
+		w.background(w.flushMetrics)
+	}
+	if w.options.RecordMetrics != nil && w.options.RuntimeMetricsInterval > 0 {
+		w.background(w.emitRuntimeMetrics)
+	}
+	if w.options.ExpvarName != "" {
+		publishExpvar(w.options.ExpvarName, w.stats)
+	}
+	if w.options.HeartbeatInterval > 0 {
+		w.background(w.heartbeat)
+	}
+	if w.options.LeaderKey != "" {
+		w.background(w.elect)
+	}
+	if w.options.OutboxSize > 0 {
+		w.background(w.drainOutbox)
+	}
+	if provider, ok := w.options.CredentialsProvider.(ExpiringCredentialsProvider); ok {
+		w.background(func() { w.reauthenticate(provider) })
+	}
+	if w.options.VersionBroadcastInterval > 0 && w.options.VersionKey != "" {
+		w.background(w.broadcastVersions)
 	}
 
-	// call destructor when the object is released
-	runtime.SetFinalizer(w, finalizer)
+	if !w.subscriber {
+		atomic.StoreInt32(&w.connected, 1)
+		w.readyOnce.Do(func() { close(w.ready) })
+		if w.options.OnConnected != nil {
+			w.options.OnConnected(w.endpoint(addr))
+		}
+		return nil
+	}
 
+	if w.options.CallbackWorkers > 1 {
+		w.startWorkers()
+	}
 	w.messageInProcessor()
 
-	go func() {
-		for {
-			select {
-			case <-w.closed:
-				return
-			default:
-				err := w.connect(addr)
-				if err == nil {
-					err = w.subscribe()
-				}
-				if err != nil {
-					fmt.Printf("Failure from Redis subscription: %v\n", err)
-				}
-				time.Sleep(2 * time.Second)
-			}
+	if w.options.BlockUntilSubscribed {
+		w.subscribeErr = make(chan error, 1)
+	}
+	// send the initial SUBSCRIBE, or find the stream position, before
+	// returning. Updates the caller publishes on the pub connection may
+	// still reach redis before the SUBSCRIBE, WaitForReady waits until
+	// the subscription is confirmed.
+	if w.options.Transport == StreamTransport {
+		if w.options.StreamGroup == "" {
+			w.initStream()
+		} else if w.options.StreamClaimInterval > 0 {
+			w.background(w.claimPending)
 		}
-	}()
+		w.background(func() { w.subscribeLoop(addr, false) })
+	} else if w.options.Transport == PollTransport {
+		// the first poll records the current version
+		w.pollVersion()
+		atomic.StoreInt32(&w.connected, 1)
+		w.readyOnce.Do(func() { close(w.ready) })
+		w.background(func() { w.poll(w.options.PollInterval) })
+	} else {
+		if w.options.Transport == DualTransport {
+			// updates added after this position are read once the
+			// subscription is confirmed
+			w.initStream()
+		}
+		_, err := w.sendSubscribe()
+		w.background(func() { w.subscribeLoop(addr, err == nil) })
+	}
 
-	return w, nil
+	if w.options.VersionPollInterval > 0 && w.options.VersionKey != "" && w.options.Transport != PollTransport {
+		w.background(func() { w.poll(w.options.VersionPollInterval) })
+	}
+
+	if w.options.BlockUntilSubscribed {
+		if err := w.waitForSubscription(); err != nil {
+			w.close()
+			return err
+		}
+	}
+	return nil
+}
+
+// waitForSubscription blocks until the initial SUBSCRIBE is confirmed and
+// returns the error of the first failed attempt or SubscribeTimeout elapsing
+func (w *Watcher) waitForSubscription() error {
+	var timeout <-chan time.Time
+	if w.options.SubscribeTimeout > 0 {
+		timer := time.NewTimer(w.options.SubscribeTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-w.ready:
+		return nil
+	case err := <-w.subscribeErr:
+		return err
+	case <-timeout:
+		return errSubscribeTimeout
+	}
 }
 
 // NewPublishWatcher return a Watcher only publish but not subscribe
 func NewPublishWatcher(addr string, setters ...WatcherOption) (persist.Watcher, error) {
-	w := &Watcher{
-		closed: make(chan struct{}),
+	w, err := newWatcher(addr, setters)
+	if err != nil {
+		return nil, err
+	}
+	if err := w.start(); err != nil {
+		return nil, err
 	}
+	return w, nil
+}
 
-	w.options = WatcherOptions{
-		Channel:            "/casbin",
-		Protocol:           "tcp",
-		LocalID:            uuid.New().String(),
-		SquashTimeoutShort: defaultShortMessageInTimeout,
-		SquashTimeoutLong:  defaultLongMessageInTimeout,
+// newWatcher applies the options, it is shared by NewWatcher and
+// NewPublishWatcher
+func newWatcher(addr string, setters []WatcherOption) (*Watcher, error) {
+	w := &Watcher{
+		addr:    addr,
+		lastSeq: make(map[string]uint64),
+
+		squash:     newSquashState(),
+		dedupeSeen: make(map[[sha256.Size]byte]time.Time),
+		acks:       make(map[string]*ackWaiter),
+		stats:      &watcherStats{},
 	}
 
+	w.options = defaultWatcherOptions()
+
 	for _, setter := range setters {
 		setter(&w.options)
 	}
-
-	if err := w.connect(addr); err != nil {
+	w.initLocalID()
+	if w.versionTransport() && w.options.VersionKey == "" {
+		return nil, errNoVersionKey
+	}
+	if err := w.options.validate(addr); err != nil {
 		return nil, err
 	}
+	w.initEndpoints(addr)
+	w.initChannels()
+	w.initSenders()
+	if w.options.ControlChannel != "" {
+		w.initCommands()
+	}
+	if w.options.ShardedPubSub {
+		w.shardedPubSub = 1
+	}
 
-	// call destructor when the object is released
-	runtime.SetFinalizer(w, finalizer)
+	if w.options.CircuitThreshold > 0 {
+		w.breaker = newCircuitBreaker(w.options.CircuitThreshold, w.options.CircuitCooldown)
+	}
+	if len(w.options.FanoutAddresses) > 0 {
+		w.initFanout()
+	}
+
+	w.storage = w.options.Storage
+	if w.storage == nil {
+		w.storage = &redisStorage{do: w.pubDo}
+	}
+
+	if w.options.MetricsSink != nil {
+		w.initMetricsSink()
+	}
+	if w.options.RecordMetrics != nil && (w.options.MetricsFilter != nil || len(w.options.MetricsSampling) > 0) {
+		w.initMetricsFilter()
+	}
 
 	return w, nil
 }
@@ -139,33 +495,329 @@ func NewPublishWatcher(addr string, setters ...WatcherOption) (persist.Watcher,
 // SetUpdateCallBack sets the update callback function invoked by the watcher
 // when the policy is updated. Defaults to Enforcer.LoadPolicy()
 func (w *Watcher) SetUpdateCallback(callback func(string)) error {
-	w.callback = callback
+	w.callback = func(_ context.Context, data string) {
+		callback(data)
+	}
 	return nil
 }
 
 // Update publishes a message to all other casbin instances telling them to
 // invoke their update callback
 func (w *Watcher) Update() error {
+	return w.UpdateWithContext(context.Background())
+}
+
+// UpdateWithContext publishes an update like Update. The publish span of the
+// Tracer is started from ctx, so the update continues the caller's trace.
+func (w *Watcher) UpdateWithContext(ctx context.Context) error {
+	_, err := w.update(ctx)
+	return err
+}
+
+// UpdateWithResult publishes an update like Update and returns the number of
+// subscribers that received it, as reported by PUBLISH. Zero receivers
+// usually means that the other watchers subscribe to a different channel.
+// The number is -1 with the stream, keyspace and poll transports, where it is
+// unknown.
+func (w *Watcher) UpdateWithResult() (int64, error) {
+	return w.update(context.Background())
+}
+
+func (w *Watcher) update(ctx context.Context) (int64, error) {
+	version, err := w.incrVersion()
+	if err != nil {
+		return 0, err
+	}
+	if w.versionTransport() {
+		// bumping the version key notifies the other watchers
+		return -1, nil
+	}
+	if w.options.EnvelopeMessages {
+		msg := &UpdateMessage{
+			Type:    UpdateMessageType,
+			Version: version,
+			Payload: w.options.LocalID,
+		}
+		err := w.publishMessage(ctx, msg)
+		return msg.receivers, err
+	}
+	msg := &UpdateMessage{Type: UpdateMessageType, LocalID: w.options.LocalID}
+	err = w.tracePublish(ctx, msg, func() error {
+		if err := w.appendLog(w.options.LocalID); err != nil {
+			return err
+		}
+		var err error
+		msg.receivers, err = w.publish(w.options.Channel, w.options.LocalID, "")
+		return err
+	})
+	return msg.receivers, err
+}
+
+// UpdateWithPayload publishes an update carrying application data, such as
+// the reason of the change or a diff, instead of the LocalID. The update
+// callback of the other watchers receives payload. It is always published as
+// an envelope, so that the sender can still be identified.
+func (w *Watcher) UpdateWithPayload(payload string) error {
+	version, err := w.incrVersion()
+	if err != nil {
+		return err
+	}
+	return w.publishMessage(context.Background(), &UpdateMessage{
+		Type:    UpdateMessageType,
+		Version: version,
+		Payload: payload,
+	})
+}
+
+// publishMessage stamps msg with the LocalID and publishes it as an
+// envelope. Updates are also stamped with the next sequence number of their
+// channel unless set, other messages carry none. The publish span is started
+// from ctx.
+func (w *Watcher) publishMessage(ctx context.Context, msg *UpdateMessage) error {
+	msg.Schema = MessageSchemaVersion
+	msg.LocalID = w.options.LocalID
+	msg.Group = w.options.GroupID
+	if msg.Seq == 0 && msg.Type == UpdateMessageType {
+		msg.Seq = w.nextSeq(w.messageChannel(msg))
+	}
+	return w.tracePublish(ctx, msg, func() error {
+		payload := msg.Payload
+		if err := w.storeReference(msg); err != nil {
+			return err
+		}
+		data, err := w.encode(msg)
+		if err != nil {
+			return err
+		}
+		if msg.Type == UpdateMessageType {
+			// the log keeps the payload, the reference expires long before
+			// the entry is replayed
+			logged := data
+			if msg.Ref != "" {
+				full := *msg
+				full.Payload, full.Ref = payload, ""
+				if logged, err = w.encode(&full); err != nil {
+					return err
+				}
+			}
+			if err := w.appendLog(string(logged)); err != nil {
+				return err
+			}
+		}
+		if w.options.ChunkMessages && w.options.MaxMessageSize > 0 && len(data) > w.options.MaxMessageSize {
+			return w.publishChunks(msg, data)
+		}
+		msg.receivers, err = w.publish(w.messageChannel(msg), string(data), msg.ID())
+		return err
+	})
+}
+
+// nextSeq returns the next sequence number of the updates published on
+// channel. Receivers track the sequence per sender and channel, so that the
+// updates of every channel are numbered without gaps.
+func (w *Watcher) nextSeq(channel string) uint64 {
+	w.seqMu.Lock()
+	defer w.seqMu.Unlock()
+	if w.seqs == nil {
+		w.seqs = make(map[string]uint64)
+	}
+	w.seqs[channel]++
+	return w.seqs[channel]
+}
+
+// publish publishes data to channel and returns the number of subscribers
+// that received it, or -1 if unknown. id is the message ID reported with the
+// metric.
+func (w *Watcher) publish(channel string, data string, id string) (receivers int64, err error) {
+	data, err = w.seal(data)
+	if err != nil {
+		return 0, err
+	}
+	if w.options.MaxMessageSize > 0 && len(data) > w.options.MaxMessageSize {
+		return 0, ErrMessageTooLarge
+	}
+	if w.versionTransport() {
+		return 0, errVersionTransport
+	}
+
+	if w.options.OutboxSize > 0 {
+		defer func() {
+			if err != nil {
+				w.options.Logger.Warn("Failure publishing, keeping message in the outbox", "channel", w.options.Channel, "localID", w.options.LocalID, "error", err)
+				w.addOutbox(outboxEntry{channel: channel, data: data, id: id})
+				receivers, err = -1, nil
+			}
+		}()
+	}
+	if w.breaker != nil {
+		if !w.breaker.allow() {
+			return 0, ErrCircuitOpen
+		}
+		defer func() {
+			if w.breaker.record(err) {
+				w.options.Logger.Warn("Publishing failed repeatedly, opening circuit", "channel", w.options.Channel, "localID", w.options.LocalID, "cooldown", w.options.CircuitCooldown, "error", err)
+				if w.options.RecordMetrics != nil {
+					w.options.RecordMetrics(w.createMetrics(CircuitOpenMetric, time.Now(), err))
+				}
+			}
+		}()
+	}
+
+	receivers, err = w.publishOnce(channel, data, id)
+	backoff := w.options.PublishRetryBackoff
+	for attempt := 1; err != nil && attempt <= w.options.PublishRetries; attempt++ {
+		w.options.Logger.Warn("Failure publishing, retrying", "channel", w.options.Channel, "localID", w.options.LocalID, "attempt", attempt, "error", err)
+		select {
+		case <-w.closed:
+			return 0, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if err = w.reconnectPub(); err == nil {
+			receivers, err = w.publishOnce(channel, data, id)
+		}
+	}
+	if err == nil && len(w.fanout) > 0 && w.options.Transport != StreamTransport {
+		n := w.publishFanout(channel, data, id)
+		if receivers >= 0 {
+			receivers += n
+		}
+	}
+	return receivers, err
+}
+
+// publishOnce makes a single attempt to publish sealed data to channel
+func (w *Watcher) publishOnce(channel string, data string, id string) (int64, error) {
+	// acknowledgements on reply channels are not part of the update stream
+	if w.streamed() && !(w.replies() && strings.HasPrefix(channel, w.replyChannel(""))) {
+		if err := w.addStream(data, id); err != nil {
+			return 0, err
+		}
+		if w.options.Transport == StreamTransport {
+			atomic.AddUint64(&w.stats.published, 1)
+			return -1, nil
+		}
+	}
+
+	if w.options.MinSubscribers > 0 {
+		w.checkSubscribers(w.prefixed(channel))
+	}
+
 	startTime := time.Now()
-	if _, err := w.pubConn.Do("PUBLISH", w.options.Channel, w.options.LocalID); err != nil {
+	command := "PUBLISH"
+	if w.sharded() {
+		command = "SPUBLISH"
+	}
+	reply, err := w.pubDo(command, w.prefixed(channel), data)
+	if err != nil && command == "SPUBLISH" && w.shardFallback(err) {
+		reply, err = w.pubDo("PUBLISH", w.prefixed(channel), data)
+	}
+	receivers, ok := reply.(int64)
+	if !ok {
+		receivers = -1
+	}
+	if err != nil {
 		if w.options.RecordMetrics != nil {
-			w.options.RecordMetrics(w.createMetrics(PubSubPublishMetric, startTime, err))
+			m := w.createMetrics(PubSubPublishMetric, startTime, err)
+			m.MessageID = id
+			w.options.RecordMetrics(m)
 		}
-		return err
+		return 0, err
 	}
 	if w.options.RecordMetrics != nil {
-		w.options.RecordMetrics(w.createMetrics(PubSubPublishMetric, startTime, nil))
+		m := w.createMetrics(PubSubPublishMetric, startTime, nil)
+		m.MessageID = id
+		m.Receivers = receivers
+		w.options.RecordMetrics(m)
 	}
+	atomic.AddUint64(&w.stats.published, 1)
 
-	return nil
+	return receivers, nil
+}
+
+// pubDo runs a command on the publish connection, which is shared between
+// callers of Update and the watcher's own goroutines. When redis rejects the
+// credentials of the connection it is dialed again with fresh credentials
+// and the command retried once.
+func (w *Watcher) pubDo(commandName string, args ...interface{}) (interface{}, error) {
+	w.pubMu.Lock()
+	defer w.pubMu.Unlock()
+	reply, err := w.pubConn.Do(commandName, args...)
+	if authError(err) && w.options.PubConn == nil {
+		// dial again with fresh credentials
+		w.options.Logger.Warn("Redis rejected the credentials, reconnecting", "channel", w.options.Channel, "localID", w.options.LocalID, "error", err)
+		w.pubConn.Close()
+		if err := w.connectPub(w.addr); err != nil {
+			return nil, err
+		}
+		reply, err = w.pubConn.Do(commandName, args...)
+	}
+	return reply, err
+}
+
+// Ready returns a channel that is closed once the watcher's subscription has
+// been confirmed by redis for the first time
+func (w *Watcher) Ready() <-chan struct{} {
+	return w.ready
+}
+
+// WaitForReady blocks until the watcher's subscription has been confirmed, so
+// that updates published afterwards are not missed by this watcher. It
+// returns the context's error if ctx is done first.
+func (w *Watcher) WaitForReady(ctx context.Context) error {
+	select {
+	case <-w.ready:
+		return nil
+	case <-w.closed:
+		return ErrWatcherClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
-// Close disconnects the watcher from redis
+// IsConnected reports whether the watcher is connected to redis: the
+// subscription is established (for watchers created with NewWatcher) and the
+// publish connection has not failed
+func (w *Watcher) IsConnected() bool {
+	if atomic.LoadInt32(&w.connected) == 0 {
+		return false
+	}
+	w.pubMu.Lock()
+	defer w.pubMu.Unlock()
+	return w.pubConn != nil && w.pubConn.Err() == nil
+}
+
+// Ping sends a PING to redis over the publish connection and returns its
+// error, if any
+func (w *Watcher) Ping() error {
+	startTime := time.Now()
+	_, err := w.pubDo("PING")
+	if w.options.RecordMetrics != nil {
+		w.options.RecordMetrics(w.createMetrics(RedisPingMetric, startTime, err))
+	}
+	return err
+}
+
+// Close disconnects the watcher from redis, use Shutdown to process the
+// queued messages first and learn whether the connections closed cleanly
 func (w *Watcher) Close() {
-	finalizer(w)
+	w.close()
 }
 
 func (w *Watcher) connect(addr string) error {
+	w.pubMu.Lock()
+	defer w.pubMu.Unlock()
+
+	err := w.connectConns(addr)
+	for attempt := 1; err != nil && attempt < w.connectAttempts(); attempt++ {
+		err = w.connectConns(addr)
+	}
+	return err
+}
+
+// connectConns establishes the publish and subscribe connections unless they
+// are healthy
+func (w *Watcher) connectConns(addr string) error {
 	var pubConnErr error
 	if w.pubConn != nil {
 		pubConnErr = w.pubConn.Err()
@@ -195,22 +847,44 @@ func (w *Watcher) connectPub(addr string) error {
 		return nil
 	}
 
-	c, err := w.dial(addr)
+	pubAddr := w.endpoint(addr)
+	c, err := w.dial(pubAddr)
 	if err != nil {
+		w.endpointFailed(pubAddr, err)
 		return err
 	}
 	w.pubConn = *c
+	w.remoteAddr.Store(pubAddr)
 	return nil
 }
 
+// reconnectPub replaces the publish connection after a failed publish,
+// unless it was passed with WithRedisPubConnection
+func (w *Watcher) reconnectPub() error {
+	if w.options.PubConn != nil {
+		return nil
+	}
+	w.pubMu.Lock()
+	defer w.pubMu.Unlock()
+	if w.pubConn != nil {
+		w.pubConn.Close()
+	}
+	return w.connectPub(w.addr)
+}
+
 func (w *Watcher) connectSub(addr string) error {
 	if w.options.SubConn != nil {
 		w.subConn = w.options.SubConn
+		w.subAddr = addr
+		w.remoteAddr.Store(addr)
 		return nil
 	}
 
-	c, err := w.dial(addr)
+	w.subAddr = w.endpoint(addr)
+	w.remoteAddr.Store(w.subAddr)
+	c, err := w.dialConn(w.subAddr, w.options.RESP3)
 	if err != nil {
+		w.endpointFailed(w.subAddr, err)
 		return err
 	}
 	w.subConn = *c
@@ -218,8 +892,20 @@ func (w *Watcher) connectSub(addr string) error {
 }
 
 func (w *Watcher) dial(addr string) (*redis.Conn, error) {
+	return w.dialConn(addr, false)
+}
+
+// dialConn dials and authenticates a connection, which negotiates RESP3 if
+// resp3 is set
+func (w *Watcher) dialConn(addr string, resp3 bool) (*redis.Conn, error) {
 	startTime := time.Now()
-	c, err := redis.Dial(w.options.Protocol, addr)
+	dialAddr, err := w.resolve(addr)
+	var c redis.Conn
+	if err == nil && resp3 {
+		c, err = w.dialRESP3(w.options.Protocol, dialAddr)
+	} else if err == nil {
+		c, err = redis.Dial(w.options.Protocol, dialAddr, w.dialOptions()...)
+	}
 	if err != nil {
 		if w.options.RecordMetrics != nil {
 			w.options.RecordMetrics(w.createMetrics(RedisDialMetric, startTime, err))
@@ -229,76 +915,289 @@ func (w *Watcher) dial(addr string) (*redis.Conn, error) {
 	if w.options.RecordMetrics != nil {
 		w.options.RecordMetrics(w.createMetrics(RedisDialMetric, startTime, nil))
 	}
-	if w.options.Password != "" {
+	username, password, err := w.credentials()
+	if err == nil && password != "" {
 		startTime = time.Now()
 
 		// https://redis.io/commands/auth
-		username := "default"
-		if w.options.Username != "" {
-			username = w.options.Username
+		if username == "" {
+			username = "default"
 		}
 
-		_, err = c.Do("AUTH", username, w.options.Password)
-		if err != nil {
-			if w.options.RecordMetrics != nil {
-				w.options.RecordMetrics(w.createMetrics(RedisDoAuthMetric, startTime, err))
-			}
-			startTime = time.Now()
-			err2 := c.Close()
-			if w.options.RecordMetrics != nil {
-				w.options.RecordMetrics(w.createMetrics(RedisCloseMetric, startTime, err2))
-			}
-			return nil, err
+		_, err = c.Do("AUTH", username, password)
+		if w.options.RecordMetrics != nil {
+			w.options.RecordMetrics(w.createMetrics(RedisDoAuthMetric, startTime, err))
 		}
+	}
+	if err == nil && resp3 {
+		_, err = c.Do("HELLO", 3)
+	}
+	if err != nil {
+		startTime = time.Now()
+		err2 := c.Close()
 		if w.options.RecordMetrics != nil {
-			w.options.RecordMetrics(w.createMetrics(RedisDoAuthMetric, startTime, nil))
+			w.options.RecordMetrics(w.createMetrics(RedisCloseMetric, startTime, err2))
 		}
+		return nil, err
 	}
 	return &c, nil
 }
 
-func (w *Watcher) unsubscribe(psc redis.PubSubConn) {
+// subscribeLoop keeps the subscription alive, reconnecting after failures
+// until the watcher is closed or MaxReconnectAttempts is exceeded. subscribed
+// is set when NewWatcher already sent the initial SUBSCRIBE.
+func (w *Watcher) subscribeLoop(addr string, subscribed bool) {
+	for {
+		select {
+		case <-w.closed:
+			return
+		default:
+			var err error
+			if subscribed {
+				subscribed = false
+				err = w.receive(w.pubSub())
+			} else {
+				err = w.connect(addr)
+				if err == nil && w.options.Transport == StreamTransport && w.options.StreamGroup != "" {
+					err = w.readGroup()
+				} else if err == nil && w.options.Transport == StreamTransport {
+					err = w.readStream()
+				} else if err == nil {
+					err = w.subscribe()
+				}
+			}
+			if err != nil {
+				select {
+				case <-w.closed:
+					return
+				default:
+				}
+				if atomic.SwapInt32(&w.reauthing, 0) == 1 {
+					w.options.Logger.Debug("Resubscribing with fresh credentials", "channel", w.options.Channel, "localID", w.options.LocalID)
+					continue
+				}
+				w.options.Logger.Error("Failure from Redis subscription", "channel", w.options.Channel, "localID", w.options.LocalID, "attempt", atomic.LoadInt32(&w.reconnectAttempts)+1, "error", err)
+				w.handleError(err)
+				if authError(err) {
+					w.resetSub()
+				}
+				select {
+				case w.subscribeErr <- err:
+				default:
+				}
+				if atomic.SwapInt32(&w.connected, 0) == 1 && w.options.OnDisconnected != nil {
+					w.options.OnDisconnected(err)
+				}
+				if !w.reconnectFailed(err) {
+					return
+				}
+			}
+			select {
+			case <-w.closed:
+				return
+			case <-time.After(2 * time.Second):
+			}
+		}
+	}
+}
+
+// reconnectFailed reports a failed connection attempt, it returns false once
+// the watcher has given up reconnecting
+func (w *Watcher) reconnectFailed(err error) bool {
+	if w.disconnectedAt.IsZero() {
+		w.disconnectedAt = time.Now()
+	}
+	w.checkReconnectThreshold(err)
+
+	attempts := int(atomic.AddInt32(&w.reconnectAttempts, 1))
+	atomic.AddUint64(&w.stats.reconnects, 1)
+	if w.options.MaxReconnectAttempts > 0 && attempts >= w.options.MaxReconnectAttempts {
+		err = &ReconnectError{Attempts: attempts, Err: err}
+		w.options.Logger.Error("Giving up Redis subscription", "channel", w.options.Channel, "localID", w.options.LocalID, "attempt", attempts, "error", err)
+		w.handleError(err)
+		if w.options.ReconnectFailureCallback != nil {
+			w.options.ReconnectFailureCallback(err)
+		}
+		return false
+	}
+	if w.options.ReconnectFailureCallback != nil {
+		w.options.ReconnectFailureCallback(err)
+	}
+	return true
+}
+
+// handleError records a background failure and passes it to the
+// ErrorHandler, if set
+func (w *Watcher) handleError(err error) {
+	w.stats.errored(time.Now())
+	if w.options.ErrorHandler != nil {
+		w.options.ErrorHandler(err)
+	}
+}
+
+// checkReconnectThreshold escalates once per outage when the watcher has been
+// disconnected for longer than ReconnectThreshold
+func (w *Watcher) checkReconnectThreshold(err error) {
+	if w.options.ReconnectThreshold <= 0 || w.escalated {
+		return
+	}
+	disconnected := time.Since(w.disconnectedAt)
+	if disconnected < w.options.ReconnectThreshold {
+		return
+	}
+	w.escalated = true
+
+	err = &ReconnectThresholdError{Disconnected: disconnected, Err: err}
+	w.options.Logger.Warn("Redis subscription down longer than threshold", "channel", w.options.Channel, "localID", w.options.LocalID, "error", err)
+	w.handleError(err)
+	if w.options.RecordMetrics != nil {
+		w.options.RecordMetrics(w.createMetrics(ReconnectThresholdMetric, w.disconnectedAt, err))
+	}
+	if w.options.ReconnectFailureCallback != nil {
+		w.options.ReconnectFailureCallback(err)
+	}
+}
+
+// reconnected resets the outage state once a subscription is confirmed and,
+// if the outage was escalated, optionally triggers a full reload
+func (w *Watcher) reconnected() {
+	w.readyOnce.Do(func() { close(w.ready) })
+	if atomic.SwapInt32(&w.connected, 1) == 0 {
+		w.options.Logger.Info("Subscribed to Redis", "channel", w.options.Channel, "localID", w.options.LocalID, "addr", w.subAddr)
+		if w.options.OnConnected != nil {
+			w.options.OnConnected(w.subAddr)
+		}
+	}
+	atomic.StoreInt32(&w.reconnectAttempts, 0)
+	w.disconnectedAt = time.Time{}
+	if w.options.Transport == DualTransport {
+		w.catchUpStream()
+	}
+	if w.options.VersionKey != "" {
+		w.catchUp()
+	}
+	if !w.escalated {
+		return
+	}
+	w.escalated = false
+	if w.options.ReloadOnRecovery {
+		w.triggerReload(w.options.LocalID)
+	}
+}
+
+// triggerReload hands data to the message processor which invokes the
+// update callback with it, bypassing squashing and IgnoreSelf
+func (w *Watcher) triggerReload(data string) {
+	select {
+	case w.reload <- data:
+	case <-w.closed:
+	}
+}
+
+func (w *Watcher) unsubscribe(psc pubSubConn) {
 	startTime := time.Now()
-	err := psc.Unsubscribe()
+	err := w.unsubscribeAll(psc)
 	if w.options.RecordMetrics != nil {
 		w.options.RecordMetrics(w.createMetrics(PubSubUnsubscribeMetric, startTime, err))
 	}
 }
 
 func (w *Watcher) subscribe() error {
-	psc := redis.PubSubConn{Conn: w.subConn}
+	psc, err := w.sendSubscribe()
+	if err != nil {
+		return err
+	}
+	return w.receive(psc)
+}
+
+// sendSubscribe sends SUBSCRIBE, or SSUBSCRIBE with ShardedPubSub, on the sub
+// connection, the confirmation is read by receive
+func (w *Watcher) sendSubscribe() (pubSubConn, error) {
+	psc := w.pubSub()
 	startTime := time.Now()
-	if err := psc.Subscribe(w.options.Channel); err != nil {
+	if err := w.subscribeAll(psc); err != nil {
 		if w.options.RecordMetrics != nil {
 			w.options.RecordMetrics(w.createMetrics(PubSubSubscribeMetric, startTime, err))
 		}
-		return err
+		return psc, err
 	}
 	if w.options.RecordMetrics != nil {
 		w.options.RecordMetrics(w.createMetrics(PubSubSubscribeMetric, startTime, nil))
 	}
-	defer w.unsubscribe(psc)
+	return psc, nil
+}
+
+// receive reads from a subscribed connection until it fails or all channels
+// are unsubscribed
+func (w *Watcher) receive(psc pubSubConn) error {
+	defer func() { w.unsubscribe(psc) }()
+
+	keepAlive := w.keepAliveInterval() > 0
+	if keepAlive {
+		stop := make(chan struct{})
+		defer close(stop)
+		atomic.StoreInt64(&w.lastReceived, time.Now().UnixNano())
+		conn := w.subConn
+		w.background(func() { w.keepAlive(psc, conn, stop) })
+	}
 
 	for {
 		startTime := time.Now()
-		msg := psc.Receive()
+		var msg interface{}
+		if w.options.ReceiveTimeout > 0 {
+			msg = psc.ReceiveWithTimeout(w.options.ReceiveTimeout)
+		} else {
+			msg = psc.Receive()
+		}
+		if keepAlive {
+			atomic.StoreInt64(&w.lastReceived, time.Now().UnixNano())
+		}
 		switch n := msg.(type) {
 		case error:
+			if _, ok := psc.(shardedPubSubConn); ok && w.shardFallback(n) {
+				// SSUBSCRIBE was rejected, subscribe on the same connection
+				psc = w.pubSub()
+				if err := w.subscribeAll(psc); err != nil {
+					return err
+				}
+				continue
+			}
 			if w.options.RecordMetrics != nil {
 				w.options.RecordMetrics(w.createMetrics(PubSubReceiveMetric, startTime, n))
 			}
 			return n
 		case redis.Message:
+			var in *UpdateMessage
+			var err error
+			if w.options.Transport == KeyspaceTransport {
+				in = w.keyspaceMessage(n.Data)
+			} else {
+				in, err = w.open(strings.TrimPrefix(n.Channel, w.options.ChannelPrefix), n.Data)
+			}
+			if err != nil {
+				w.options.Logger.Error("Failure decrypting message", "channel", w.options.Channel, "localID", w.options.LocalID, "error", err)
+				w.handleError(err)
+				continue
+			}
 			if w.options.RecordMetrics != nil {
 				watcherMetrics := w.createMetrics(PubSubReceiveMetric, startTime, nil)
 				watcherMetrics.MessageSize = int64(len(n.Data))
+				watcherMetrics.MessageID = in.ID()
 				w.options.RecordMetrics(watcherMetrics)
 			}
-			w.messagesIn <- msg.(redis.Message)
+			if w.options.Transport == DualTransport {
+				w.receivedLive(in)
+			}
+			w.stats.receivedAt(time.Now())
+			w.enqueue(in)
 		case redis.Subscription:
 			if w.options.RecordMetrics != nil {
 				w.options.RecordMetrics(w.createMetrics(PubSubReceiveMetric, startTime, nil))
 			}
+			if n.Kind == "subscribe" && n.Count == 1 {
+				// the first of the channels is confirmed
+				w.reconnected()
+			}
 			if n.Count == 0 {
 				return nil
 			}
@@ -308,74 +1207,270 @@ func (w *Watcher) subscribe() error {
 }
 
 func (w *Watcher) messageInProcessor() {
-	w.options.callbackPending = false
-	var data string
-	timeOut := w.options.SquashTimeoutLong
-	go func() {
+	w.background(func() {
 		for {
+			timeOut := w.squash.timeout(w.options.SquashTimeoutShort, w.options.SquashTimeoutLong, w.options.SquashMaxDelay)
+			var reorderTimeout <-chan time.Time
+			if w.ordering != nil {
+				reorderTimeout = w.ordering.timer(time.Now())
+			}
+
 			select {
 			case <-w.closed:
+				if w.cancelInFlight != nil {
+					w.cancelInFlight()
+				}
 				return
+			case reload := <-w.reload:
+				w.reloadNow(reload)
 			case msg := <-w.messagesIn:
-				if w.callback != nil {
-					data = string(msg.Data)
-
-					switch {
-					case !w.options.IgnoreSelf && !w.options.SquashMessages:
-						w.callback(data)
-					case w.options.IgnoreSelf && data == w.options.LocalID: // ignore message
-					case !w.options.IgnoreSelf && w.options.SquashMessages:
-						w.options.callbackPending = true
-					case w.options.IgnoreSelf && data != w.options.LocalID && !w.options.SquashMessages:
-						w.callback(data)
-					case w.options.IgnoreSelf && data != w.options.LocalID && w.options.SquashMessages:
-						w.options.callbackPending = true
-					default:
-						w.callback(data)
-					}
+				w.receiveMessage(msg)
+			case done := <-w.flushes:
+				w.flushQueue()
+				close(done)
+			case <-reorderTimeout:
+				ready, skipped := w.ordering.expire(time.Now())
+				if skipped > 0 && w.options.RecordMetrics != nil {
+					w.options.RecordMetrics(w.createMetrics(SequenceGapMetric, time.Now(), nil))
 				}
-				if w.options.callbackPending { // set short timeout
-					timeOut = w.options.SquashTimeoutShort
+				for _, msg := range ready {
+					w.processMessage(msg)
 				}
 			case <-time.After(timeOut):
-				if w.options.callbackPending {
-					w.options.callbackPending = false
-					w.callback(data)                      // data will be last message recieved
-					timeOut = w.options.SquashTimeoutLong // long timeout
+				if w.squash.isPending(false) {
+					w.flushSquashed() // data will be last message recieved
 				}
 			}
 		}
-	}()
+	})
 }
 
-func (w *Watcher) createMetrics(metricsName string, startTime time.Time, err error) *WatcherMetrics {
-	return &WatcherMetrics{
-		Name:      metricsName,
-		Channel:   w.options.Channel,
-		LocalID:   w.options.LocalID,
-		Protocol:  w.options.Protocol,
-		LatencyMs: float64(time.Since(startTime)) / float64(time.Millisecond),
-		Error:     err,
+// processMessage hands a received message to the snapshot handling, the
+// update callback or the squash window according to its disposition
+func (w *Watcher) processMessage(msg *UpdateMessage) {
+	w.recordLag(msg)
+	// stream entries are acknowledged once the callback completed, ack is
+	// handed on with the callback or cleared when it is taken care of
+	// elsewhere
+	var ack func()
+	if len(msg.streamIDs) > 0 {
+		ack = func() { w.ackStreams(msg.streamIDs) }
+		defer func() {
+			if ack != nil {
+				ack()
+			}
+		}()
 	}
+	ctx := context.Background()
+	if w.options.Tracer != nil {
+		var end func(error)
+		ctx, end = w.options.Tracer.StartReceive(ctx, msg)
+		defer end(nil)
+	}
+
+	if err := w.resolveReference(msg); err != nil {
+		w.options.Logger.Error("Failure fetching referenced payload", "channel", w.options.Channel, "localID", w.options.LocalID, "key", msg.Ref, "error", err)
+		w.handleError(err)
+		return
+	}
+	if !w.transform(msg) {
+		return
+	}
+
+	disposition := w.disposition(msg)
+	switch disposition {
+	case DispositionRejected, DispositionDeniedSender, DispositionUnsupported:
+		// not part of the update stream of a trusted sender
+	default:
+		w.trackSequence(msg)
+	}
+	switch disposition {
+	case DispositionControl:
+		if msg.Type == ChunkMessageType {
+			// acknowledged with the reassembled message
+			ack = nil
+		}
+		w.handleControlMessage(msg)
+		return
+	case DispositionRejected:
+		if w.options.RecordMetrics != nil {
+			m := w.createMetrics(UnexpectedChannelMetric, time.Now(), nil)
+			m.Channel = msg.Channel
+			m.MessageID = msg.ID()
+			w.options.RecordMetrics(m)
+		}
+		return
+	case DispositionDeniedSender:
+		if w.options.RecordMetrics != nil {
+			m := w.createMetrics(DeniedSenderMetric, time.Now(), nil)
+			m.MessageID = msg.ID()
+			w.options.RecordMetrics(m)
+		}
+		return
+	case DispositionUnsupported:
+		w.options.Logger.Debug("Ignoring message of unknown type", "channel", w.options.Channel, "localID", w.options.LocalID, "type", msg.Type, "schema", msg.Schema)
+		return
+	case DispositionOtherTenant:
+		return
+	case DispositionFiltered:
+		if w.options.RecordMetrics != nil {
+			m := w.createMetrics(FilteredMessageMetric, time.Now(), nil)
+			m.MessageID = msg.ID()
+			w.options.RecordMetrics(m)
+		}
+		return
+	case DispositionPaused:
+		atomic.StoreInt32(&w.pausedMissed, 1)
+		return
+	case DispositionDuplicate:
+		if w.options.RecordMetrics != nil {
+			m := w.createMetrics(DuplicateMessageMetric, time.Now(), nil)
+			m.MessageID = msg.ID()
+			w.options.RecordMetrics(m)
+		}
+		return
+	case DispositionIgnoredSelf:
+		atomic.AddUint64(&w.stats.ignoredSelf, 1)
+		if w.options.RecordMetrics != nil {
+			m := w.createMetrics(IgnoredSelfMetric, time.Now(), nil)
+			m.MessageID = msg.ID()
+			w.options.RecordMetrics(m)
+		}
+	case DispositionIgnoredGroup:
+		if w.options.RecordMetrics != nil {
+			m := w.createMetrics(IgnoredGroupMetric, time.Now(), nil)
+			m.MessageID = msg.ID()
+			w.options.RecordMetrics(m)
+		}
+	}
+	w.remember(msg)
+	atomic.AddUint64(&w.policyVersion, 1)
+	if msg.Version > 0 {
+		w.seenVersion(msg.Version)
+	}
+	// routed updates only reach the callback registered for their route
+	routeCallback := w.routeCallback(msg.Route)
+	if msg.Route != "" && routeCallback == nil {
+		return
+	}
+	channelCallback := w.channelCallback(msg.Channel)
+	if !w.hasCallback() && routeCallback == nil && channelCallback == nil && w.filteredCallback == nil && w.domainCallback == nil {
+		return
+	}
+
+	switch disposition {
+	case DispositionDelivered:
+		var callback func()
+		if routeCallback != nil {
+			callback = func() { routeCallback(msg.Payload) }
+		} else if channelCallback != nil {
+			callback = func() { channelCallback(msg.Payload) }
+		} else if msg.Filter != "" && w.filteredCallback != nil {
+			callback = func() { w.filteredCallback([]byte(msg.Filter)) }
+		} else if len(msg.Domains) > 0 && w.domainCallback != nil {
+			callback = func() { w.domainCallback(msg.Domains) }
+		} else if msg.AckRequested {
+			callback = func() { w.invokeCallbacks(ctx, msg.Payload) }
+		} else {
+			w.deliverThen(ctx, msg.LocalID, msg.Payload, ack)
+			ack = nil
+			return
+		}
+		if msg.AckRequested {
+			invoke := callback
+			callback = func() {
+				invoke()
+				w.ack(msg)
+			}
+		}
+		if ack != nil {
+			invoke, done := callback, ack
+			callback = func() {
+				invoke()
+				done()
+			}
+			ack = nil
+		}
+		w.dispatch(msg.LocalID, callback)
+	case DispositionSquashed:
+		atomic.AddUint64(&w.stats.squashed, 1)
+		if w.options.RecordMetrics != nil {
+			m := w.createMetrics(SquashedMessageMetric, time.Now(), nil)
+			m.MessageID = msg.ID()
+			w.options.RecordMetrics(m)
+		}
+		var count int
+		if routeCallback != nil {
+			count = w.squash.add(squashRoute, msg.Route, msg.Payload)
+		} else if channelCallback != nil {
+			count = w.squash.add(squashChannel, msg.Channel, msg.Payload)
+		} else if w.options.SquashPerSender {
+			count = w.squash.add(squashSender, msg.LocalID, msg.Payload)
+		} else {
+			count = w.squash.add(squashDefault, "", msg.Payload)
+		}
+		// acknowledged once the squashed callbacks completed
+		w.squash.addStreamIDs(msg.streamIDs)
+		ack = nil
+		if w.options.SquashMaxCount > 0 && count >= w.options.SquashMaxCount {
+			w.flushSquashed()
+		}
+	}
+}
+
+func (w *Watcher) createMetrics(metricsName string, startTime time.Time, err error) *WatcherMetrics {
+	remoteAddr, _ := w.remoteAddr.Load().(string)
+	m := w.newMetrics()
+	m.Name = metricsName
+	m.Channel = w.options.Channel
+	m.LocalID = w.options.LocalID
+	m.Protocol = w.options.Protocol
+	m.LatencyMs = float64(time.Since(startTime)) / float64(time.Millisecond)
+	m.Error = err
+	m.Timestamp = startTime
+	m.RemoteAddr = remoteAddr
+	m.ReconnectAttempt = int(atomic.LoadInt32(&w.reconnectAttempts))
+	return m
 }
 
-// return option settings
-func (w *Watcher) GetWatcherOptions() WatcherOptions {
-	return w.options
+// GetWatcherOptions returns a copy of the option settings without
+// connections, callbacks or secrets
+func (w *Watcher) GetWatcherOptions() WatcherOptionsSnapshot {
+	return w.options.snapshot()
 }
 
-func finalizer(w *Watcher) {
+// close closes the connections once and returns the first error closing
+// them
+func (w *Watcher) close() error {
 	w.once.Do(func() {
 		close(w.closed)
+		if w.options.ExpvarName != "" {
+			unpublishExpvar(w.options.ExpvarName, w.stats)
+		}
+		if w.options.HeartbeatInterval > 0 {
+			w.removePresence()
+		}
+		if w.IsLeader() {
+			w.resign()
+		}
 		startTime := time.Now()
 		err := w.subConn.Close()
 		if w.options.RecordMetrics != nil {
 			w.options.RecordMetrics(w.createMetrics(RedisCloseMetric, startTime, err))
 		}
+		w.closeErr = err
 		startTime = time.Now()
 		err = w.pubConn.Close()
 		if w.options.RecordMetrics != nil {
 			w.options.RecordMetrics(w.createMetrics(RedisCloseMetric, startTime, err))
 		}
+		if w.closeErr == nil {
+			w.closeErr = err
+		}
+		w.closeFanout()
+		if w.metrics != nil {
+			w.metrics.flush()
+		}
 	})
+	return w.closeErr
 }
@@ -1,6 +1,8 @@
 package rediswatcher
 
 import (
+	"context"
+	"errors"
 	"testing"
 	"time"
 
@@ -242,3 +244,363 @@ func TestWithEnforcerIgnoreSelf(t *testing.T) {
 	case <-time.After(time.Millisecond * 50):
 	}
 }
+
+func TestMaxReconnectAttempts(t *testing.T) {
+	// setup mock redis without a SUBSCRIBE reply so subscribing fails
+	c := NewTestConn()
+	c.Clear()
+
+	errs := make(chan error, 1)
+	_, err := NewWatcher("127.0.0.1:6379", WithRedisSubConnection(c), WithRedisPubConnection(c),
+		MaxReconnectAttempts(1), ReconnectFailureCallback(func(err error) {
+			errs <- err
+		}))
+	if err != nil {
+		t.Fatalf("Failed to connect to Redis: %v", err)
+	}
+
+	select {
+	case err := <-errs:
+		if re, ok := err.(*ReconnectError); !ok || re.Attempts != 1 {
+			t.Fatalf("Expected a *ReconnectError after 1 attempt, received '%v' instead", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("ReconnectFailureCallback was not invoked")
+	}
+}
+
+func TestReconnectThreshold(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	var errs []error
+	var metrics []*WatcherMetrics
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c),
+		ReconnectThreshold(time.Millisecond),
+		ReconnectFailureCallback(func(err error) {
+			errs = append(errs, err)
+		}),
+		RecordMetrics(func(m *WatcherMetrics) {
+			metrics = append(metrics, m)
+		}))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	rw.reconnectFailed(errors.New("connection refused"))
+	time.Sleep(2 * time.Millisecond)
+	rw.reconnectFailed(errors.New("connection refused"))
+	rw.reconnectFailed(errors.New("connection refused"))
+
+	escalations := 0
+	for _, err := range errs {
+		if _, ok := err.(*ReconnectThresholdError); ok {
+			escalations++
+		}
+	}
+	if escalations != 1 {
+		t.Errorf("Outage should be escalated once, escalated %d times", escalations)
+	}
+	if len(metrics) != 1 || metrics[0].Name != ReconnectThresholdMetric {
+		t.Errorf("Expected a single %s metric, received %v", ReconnectThresholdMetric, metrics)
+	}
+
+	rw.reconnected()
+	if rw.escalated || !rw.disconnectedAt.IsZero() {
+		t.Error("Reconnecting should reset the outage state")
+	}
+}
+
+func TestConnectionCallbacks(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	c.ReceiveWait = true
+
+	subValues := []interface{}{}
+	subValues = append(subValues, interface{}([]byte("subscribe")))
+	subValues = append(subValues, interface{}([]byte("/casbin")))
+	subValues = append(subValues, interface{}([]byte("1")))
+	c.Command("SUBSCRIBE", "/casbin").Expect(subValues)
+
+	connected := make(chan string, 1)
+	disconnected := make(chan error, 1)
+	w, err := NewWatcher("127.0.0.1:6379", WithRedisSubConnection(c), WithRedisPubConnection(c),
+		OnConnected(func(addr string) {
+			connected <- addr
+		}),
+		OnDisconnected(func(err error) {
+			disconnected <- err
+		}))
+	if err != nil {
+		t.Fatalf("Failed to connect to Redis: %v", err)
+	}
+	defer w.Close()
+
+	c.ReceiveNow <- true
+	select {
+	case addr := <-connected:
+		if addr != "127.0.0.1:6379" {
+			t.Errorf("Connected address should be '127.0.0.1:6379', received '%s' instead", addr)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("OnConnected was not invoked")
+	}
+
+	// no more messages, the receive fails
+	c.ReceiveNow <- true
+	select {
+	case <-disconnected:
+	case <-time.After(time.Second * 5):
+		t.Fatal("OnDisconnected was not invoked")
+	}
+}
+
+func TestHealth(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	if !rw.IsConnected() {
+		t.Error("Watcher should be connected")
+	}
+
+	c.Command("PING").Expect("PONG")
+	if err := rw.Ping(); err != nil {
+		t.Errorf("Ping should succeed, received '%v' instead", err)
+	}
+
+	c.Command("PING").ExpectError(errors.New("connection reset"))
+	if err := rw.Ping(); err == nil {
+		t.Error("Ping should fail")
+	}
+
+	c.ErrMock = func() error {
+		return errors.New("connection reset")
+	}
+	if rw.IsConnected() {
+		t.Error("Watcher with a failed connection should not be connected")
+	}
+}
+
+func TestWaitForReady(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	c.ReceiveWait = true
+
+	subValues := []interface{}{}
+	subValues = append(subValues, interface{}([]byte("subscribe")))
+	subValues = append(subValues, interface{}([]byte("/casbin")))
+	subValues = append(subValues, interface{}([]byte("1")))
+	c.Command("SUBSCRIBE", "/casbin").Expect(subValues)
+
+	w, err := NewWatcher("127.0.0.1:6379", WithRedisSubConnection(c), WithRedisPubConnection(c))
+	if err != nil {
+		t.Fatalf("Failed to connect to Redis: %v", err)
+	}
+	rw := w.(*Watcher)
+	defer w.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := rw.WaitForReady(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Watcher should not be ready before SUBSCRIBE is confirmed, received '%v'", err)
+	}
+
+	go func() {
+		c.ReceiveNow <- true
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := rw.WaitForReady(ctx); err != nil {
+		t.Fatalf("Watcher should be ready, received '%v' instead", err)
+	}
+}
+
+func TestBlockUntilSubscribed(t *testing.T) {
+	// without a SUBSCRIBE reply the initial subscription fails
+	c := NewTestConn()
+	c.Clear()
+	if _, err := NewWatcher("127.0.0.1:6379", WithRedisSubConnection(c), WithRedisPubConnection(c), BlockUntilSubscribed(true)); err == nil {
+		t.Error("Failed subscription should fail NewWatcher")
+	}
+
+	c = NewTestConn()
+	c.Clear()
+	subValues := []interface{}{}
+	subValues = append(subValues, interface{}([]byte("subscribe")))
+	subValues = append(subValues, interface{}([]byte("/casbin")))
+	subValues = append(subValues, interface{}([]byte("1")))
+	c.Command("SUBSCRIBE", "/casbin").Expect(subValues)
+
+	w, err := NewWatcher("127.0.0.1:6379", WithRedisSubConnection(c), WithRedisPubConnection(c), BlockUntilSubscribed(true))
+	if err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+	w.Close()
+}
+
+type testLogger struct {
+	errors []string
+}
+
+func (l *testLogger) Debug(msg string, keyvals ...interface{}) {}
+func (l *testLogger) Info(msg string, keyvals ...interface{})  {}
+func (l *testLogger) Warn(msg string, keyvals ...interface{})  {}
+func (l *testLogger) Error(msg string, keyvals ...interface{}) {
+	l.errors = append(l.errors, formatLog(msg, keyvals))
+}
+
+func TestWithLogger(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	logger := &testLogger{}
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		MaxReconnectAttempts(1), WithLogger(logger))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	rw.reconnectFailed(errors.New("connection refused"))
+	if len(logger.errors) != 1 {
+		t.Fatalf("Expected 1 error to be logged, received %d", len(logger.errors))
+	}
+	expected := "Giving up Redis subscription channel=/casbin localID=node1 attempt=1 error=rediswatcher: giving up after 1 reconnect attempts: connection refused"
+	if logger.errors[0] != expected {
+		t.Errorf("Logged error should be '%s', received '%s' instead", expected, logger.errors[0])
+	}
+}
+
+func TestWithErrorHandler(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+
+	var handled []error
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		MaxReconnectAttempts(1), WithErrorHandler(func(err error) {
+			handled = append(handled, err)
+		}))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	rw.reconnectFailed(errors.New("connection refused"))
+	if len(handled) != 1 {
+		t.Fatalf("Expected 1 error to be handled, received %d", len(handled))
+	}
+	if _, ok := handled[0].(*ReconnectError); !ok {
+		t.Errorf("Handled error should be a *ReconnectError, received %T instead", handled[0])
+	}
+}
+
+func TestUpdateWithPayload(t *testing.T) {
+	c := &publishConn{testConn: NewTestConn()}
+	c.Clear()
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	if err := rw.UpdateWithPayload("reason: revoke alice"); err != nil {
+		t.Fatalf("UpdateWithPayload failed: %v", err)
+	}
+	if len(c.published) != 1 {
+		t.Fatalf("Expected one published message, published %v", c.published)
+	}
+	msg := decodeMessage("/casbin", []byte(c.published[0]))
+	if msg.LocalID != "node1" || msg.Payload != "reason: revoke alice" {
+		t.Errorf("Expected the payload from node1, received '%s' from '%s'", msg.Payload, msg.LocalID)
+	}
+
+	var received []string
+	w.SetUpdateCallback(func(msg string) { received = append(received, msg) })
+	msg.LocalID = "node2"
+	rw.processMessage(msg)
+	if len(received) != 1 || received[0] != "reason: revoke alice" {
+		t.Errorf("Callback should receive the payload, received %v", received)
+	}
+}
+
+func TestUpdateWithResult(t *testing.T) {
+	c := NewTestConn()
+	c.Clear()
+	c.Command("PUBLISH", "/casbin", "node1").Expect(int64(0))
+	var receivers int64 = -1
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		RecordMetrics(func(m *WatcherMetrics) {
+			if m.Name == PubSubPublishMetric {
+				receivers = m.Receivers
+			}
+		}))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	rw := w.(*Watcher)
+
+	n, err := rw.UpdateWithResult()
+	if err != nil {
+		t.Fatalf("UpdateWithResult failed: %v", err)
+	}
+	if n != 0 || receivers != 0 {
+		t.Errorf("Expected zero receivers, got %d and %d in the metric", n, receivers)
+	}
+
+	c.Command("PUBLISH", "/casbin", "node1").Expect(int64(3))
+	if n, _ := rw.UpdateWithResult(); n != 3 {
+		t.Errorf("Expected 3 receivers, got %d", n)
+	}
+}
+
+// flakyConn fails the first PUBLISH commands
+type flakyConn struct {
+	*testConn
+	failures  int
+	publishes int
+	published []string
+}
+
+func (c *flakyConn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	if commandName == "PUBLISH" {
+		c.publishes++
+		if c.publishes <= c.failures {
+			return nil, errors.New("connection reset")
+		}
+		c.published = append(c.published, args[1].(string))
+		return int64(1), nil
+	}
+	return c.testConn.Do(commandName, args...)
+}
+
+func TestPublishRetries(t *testing.T) {
+	c := &flakyConn{testConn: NewTestConn(), failures: 2}
+	c.Clear()
+	w, err := NewPublishWatcher("", WithRedisSubConnection(c), WithRedisPubConnection(c), LocalID("node1"),
+		WithLogger(&testLogger{}), PublishRetries(2, time.Millisecond))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+
+	if err := w.Update(); err != nil {
+		t.Fatalf("Update should succeed on the last retry: %v", err)
+	}
+	if c.publishes != 3 {
+		t.Errorf("Expected 3 publish attempts, got %d", c.publishes)
+	}
+
+	c.publishes, c.failures = 0, 5
+	if err := w.Update(); err == nil {
+		t.Error("Update should fail once the retries are exhausted")
+	}
+	if c.publishes != 3 {
+		t.Errorf("Expected 3 publish attempts, got %d", c.publishes)
+	}
+}